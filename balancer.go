@@ -0,0 +1,478 @@
+package socketgo
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BalanceStrategy 多节点客户端选择节点的策略
+type BalanceStrategy int
+
+const (
+	BalanceRoundRobin     BalanceStrategy = iota // 轮询
+	BalanceLeastPending                          // 等待应答的 Call 最少的节点
+	BalanceConsistentHash                        // 按 key 一致性哈希, 节点不可用时顺延到下一个可用节点
+)
+
+const (
+	DefaultHeartbeatInterval = 10 * time.Second
+	DefaultMinBackoff        = 100 * time.Millisecond
+	DefaultMaxBackoff        = 30 * time.Second
+
+	hashReplicas       = 128                   // 一致性哈希中每个节点的虚拟节点数
+	drainCheckInterval = 10 * time.Millisecond // 移除节点时检查在途 Call 的间隔
+)
+
+// BalancedConfig 多节点客户端配置
+type BalancedConfig struct {
+	Network         string
+	Strategy        BalanceStrategy
+	SendChanSize    int
+	ReadBufferSize  int
+	WriteBufferSize int
+
+	HeartbeatInterval time.Duration // 心跳间隔, 协议实现了 IPingProtocol 时生效, 0 表示 DefaultHeartbeatInterval
+	HeartbeatTimeout  time.Duration // 心跳超时, 0 表示 DefaultPingTimeout
+	MinBackoff        time.Duration // 重连的初始等待时间, 0 表示 DefaultMinBackoff
+	MaxBackoff        time.Duration // 重连的最长等待时间, 0 表示 DefaultMaxBackoff
+	DrainTimeout      time.Duration // 节点移除后等待在途 Call 完成的最长时间, 0 表示 DefaultDrainTimeout
+
+	Dialer Dialer // 建立连接的方法, nil 表示 net.Dial
+	Clock  Clock  // 心跳、退避与会话使用的时钟, nil 表示 RealClock
+}
+
+// EndpointStatus 节点状态
+type EndpointStatus struct {
+	Address  string
+	Up       bool
+	Pending  int   // 等待应答的 Call 数量
+	Failures int   // 连续连接失败的次数
+	LastErr  error // 最近一次连接或心跳失败的原因
+}
+
+// endpoint 单个服务节点
+type endpoint struct {
+	addr       string
+	client     *AsyncClient // 节点可用时非 nil
+	failures   int
+	lastErr    error
+	stopedChan chan struct{} // 节点被移除或客户端关闭
+}
+
+// BalancedClient 连接多个服务节点的异步客户端, 按策略把 Send/Call 分发到可用的节点,
+// 节点连接失败或心跳失败时标记为不可用, 并按指数退避重连。
+type BalancedClient struct {
+	config     BalancedConfig
+	protocol   IPacketProtocol
+	dispatcher IDispatcher
+
+	lock      sync.RWMutex
+	endpoints []*endpoint
+	ring      []hashNode // 一致性哈希环, 按 hash 排序
+	next      uint32     // 轮询计数
+
+	cancelResolve context.CancelFunc // 使用 Resolver 时停止监视节点变化
+
+	once       sync.Once
+	stopedChan chan struct{}
+}
+
+type hashNode struct {
+	hash uint32
+	ep   *endpoint
+}
+
+// NewBalancedClient 新建多节点客户端并在后台连接所有节点, 所有节点共用同一个协议与事件分发器
+func NewBalancedClient(addrs []string, protocol IPacketProtocol, dispatcher IDispatcher, config BalancedConfig) *BalancedClient {
+	if config.Network == "" {
+		config.Network = "tcp"
+	}
+	if config.HeartbeatInterval <= 0 {
+		config.HeartbeatInterval = DefaultHeartbeatInterval
+	}
+	if config.HeartbeatTimeout <= 0 {
+		config.HeartbeatTimeout = DefaultPingTimeout
+	}
+	if config.MinBackoff <= 0 {
+		config.MinBackoff = DefaultMinBackoff
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = DefaultMaxBackoff
+	}
+	if config.DrainTimeout <= 0 {
+		config.DrainTimeout = DefaultDrainTimeout
+	}
+	config.Clock = clockOrDefault(config.Clock)
+
+	b := &BalancedClient{
+		config:     config,
+		protocol:   protocol,
+		dispatcher: dispatcher,
+		stopedChan: make(chan struct{}),
+	}
+
+	b.lock.Lock()
+	for _, addr := range addrs {
+		b.addEndpoint(addr)
+	}
+	b.rebuildRing()
+	b.lock.Unlock()
+
+	return b
+}
+
+// NewBalancedClientWithResolver 新建多节点客户端, 节点由 resolver 提供并随其变化自动增删
+func NewBalancedClientWithResolver(resolver Resolver, protocol IPacketProtocol, dispatcher IDispatcher, config BalancedConfig) *BalancedClient {
+	b := NewBalancedClient(nil, protocol, dispatcher, config)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b.cancelResolve = cancel
+	go resolver.Watch(ctx, b.UpdateEndpoints)
+
+	return b
+}
+
+// UpdateEndpoints 替换节点列表: 新增的节点开始连接, 移除的节点不再分配新的请求,
+// 等待其在途 Call 全部完成(最长 DrainTimeout)后再关闭连接
+func (b *BalancedClient) UpdateEndpoints(addrs []string) {
+	wanted := make(map[string]bool, len(addrs))
+	for _, addr := range addrs {
+		wanted[addr] = true
+	}
+
+	b.lock.Lock()
+	select {
+	case <-b.stopedChan:
+		b.lock.Unlock()
+		return
+	default:
+	}
+
+	var removed []*endpoint
+	kept := b.endpoints[:0]
+	for _, ep := range b.endpoints {
+		if wanted[ep.addr] {
+			delete(wanted, ep.addr)
+			kept = append(kept, ep)
+		} else {
+			removed = append(removed, ep)
+		}
+	}
+	for i := len(kept); i < len(b.endpoints); i++ {
+		b.endpoints[i] = nil
+	}
+	b.endpoints = kept
+
+	for _, addr := range addrs {
+		if wanted[addr] {
+			delete(wanted, addr)
+			b.addEndpoint(addr)
+		}
+	}
+	b.rebuildRing()
+	b.lock.Unlock()
+
+	for _, ep := range removed {
+		go b.drainEndpoint(ep)
+	}
+}
+
+// Send 选择可用节点发送封包, key 仅在一致性哈希策略下使用。
+// 连接已断开但尚未被标记为不可用的节点会被跳过; 封包一旦交给某个节点, 出错时直接返回,
+// 不会再发给其它节点, 因为它可能已经写出, 重发会使对端处理两次。
+func (b *BalancedClient) Send(key string, packet interface{}) error {
+	var err error = ErrNoEndpoint
+
+	for _, client := range b.pick(key) {
+		if err = client.session.Err(); err != nil {
+			continue
+		}
+		return client.Send(packet)
+	}
+
+	return err
+}
+
+// Call 选择可用节点发送请求并等待应答, 见 AsyncClient.Call。
+// 与 Send 相同, 只跳过连接已断开的节点, 请求发出后的错误不会转到其它节点重试。
+func (b *BalancedClient) Call(ctx context.Context, key string, req interface{}, match FnMatchPacket) (interface{}, error) {
+	var err error = ErrNoEndpoint
+
+	for _, client := range b.pick(key) {
+		if err = client.session.Err(); err != nil {
+			continue
+		}
+		return client.Call(ctx, req, match)
+	}
+
+	return nil, err
+}
+
+// Endpoints 获取所有节点的状态
+func (b *BalancedClient) Endpoints() []EndpointStatus {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	status := make([]EndpointStatus, 0, len(b.endpoints))
+	for _, ep := range b.endpoints {
+		st := EndpointStatus{Address: ep.addr, Up: ep.client != nil, Failures: ep.failures, LastErr: ep.lastErr}
+		if ep.client != nil {
+			st.Pending = ep.client.Pending()
+		}
+		status = append(status, st)
+	}
+
+	return status
+}
+
+// Close 关闭所有节点的连接
+func (b *BalancedClient) Close() {
+	b.once.Do(func() {
+		if b.cancelResolve != nil {
+			b.cancelResolve()
+		}
+		close(b.stopedChan)
+
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		for _, ep := range b.endpoints {
+			if ep.client != nil {
+				ep.client.Close()
+			}
+		}
+	})
+}
+
+// pick 按策略返回可用节点的客户端, 第一个为首选, 其余为发送失败时的备选
+func (b *BalancedClient) pick(key string) []*AsyncClient {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	var clients []*AsyncClient
+
+	switch b.config.Strategy {
+	case BalanceConsistentHash:
+		if len(b.ring) == 0 {
+			return nil
+		}
+
+		hash := crc32.ChecksumIEEE([]byte(key))
+		start := sort.Search(len(b.ring), func(i int) bool { return b.ring[i].hash >= hash })
+		seen := make(map[*endpoint]bool, len(b.endpoints))
+		for i := 0; i < len(b.ring) && len(seen) < len(b.endpoints); i++ {
+			ep := b.ring[(start+i)%len(b.ring)].ep
+			if seen[ep] {
+				continue
+			}
+			seen[ep] = true
+			if ep.client != nil {
+				clients = append(clients, ep.client)
+			}
+		}
+	case BalanceLeastPending:
+		for _, ep := range b.endpoints {
+			if ep.client != nil {
+				clients = append(clients, ep.client)
+			}
+		}
+		sort.SliceStable(clients, func(i, j int) bool { return clients[i].Pending() < clients[j].Pending() })
+	default:
+		for _, ep := range b.endpoints {
+			if ep.client != nil {
+				clients = append(clients, ep.client)
+			}
+		}
+		if n := len(clients); n > 1 {
+			offset := int(atomic.AddUint32(&b.next, 1) % uint32(n))
+			clients = append(clients[offset:], clients[:offset]...)
+		}
+	}
+
+	return clients
+}
+
+// addEndpoint 添加节点并启动连接, 调用方需持有锁
+func (b *BalancedClient) addEndpoint(addr string) *endpoint {
+	ep := &endpoint{addr: addr, stopedChan: make(chan struct{})}
+	b.endpoints = append(b.endpoints, ep)
+	go b.runEndpoint(ep)
+
+	return ep
+}
+
+// drainEndpoint 等待已移除节点的在途 Call 完成后停止该节点, runEndpoint 随之关闭连接
+func (b *BalancedClient) drainEndpoint(ep *endpoint) {
+	defer close(ep.stopedChan)
+
+	deadline := b.config.Clock.NewTimer(b.config.DrainTimeout)
+	defer deadline.Stop()
+	ticker := b.config.Clock.NewTicker(drainCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		b.lock.RLock()
+		client := ep.client
+		b.lock.RUnlock()
+
+		if client == nil || client.Pending() == 0 {
+			return
+		}
+
+		select {
+		case <-ticker.C():
+		case <-deadline.C():
+			fmt.Printf("节点 %s 移除时仍有 %d 个 Call 未完成\n", ep.addr, client.Pending())
+			return
+		case <-b.stopedChan:
+			return
+		}
+	}
+}
+
+// rebuildRing 节点变化后重建一致性哈希环, 调用方需持有锁
+func (b *BalancedClient) rebuildRing() {
+	b.ring = b.ring[:0]
+	for _, ep := range b.endpoints {
+		for i := 0; i < hashReplicas; i++ {
+			hash := crc32.ChecksumIEEE([]byte(ep.addr + "#" + strconv.Itoa(i)))
+			b.ring = append(b.ring, hashNode{hash: hash, ep: ep})
+		}
+	}
+
+	sort.Slice(b.ring, func(i, j int) bool { return b.ring[i].hash < b.ring[j].hash })
+}
+
+// runEndpoint 维持单个节点的连接: 连接失败、断开或心跳失败后按指数退避重连。
+// 连接稳定运行满一个心跳周期后才重置退避, 否则接受后立即断开的服务端会导致不停重连。
+func (b *BalancedClient) runEndpoint(ep *endpoint) {
+	backoff := b.config.MinBackoff
+
+	for {
+		client, err := b.connect(ep)
+		if err != nil {
+			b.markDown(ep, nil, err)
+			fmt.Printf("连接 %s 失败, %v 后重试: %v\n", ep.addr, backoff, err)
+		} else {
+			connectedAt := b.config.Clock.Now()
+			err = b.watch(ep, client)
+			b.markDown(ep, client, err)
+
+			if b.config.Clock.Since(connectedAt) >= b.config.HeartbeatInterval {
+				backoff = b.config.MinBackoff
+			}
+		}
+
+		if !b.waitBackoff(ep, backoff) {
+			return
+		}
+
+		if backoff *= 2; backoff > b.config.MaxBackoff {
+			backoff = b.config.MaxBackoff
+		}
+	}
+}
+
+// waitBackoff 等待 d 后返回 true, 期间节点被移除或客户端关闭时返回 false
+func (b *BalancedClient) waitBackoff(ep *endpoint, d time.Duration) bool {
+	timer := b.config.Clock.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C():
+		return true
+	case <-ep.stopedChan:
+		return false
+	case <-b.stopedChan:
+		return false
+	}
+}
+
+func (b *BalancedClient) connect(ep *endpoint) (*AsyncClient, error) {
+	client := NewAsyncClient(b.protocol, b.dispatcher, b.config.SendChanSize)
+	client.SetDialer(b.config.Dialer)
+	client.SetClock(b.config.Clock)
+	err := client.Conn(b.config.Network, ep.addr, b.config.ReadBufferSize, b.config.WriteBufferSize, nil, nil)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	select {
+	case <-ep.stopedChan:
+	case <-b.stopedChan:
+	default:
+		ep.client = client
+		ep.failures = 0
+		return client, nil
+	}
+
+	// 连接期间节点已被移除或客户端已关闭
+	client.Close()
+	return nil, ErrSessionClosed
+}
+
+// watch 定期发送心跳, 直到连接断开、心跳失败或节点被移除, 返回断开的原因
+func (b *BalancedClient) watch(ep *endpoint, client *AsyncClient) error {
+	ticker := b.config.Clock.NewTicker(b.config.HeartbeatInterval)
+	defer ticker.Stop()
+
+	session := client.GetSession()
+	for {
+		select {
+		case <-session.Done():
+			return session.Err()
+		case <-ep.stopedChan:
+			return nil
+		case <-b.stopedChan:
+			return nil
+		case <-ticker.C():
+			if err := b.heartbeat(client); err != nil {
+				fmt.Printf("节点 %s 心跳失败: %v\n", ep.addr, err)
+				return err
+			}
+		}
+	}
+}
+
+func (b *BalancedClient) heartbeat(client *AsyncClient) error {
+	pinger, ok := b.protocol.(IPingProtocol)
+	if !ok {
+		return nil
+	}
+
+	ctx, cancel := contextWithTimeout(b.config.Clock, context.Background(), b.config.HeartbeatTimeout)
+	defer cancel()
+
+	resp, err := client.Call(ctx, pinger.PingPacket(), pinger.IsPong)
+	if releaser, ok := resp.(IReleaser); ok {
+		releaser.Release()
+	}
+
+	return err
+}
+
+// markDown 标记节点不可用并关闭连接
+func (b *BalancedClient) markDown(ep *endpoint, client *AsyncClient, err error) {
+	b.lock.Lock()
+	if ep.client == client {
+		ep.client = nil
+	}
+	if err != nil {
+		ep.failures++
+		ep.lastErr = err
+	}
+	b.lock.Unlock()
+
+	if client != nil {
+		client.Close()
+	}
+}
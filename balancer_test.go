@@ -0,0 +1,79 @@
+package socketgo_test
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	socket "github.com/datochan/socketgo"
+	"github.com/datochan/socketgo/socketgotest"
+)
+
+// waitUp 等待唯一节点的连接状态变为 up
+func waitUp(t *testing.T, b *socket.BalancedClient, up bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if endpoints := b.Endpoints(); len(endpoints) == 1 && endpoints[0].Up == up {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("endpoint Up never became %v", up)
+}
+
+func TestBalancerReconnectBackoff(t *testing.T) {
+	const heartbeat = time.Hour
+
+	clock := socketgotest.NewVirtualClock(time.Unix(0, 0))
+	remotes := make(chan net.Conn, 1)
+	dialer := func(string, string) (net.Conn, error) {
+		local, remote := net.Pipe()
+		remotes <- remote
+		return local, nil
+	}
+
+	protocol := &socket.LengthFieldProtocol{HeaderSize: 8, LengthOffset: 4, LengthSize: 4, ByteOrder: binary.LittleEndian}
+	b := socket.NewBalancedClient([]string{"node"}, protocol, socket.NewDispatcher(), socket.BalancedConfig{
+		HeartbeatInterval: heartbeat,
+		MinBackoff:        time.Second,
+		MaxBackoff:        4 * time.Second,
+		Dialer:            dialer,
+		Clock:             clock,
+	})
+	defer b.Close()
+
+	// 服务端每次接受后立即断开, 重连间隔逐次翻倍; 连接稳定满一个心跳周期后退避重置
+	for _, round := range []struct {
+		healthy bool
+		backoff time.Duration
+	}{{false, time.Second}, {false, 2 * time.Second}, {false, 4 * time.Second}, {false, 4 * time.Second}, {true, time.Second}} {
+		remote := <-remotes
+		waitUp(t, b, true)
+		if round.healthy {
+			clock.Advance(heartbeat)
+		}
+		_ = remote.Close()
+		waitUp(t, b, false)
+
+		// 断开后只剩退避的定时器, 提前 1ms 不应重连
+		clock.BlockUntil(1)
+		clock.Advance(round.backoff - time.Millisecond)
+		select {
+		case <-remotes:
+			t.Fatalf("reconnected before the %v backoff", round.backoff)
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		clock.Advance(time.Millisecond)
+	}
+
+	select {
+	case remote := <-remotes:
+		_ = remote.Close()
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reconnect after the backoff")
+	}
+}
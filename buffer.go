@@ -0,0 +1,112 @@
+package socketgo
+
+import (
+	"math/bits"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	minBufferClass = 6  // 最小的池化缓冲区 64B
+	maxBufferClass = 20 // 最大的池化缓冲区 1MB, 更大的直接分配
+
+	defaultBuildSize = 512 // 组包时预取的缓冲区大小
+)
+
+// bufferPools 按 2 的幂次分级的缓冲区池
+var bufferPools [maxBufferClass + 1]sync.Pool
+
+// IReleaser 可归还的封包, recvLoop 在 handler 返回后调用 Release
+type IReleaser interface {
+	Release()
+}
+
+// IPacketAppender 可选接口, 协议实现后组包时直接写入池化的缓冲区, 避免每个封包分配内存
+type IPacketAppender interface {
+	// AppendPacket 将组好的封包追加到 dst 之后并返回
+	AppendPacket(dst []byte, packet interface{}) []byte
+}
+
+// IPacketChecker 可选接口, 协议实现后组包前先检查封包, 不合法的封包发送失败, 而不是组出错误的数据或 panic
+type IPacketChecker interface {
+	CheckPacket(packet interface{}) error
+}
+
+// Buffer 由 sync.Pool 管理的缓冲区。
+// 生命周期约定: 交给 handler 的 Buffer 在 handler 返回后即被回收,
+// 如需在 handler 之外继续使用, 须先调用 Retain, 用完后再调用 Release。
+type Buffer struct {
+	B    []byte
+	refs int32
+}
+
+// GetBuffer 从池中获取长度为 size 的缓冲区, 内容未清零
+func GetBuffer(size int) *Buffer {
+	class := bufferClass(size)
+	if class > maxBufferClass {
+		return &Buffer{B: make([]byte, size), refs: 1}
+	}
+
+	if v := bufferPools[class].Get(); v != nil {
+		buf := v.(*Buffer)
+		buf.B = buf.B[:size]
+		buf.refs = 1
+		return buf
+	}
+
+	return &Buffer{B: make([]byte, size, 1<<class), refs: 1}
+}
+
+// Retain 增加引用计数
+func (b *Buffer) Retain() {
+	atomic.AddInt32(&b.refs, 1)
+}
+
+// Release 减少引用计数, 归零后放回池中, 之后不得再访问 B
+func (b *Buffer) Release() {
+	if atomic.AddInt32(&b.refs, -1) != 0 {
+		return
+	}
+
+	// 按容量向下取整归类, AppendPacket 扩容后的缓冲区同样可以复用
+	class := bits.Len(uint(cap(b.B))) - 1
+	if class < minBufferClass || class > maxBufferClass {
+		return
+	}
+
+	b.B = b.B[:0]
+	bufferPools[class].Put(b)
+}
+
+// bufferClass 能容纳 size 字节的最小分级
+func bufferClass(size int) int {
+	if size <= 1<<minBufferClass {
+		return minBufferClass
+	}
+
+	return bits.Len(uint(size - 1))
+}
+
+// buildPacket 组包, 协议支持 IPacketAppender 时使用池化缓冲区, 返回的 Buffer 需在写出后释放;
+// 协议实现 IPacketChecker 时先检查封包, 不合法时返回其错误
+func buildPacket(protocol IPacketProtocol, packet interface{}) ([]byte, *Buffer, error) {
+	if encoded, ok := packet.(EncodedPacket); ok {
+		return encoded, nil, nil
+	}
+
+	if checker, ok := protocol.(IPacketChecker); ok {
+		if err := checker.CheckPacket(packet); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	appender, ok := protocol.(IPacketAppender)
+	if !ok {
+		return protocol.BuildPacket(packet), nil, nil
+	}
+
+	buf := GetBuffer(defaultBuildSize)
+	buf.B = appender.AppendPacket(buf.B[:0], packet)
+
+	return buf.B, buf, nil
+}
@@ -1,54 +1,116 @@
 package socketgo
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net"
 	"os"
 	"os/signal"
+	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 )
 
+// Dialer 建立连接的方法, 默认为 net.Dial
+type Dialer func(network, address string) (net.Conn, error)
+
+// FnMatchPacket 判断收到的封包是否为请求的应答
+type FnMatchPacket func(resp interface{}) bool
+
 type Client struct {
 	conn       net.Conn
 	protocol   IPacketProtocol
-	stopedChan <-chan os.Signal
+	dialer     Dialer
+	stopedChan chan os.Signal
+
+	callLock    sync.Mutex        // Call 与 Recv 互斥, 避免并发读取同一连接
+	pending     []interface{}     // Call 期间收到的非应答封包, 由之后的 Recv 依次返回
+	pushHandler func(interface{}) // 设置后非应答封包交给它处理, 不再缓存
+	broken      atomic.Bool       // Call 的读写被打断, 连接中可能残留半个封包, 需重新 Conn
 }
 
 func NewClient(protocol IPacketProtocol) *Client {
-	stopSignal := make(chan os.Signal) // 接收系统中断信号
-	var shutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM, syscall.SIGINT}
-	signal.Notify(stopSignal, shutdownSignals...)
-
 	return &Client{
-		stopedChan: stopSignal,
+		stopedChan: notifyShutdown(),
 		protocol:   protocol,
 	}
 }
 
+// notifyShutdown 接收系统中断信号
+func notifyShutdown() chan os.Signal {
+	stopSignal := make(chan os.Signal, 1)
+	var shutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM, syscall.SIGINT}
+	signal.Notify(stopSignal, shutdownSignals...)
+
+	return stopSignal
+}
+
+// SetDialer 替换建立连接的方法, 如通过 Mux.OpenStream 在已有连接上新建流
+func (c *Client) SetDialer(dialer Dialer) {
+	c.dialer = dialer
+}
+
+// Conn 建立连接, 重连时关闭旧连接并丢弃其上缓存的封包
 func (c *Client) Conn(network, address string, readBufferSize, writeBufferSize int) error {
-	conn, err := net.Dial(network, address)
+	dial := c.dialer
+	if dial == nil {
+		dial = net.Dial
+	}
+
+	conn, err := dial(network, address)
 	if err != nil {
-		return nil
+		return err
+	}
+
+	// 先关闭旧连接, 打断其上阻塞中的 Call 或 Recv
+	if c.conn != nil {
+		_ = c.conn.Close()
 	}
 
+	// 旧连接上缓存的封包不属于新连接
+	c.callLock.Lock()
+	for _, packet := range c.pending {
+		if releaser, ok := packet.(IReleaser); ok {
+			releaser.Release()
+		}
+	}
+	c.pending = nil
 	c.conn = conn
+	c.broken.Store(false)
+	c.callLock.Unlock()
 
-	tcpConn := conn.(*net.TCPConn)
-	_ = tcpConn.SetNoDelay(true)
-	_ = tcpConn.SetReadBuffer(readBufferSize)
-	_ = tcpConn.SetWriteBuffer(writeBufferSize)
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		_ = tcpConn.SetNoDelay(true)
+		_ = tcpConn.SetReadBuffer(readBufferSize)
+		_ = tcpConn.SetWriteBuffer(writeBufferSize)
+	}
 
 	return nil
 }
 
 func (c *Client) Send(packet interface{}) error {
+	if c.broken.Load() {
+		return ErrClientBroken
+	}
+
 	select {
 	case <-c.stopedChan:
 		return ErrSignalStopped
 	default:
-		content := c.protocol.BuildPacket(packet)
-		err := c.protocol.SendPacket(c.conn, content)
+		// 未通过检查的封包没有写出任何数据, 连接仍可使用
+		content, pooled, err := buildPacket(c.protocol, packet)
 		if err != nil {
-			return ErrWritePacketFailed
+			return err
+		}
+
+		err = c.protocol.SendPacket(c.conn, content)
+		if pooled != nil {
+			pooled.Release()
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrWritePacketFailed, err)
 		}
 	}
 
@@ -56,34 +118,149 @@ func (c *Client) Send(packet interface{}) error {
 }
 
 func (c *Client) Recv() (interface{}, error) {
+	c.callLock.Lock()
+	defer c.callLock.Unlock()
+
+	if len(c.pending) > 0 {
+		packet := c.pending[0]
+		c.pending[0] = nil
+		c.pending = c.pending[1:]
+		return packet, nil
+	}
+
+	if c.broken.Load() {
+		return nil, ErrClientBroken
+	}
+
 	packet, err := c.protocol.ReadPacket(c.conn)
 	if err != nil {
-		return nil, ErrReadPacketFailed
+		return nil, fmt.Errorf("%w: %w", ErrReadPacketFailed, err)
 	}
 
 	return packet, nil
 }
 
+// SetPushHandler 设置 Call 期间收到的非应答封包(如服务端推送)的处理句柄,
+// 未设置时这些封包被缓存, 由之后的 Recv 依次返回
+func (c *Client) SetPushHandler(handler func(packet interface{})) {
+	c.callLock.Lock()
+	c.pushHandler = handler
+	c.callLock.Unlock()
+}
+
+// Call 同步请求: 发送 req 后持续读取, 直到收到 match 返回 true 的应答。
+// ctx 的截止时间会设置为连接的读写超时, ctx 被取消时正在进行的读写立即返回;
+// ctx 携带的跟踪ID在协议实现 ITraceCarrier 时写入请求。
+// 读写出错(包括 ctx 超时)时连接中可能残留半个封包, 此时连接被关闭,
+// 之后的 Send、Recv、Call 返回 ErrClientBroken, 需重新调用 Conn。
+func (c *Client) Call(ctx context.Context, req interface{}, match FnMatchPacket) (interface{}, error) {
+	c.callLock.Lock()
+	defer c.callLock.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if c.broken.Load() {
+		return nil, ErrClientBroken
+	}
+
+	deadline, _ := ctx.Deadline()
+	_ = c.conn.SetDeadline(deadline)
+
+	// ctx 被取消时通过过期的截止时间打断阻塞中的读写
+	interrupted := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		_ = c.conn.SetDeadline(time.Unix(1, 0))
+		close(interrupted)
+	})
+	defer func() {
+		if !stop() {
+			<-interrupted
+		}
+		_ = c.conn.SetDeadline(time.Time{})
+	}()
+
+	if err := c.Send(injectTrace(ctx, c.protocol, req)); err != nil {
+		if errors.Is(err, ErrWritePacketFailed) {
+			c.markBroken()
+		}
+		return nil, contextError(ctx, err, err)
+	}
+
+	for {
+		packet, err := c.protocol.ReadPacket(c.conn)
+		if err != nil {
+			c.markBroken()
+			return nil, contextError(ctx, err, fmt.Errorf("%w: %w", ErrReadPacketFailed, err))
+		}
+
+		if match(packet) {
+			return packet, nil
+		}
+
+		if c.pushHandler != nil {
+			c.pushHandler(packet)
+		} else {
+			c.pending = append(c.pending, packet)
+		}
+	}
+}
+
+// Close 关闭连接, 停止接收系统中断信号
+func (c *Client) Close() error {
+	signal.Stop(c.stopedChan)
+
+	if c.conn == nil {
+		return nil
+	}
+
+	return c.conn.Close()
+}
+
+// markBroken 读写被打断后无法再确定帧边界, 关闭连接
+func (c *Client) markBroken() {
+	c.broken.Store(true)
+	_ = c.conn.Close()
+}
+
+// contextError ctx 已结束或读写因 ctx 的截止时间超时时返回 ctx 的错误, 否则返回 err
+func contextError(ctx context.Context, cause, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+
+	if errors.Is(cause, os.ErrDeadlineExceeded) {
+		return context.DeadlineExceeded
+	}
+
+	return err
+}
+
 // AsyncClient 异步通讯客户端
 type AsyncClient struct {
 	Client
-	sendChanSize int
-	session      ISession
-	dispatcher   IDispatcher
+	sessionConfig
+	session    ISession
+	dispatcher IDispatcher
+
+	waiterLock sync.Mutex
+	waiters    []*callWaiter // 等待应答的 Call
 }
 
-func NewAsyncClient(protocol IPacketProtocol, dispatcher IDispatcher, bufferSize int) *AsyncClient {
-	stopSignal := make(chan os.Signal) // 接收系统中断信号
-	var shutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM, syscall.SIGINT}
-	signal.Notify(stopSignal, shutdownSignals...)
+// callWaiter 等待应答的 Call
+type callWaiter struct {
+	match FnMatchPacket
+	resp  chan interface{}
+}
 
+func NewAsyncClient(protocol IPacketProtocol, dispatcher IDispatcher, bufferSize int) *AsyncClient {
 	return &AsyncClient{
 		Client: Client{
-			stopedChan: stopSignal,
+			stopedChan: notifyShutdown(),
 			protocol:   protocol,
 		},
-		sendChanSize: bufferSize,
-		dispatcher:   dispatcher,
+		sessionConfig: sessionConfig{sendChanSize: bufferSize},
+		dispatcher:    dispatcher,
 	}
 }
 
@@ -99,6 +276,8 @@ func (c *AsyncClient) GetSession() ISession {
 
 // Close 关闭连接
 func (c *AsyncClient) Close() {
+	signal.Stop(c.stopedChan)
+
 	if nil != c.session {
 		_ = c.session.Close()
 	}
@@ -113,10 +292,10 @@ func (c *AsyncClient) Conn(network, address string,
 
 	err := c.Client.Conn(network, address, readBufferSize, writeBufferSize)
 	if err != nil {
-		return nil
+		return err
 	}
 
-	c.session = NewSession(c.conn, c.protocol, c.dispatcher.HandleProc, c.sendChanSize)
+	c.session = c.newSession(c.conn, c.protocol, c.handlePacket)
 
 	if callbackSend != nil {
 		c.session.SetSendCallback(callbackSend)
@@ -131,12 +310,120 @@ func (c *AsyncClient) Conn(network, address string,
 	return nil
 }
 
+// SetSendPolicy 设置发送队列已满时的处理策略, 已建立的连接同样生效
+func (c *AsyncClient) SetSendPolicy(policy SendPolicy) {
+	c.sessionConfig.SetSendPolicy(policy)
+	if nil != c.session {
+		c.session.SetSendPolicy(policy)
+	}
+}
+
 func (c *AsyncClient) Send(packet interface{}) error {
 	return c.session.Send(packet)
 }
 
+// SendContext 阻塞发送, 直到封包进入发送队列、连接关闭或 ctx 结束
+func (c *AsyncClient) SendContext(ctx context.Context, packet interface{}) error {
+	return c.session.SendContext(ctx, packet)
+}
+
+// SendPriority 以指定优先级发送
+func (c *AsyncClient) SendPriority(packet interface{}, priority Priority) error {
+	return c.session.SendPriority(packet, priority)
+}
+
+// SendAsync 异步发送, 返回的管道在封包写入连接后收到发送结果
+func (c *AsyncClient) SendAsync(packet interface{}) <-chan SendResult {
+	return c.session.SendAsync(packet)
+}
+
+// Call 发送请求并等待 match 返回 true 的应答, 其余封包照常交给事件分发器。
+// 应答若实现了 IReleaser(如 *Buffer), 调用方用完后需调用 Release。
+// ctx 携带的跟踪ID随请求发出, 见 Session.SendContext。
+func (c *AsyncClient) Call(ctx context.Context, req interface{}, match FnMatchPacket) (interface{}, error) {
+	session := c.session
+	if session == nil {
+		return nil, ErrSessionClosed
+	}
+
+	waiter := &callWaiter{match: match, resp: make(chan interface{}, 1)}
+	c.waiterLock.Lock()
+	c.waiters = append(c.waiters, waiter)
+	c.waiterLock.Unlock()
+
+	if err := session.SendContext(ctx, req); err != nil {
+		c.abandonWaiter(waiter)
+		return nil, err
+	}
+
+	select {
+	case resp := <-waiter.resp:
+		return resp, nil
+	case <-session.Done():
+		c.abandonWaiter(waiter)
+		return nil, session.Err()
+	case <-ctx.Done():
+		c.abandonWaiter(waiter)
+		return nil, ctx.Err()
+	}
+}
+
+// abandonWaiter Call 不再等待应答。应答可能已被 handlePacket 取走并 Retain,
+// 此时它一定会被放入 waiter.resp, 取出后归还。
+func (c *AsyncClient) abandonWaiter(waiter *callWaiter) {
+	if c.removeWaiter(waiter) {
+		return
+	}
+
+	if releaser, ok := (<-waiter.resp).(IReleaser); ok {
+		releaser.Release()
+	}
+}
+
+// Pending 正在等待应答的 Call 数量
+func (c *AsyncClient) Pending() int {
+	c.waiterLock.Lock()
+	defer c.waiterLock.Unlock()
+	return len(c.waiters)
+}
+
+// removeWaiter 移除等待中的 Call, 已被 handlePacket 匹配时返回 false
+func (c *AsyncClient) removeWaiter(waiter *callWaiter) bool {
+	c.waiterLock.Lock()
+	defer c.waiterLock.Unlock()
+
+	for i, w := range c.waiters {
+		if w == waiter {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// handlePacket 应答优先交给等待中的 Call, 其余封包交给事件分发器
+func (c *AsyncClient) handlePacket(session ISession, packet interface{}) {
+	c.waiterLock.Lock()
+	for i, w := range c.waiters {
+		if w.match(packet) {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			c.waiterLock.Unlock()
+
+			// 应答交给调用方, recvLoop 中的 Release 不能归还它
+			if buf, ok := packet.(*Buffer); ok {
+				buf.Retain()
+			}
+			w.resp <- packet
+			return
+		}
+	}
+	c.waiterLock.Unlock()
+
+	c.dispatcher.HandleProc(session, packet)
+}
+
 // Recv 异步通讯的客户端只能注册接收消息的句柄，不能直接收取封包内容
 func (c *AsyncClient) Recv() (interface{}, error) {
 	panic("异步通讯客户端不允许直接读取封包内容")
-	return nil, ErrReadPacketFailed
 }
@@ -0,0 +1,135 @@
+package socketgo
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestClientCallBrokenAfterTimeout(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+
+	client := NewClient(newTestProtocol())
+	client.SetDialer(func(string, string) (net.Conn, error) { return local, nil })
+	if err := client.Conn("pipe", "", 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// 对端读完请求后只回复半个应答
+	go func() {
+		if _, err := newTestProtocol().ReadPacket(remote); err != nil {
+			return
+		}
+		resp := newTestProtocol().BuildPacket(newTestFrame(1, []byte("response")))
+		_, _ = remote.Write(resp[:len(resp)/2])
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	match := func(interface{}) bool { return true }
+	if _, err := client.Call(ctx, newTestFrame(1, nil), match); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Call = %v, want DeadlineExceeded", err)
+	}
+
+	// 剩余的半个应答会被当作下一个封包的开头, 连接不能再使用
+	if _, err := client.Call(context.Background(), newTestFrame(1, nil), match); !errors.Is(err, ErrClientBroken) {
+		t.Fatalf("Call after timeout = %v, want ErrClientBroken", err)
+	}
+	if err := client.Send(newTestFrame(1, nil)); !errors.Is(err, ErrClientBroken) {
+		t.Fatalf("Send after timeout = %v, want ErrClientBroken", err)
+	}
+}
+
+func TestClientCallSkipsBufferedPush(t *testing.T) {
+	protocol := newTestProtocol()
+	local, remote := net.Pipe()
+	defer remote.Close()
+
+	client := NewClient(newTestProtocol())
+	client.SetDialer(func(string, string) (net.Conn, error) { return local, nil })
+	if err := client.Conn("pipe", "", 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// 对端先推送事件1, 再依次以事件2、事件3应答两个请求
+	go func() {
+		for _, replies := range [][]uint32{{1, 2}, {3}} {
+			if _, err := protocol.ReadPacket(remote); err != nil {
+				return
+			}
+			for _, id := range replies {
+				_, _ = remote.Write(protocol.BuildPacket(newTestFrame(id, nil)))
+			}
+		}
+	}()
+
+	matchID := func(id uint32) FnMatchPacket {
+		return func(packet interface{}) bool { return protocol.PacketID(packet) == id }
+	}
+
+	if _, err := client.Call(context.Background(), newTestFrame(1, nil), matchID(2)); err != nil {
+		t.Fatal(err)
+	}
+
+	// 缓存的推送能被 match 匹配, 但 Call 仍需发送请求并读取新的应答
+	resp, err := client.Call(context.Background(), newTestFrame(1, nil), func(interface{}) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := protocol.PacketID(resp); id != 3 {
+		t.Fatalf("Call returned event %d, want 3", id)
+	}
+}
+
+func TestClientReconnectDropsPending(t *testing.T) {
+	protocol := newTestProtocol()
+	oldLocal, oldRemote := net.Pipe()
+	newLocal, newRemote := net.Pipe()
+	defer oldRemote.Close()
+	defer newRemote.Close()
+
+	conns := []net.Conn{oldLocal, newLocal}
+	client := NewClient(newTestProtocol())
+	client.SetDialer(func(string, string) (net.Conn, error) {
+		conn := conns[0]
+		conns = conns[1:]
+		return conn, nil
+	})
+	if err := client.Conn("pipe", "", 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	go func() {
+		if _, err := protocol.ReadPacket(oldRemote); err != nil {
+			return
+		}
+		_, _ = oldRemote.Write(protocol.BuildPacket(newTestFrame(1, nil)))
+		_, _ = oldRemote.Write(protocol.BuildPacket(newTestFrame(2, nil)))
+	}()
+	if _, err := client.Call(context.Background(), newTestFrame(1, nil), func(p interface{}) bool { return protocol.PacketID(p) == 2 }); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.Conn("pipe", "", 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := oldRemote.Write([]byte{0}); err == nil {
+		t.Fatal("old connection still open after reconnect")
+	}
+
+	go func() { _, _ = newRemote.Write(protocol.BuildPacket(newTestFrame(3, nil))) }()
+	packet, err := client.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := protocol.PacketID(packet); id != 3 {
+		t.Fatalf("Recv returned event %d from the old connection, want 3", id)
+	}
+}
@@ -0,0 +1,107 @@
+package socketgo
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Clock 时钟接口, Session、Server、Mux、AsyncClient、BalancedClient 与 ClientPool 的超时与定时器都通过它获取,
+// 测试时可替换为虚拟时钟(见 socketgotest.VirtualClock)
+type Clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+	// AfterFunc 在 d 之后于独立的 goroutine 或时钟推进时调用 f
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer 对应 time.Timer
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// Ticker 对应 time.Ticker
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// RealClock 基于系统时间的时钟, 所有组件的默认值
+var RealClock Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                  { return time.Now() }
+func (realClock) Since(t time.Time) time.Duration { return time.Since(t) }
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return realTimer{time.AfterFunc(d, f)}
+}
+
+type realTimer struct{ *time.Timer }
+
+func (t realTimer) C() <-chan time.Time { return t.Timer.C }
+
+type realTicker struct{ *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time { return t.Ticker.C }
+
+// clockOrDefault nil 时返回 RealClock
+func clockOrDefault(clock Clock) Clock {
+	if clock == nil {
+		return RealClock
+	}
+	return clock
+}
+
+// contextWithTimeout 按 clock 计时的 context.WithTimeout, 超时后 ctx.Err() 为 context.DeadlineExceeded
+func contextWithTimeout(clock Clock, parent context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	clock = clockOrDefault(clock)
+	if clock == RealClock {
+		return context.WithTimeout(parent, d)
+	}
+
+	deadline := clock.Now().Add(d)
+	if parentDeadline, ok := parent.Deadline(); ok && parentDeadline.Before(deadline) {
+		deadline = parentDeadline
+	}
+
+	ctx, cancel := context.WithCancelCause(parent)
+	timer := clock.AfterFunc(d, func() { cancel(context.DeadlineExceeded) })
+
+	return &timeoutContext{Context: ctx, deadline: deadline}, func() {
+		timer.Stop()
+		cancel(context.Canceled)
+	}
+}
+
+// timeoutContext 超时取消时 Err 返回 context.DeadlineExceeded 而不是 context.Canceled,
+// Deadline 返回按 clock 计算的截止时间, 供 Client.Call 等设置连接的读写截止时间
+type timeoutContext struct {
+	context.Context
+	deadline time.Time
+}
+
+func (c *timeoutContext) Deadline() (time.Time, bool) {
+	return c.deadline, true
+}
+
+func (c *timeoutContext) Err() error {
+	err := c.Context.Err()
+	if err != nil && errors.Is(context.Cause(c.Context), context.DeadlineExceeded) {
+		return context.DeadlineExceeded
+	}
+	return err
+}
@@ -0,0 +1,104 @@
+package socketgo
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"runtime"
+)
+
+// CloseKind 会话关闭原因的分类
+type CloseKind int
+
+const (
+	CloseLocal          CloseKind = iota + 1 // 本端调用 Close
+	ClosePeer                                // 对端关闭连接, 在封包边界读到 EOF
+	CloseReadError                           // 读取连接失败, 如连接重置、读取超时或封包读到一半断开
+	CloseDecodeError                         // ReadPacket 解析封包失败或分片重组失败
+	CloseWriteError                          // 写入连接失败
+	ClosePanic                               // handler 或组包时发生 panic
+	CloseKicked                              // 被服务端主动踢出
+	CloseServerShutdown                      // 服务器关闭
+	CloseSlowConsumer                        // 发送队列已满且策略为 SendPolicyCloseSlow
+)
+
+var closeKindNames = map[CloseKind]string{
+	CloseLocal:          "local close",
+	ClosePeer:           "peer closed",
+	CloseReadError:      "read error",
+	CloseDecodeError:    "decode error",
+	CloseWriteError:     "write error",
+	ClosePanic:          "panic",
+	CloseKicked:         "kicked",
+	CloseServerShutdown: "server shutdown",
+	CloseSlowConsumer:   "slow consumer",
+}
+
+func (k CloseKind) String() string {
+	if name, ok := closeKindNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("CloseKind(%d)", int(k))
+}
+
+// CloseReason 会话关闭的原因, Err 为导致关闭的底层错误(可能为 nil)。
+// errors.Is(reason, ErrSessionClosed) 恒成立, 底层错误可通过 errors.Is / errors.As 取得。
+type CloseReason struct {
+	Kind CloseKind
+	Err  error
+}
+
+func (r *CloseReason) Error() string {
+	if r.Err == nil {
+		return "socket: session closed: " + r.Kind.String()
+	}
+	return "socket: session closed: " + r.Kind.String() + ": " + r.Err.Error()
+}
+
+func (r *CloseReason) Unwrap() error {
+	return r.Err
+}
+
+// Is 使会话关闭后返回的 CloseReason 与 ErrSessionClosed 兼容
+func (r *CloseReason) Is(target error) bool {
+	return target == ErrSessionClosed
+}
+
+// PanicError handler 或组包时发生的 panic, 作为 ClosePanic 的底层错误
+type PanicError struct {
+	Value interface{} // recover 得到的值
+	Stack []byte      // 发生 panic 时的调用栈
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("socket: panic: %v", e.Value)
+}
+
+// Unwrap panic 的值本身是 error 时返回它
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// readCloseKind 根据 ReadPacket 返回的错误判断关闭原因:
+// 封包边界的 EOF 为对端关闭, 连接层面的错误为读取失败, 其余视为协议解析失败
+func readCloseKind(err error) CloseKind {
+	var netErr net.Error
+
+	switch {
+	case errors.Is(err, io.EOF):
+		return ClosePeer
+	case errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.ErrClosedPipe),
+		errors.Is(err, net.ErrClosed), errors.As(err, &netErr):
+		return CloseReadError
+	default:
+		return CloseDecodeError
+	}
+}
+
+// newPanicError 记录 panic 的值与当前 goroutine 的调用栈
+func newPanicError(p interface{}) *PanicError {
+	buf := make([]byte, 1<<16)
+	return &PanicError{Value: p, Stack: buf[:runtime.Stack(buf, false)]}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	socket "github.com/datochan/socketgo"
+)
+
+const socketBufferSize = 256 * 1024
+
+// benchClient 单个压测会话
+type benchClient struct {
+	*socket.AsyncClient
+	cfg    *benchConfig
+	stats  *stats
+	nextID uint32
+}
+
+// benchDispatcher 统计未被 Call 认领的封包, 如超时后才到达的应答
+type benchDispatcher struct {
+	*socket.Dispatcher
+	stats *stats
+}
+
+func (d *benchDispatcher) HandleProc(session socket.ISession, packet interface{}) {
+	atomic.AddInt64(&d.stats.unmatched, 1)
+}
+
+func dial(cfg *benchConfig, stats *stats) (*benchClient, error) {
+	dispatcher := &benchDispatcher{Dispatcher: socket.NewDispatcher(), stats: stats}
+	client := socket.NewAsyncClient(cfg.protocol, dispatcher, socket.DefaultSendChanSize)
+
+	err := client.Conn(cfg.network, cfg.addr, socketBufferSize, socketBufferSize, nil, nil)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return &benchClient{AsyncClient: client, cfg: cfg, stats: stats}, nil
+}
+
+// closedLoop inflight 个请求并发, 每个收到应答(或失败)后立即发送下一个
+func (c *benchClient) closedLoop(inflight int, stop <-chan struct{}) {
+	var wg sync.WaitGroup
+
+	for i := 0; i < inflight; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					c.request(time.Now())
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+// openLoop 按固定速率发送, 不等待应答; 延迟从计划发送时间算起, 避免协调遗漏
+func (c *benchClient) openLoop(rate float64, stop <-chan struct{}) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	interval := time.Duration(float64(time.Second) / rate)
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	next := time.Now()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-timer.C:
+		}
+
+		// 落后于计划时立即补发, 保持总速率
+		for now := time.Now(); !next.After(now); next = next.Add(interval) {
+			wg.Add(1)
+			go func(scheduled time.Time) {
+				defer wg.Done()
+				c.request(scheduled)
+			}(next)
+		}
+
+		timer.Reset(time.Until(next))
+	}
+}
+
+// request 发送一个带关联ID的请求并等待应答
+func (c *benchClient) request(scheduled time.Time) {
+	id := atomic.AddUint32(&c.nextID, 1)
+	offset, order := c.cfg.idOffset, c.cfg.protocol.ByteOrder
+
+	frame := make([]byte, len(c.cfg.template))
+	copy(frame, c.cfg.template)
+	order.PutUint32(frame[offset:], id)
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.cfg.timeout)
+	defer cancel()
+
+	atomic.AddInt64(&c.stats.requests, 1)
+	resp, err := c.Call(ctx, frame, func(packet interface{}) bool {
+		buf, ok := packet.(*socket.Buffer)
+		return ok && len(buf.B) >= offset+4 && order.Uint32(buf.B[offset:]) == id
+	})
+	if err != nil {
+		c.stats.addError("call", err)
+		return
+	}
+
+	resp.(*socket.Buffer).Release()
+	c.stats.observe(time.Since(scheduled))
+}
@@ -0,0 +1,159 @@
+// socketgo-bench 压测工具: 建立 N 个 AsyncClient 会话, 按模板发送请求并通过请求中的关联ID匹配应答,
+// 统计吞吐量、延迟分位数与错误数。服务端需在应答的相同位置原样带回关联ID。
+//
+// 闭环模式, 100 个会话, 每个会话同时 4 个请求:
+//
+//	socketgo-bench -addr 127.0.0.1:7190 -clients 100 -mode closed -inflight 4 -template 0100000000000000 -id-offset 8
+//
+// 开环模式, 总速率 20000 请求每秒, 延迟从计划发送时间算起, 不受服务端变慢的影响:
+//
+//	socketgo-bench -addr 127.0.0.1:7190 -clients 100 -mode open -rate 20000 -output json
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	socket "github.com/datochan/socketgo"
+)
+
+// benchConfig 压测配置
+type benchConfig struct {
+	network  string
+	addr     string
+	clients  int
+	mode     string
+	rate     float64
+	inflight int
+	duration time.Duration
+	timeout  time.Duration
+
+	protocol *socket.LengthFieldProtocol
+	template []byte
+	idOffset int
+}
+
+func main() {
+	var cfg benchConfig
+
+	flag.StringVar(&cfg.addr, "addr", "127.0.0.1:7190", "服务端地址")
+	flag.StringVar(&cfg.network, "network", "tcp", "网络类型")
+	flag.IntVar(&cfg.clients, "clients", 10, "并发会话数")
+	flag.StringVar(&cfg.mode, "mode", "closed", "closed: 每个会话收到应答后再发下一个请求; open: 按固定速率发送")
+	flag.Float64Var(&cfg.rate, "rate", 1000, "开环模式下所有会话合计每秒发送的请求数")
+	flag.IntVar(&cfg.inflight, "inflight", 1, "闭环模式下每个会话同时等待应答的请求数")
+	flag.DurationVar(&cfg.duration, "duration", 10*time.Second, "压测时长")
+	flag.DurationVar(&cfg.timeout, "timeout", 5*time.Second, "单个请求的超时时间")
+	headerSize := flag.Int("header", 8, "包头长度")
+	lengthOffset := flag.Int("length-offset", 4, "长度字段在包头中的偏移")
+	lengthSize := flag.Int("length-size", 4, "长度字段字节数: 1、2、4、8")
+	endian := flag.String("endian", "little", "长度字段与关联ID的字节序: little 或 big")
+	lengthAdjust := flag.Int("length-adjust", 0, "包体长度 = 长度字段值 + length-adjust")
+	template := flag.String("template", "0100000000000000", "请求封包模板(十六进制), 长度字段自动回填, 不足时补 0 以容纳关联ID")
+	flag.IntVar(&cfg.idOffset, "id-offset", 8, "4 字节关联ID在封包中的偏移")
+	output := flag.String("output", "text", "结果格式: text 或 json")
+	flag.Parse()
+
+	cfg.protocol = &socket.LengthFieldProtocol{
+		HeaderSize:   *headerSize,
+		LengthOffset: *lengthOffset,
+		LengthSize:   *lengthSize,
+		LengthAdjust: *lengthAdjust,
+	}
+
+	switch *endian {
+	case "little":
+		cfg.protocol.ByteOrder = binary.LittleEndian
+	case "big":
+		cfg.protocol.ByteOrder = binary.BigEndian
+	default:
+		exitf("未知的字节序: %s", *endian)
+	}
+	if err := cfg.protocol.Validate(); err != nil {
+		exitf("包头参数无效: %v", err)
+	}
+
+	var err error
+	if cfg.template, err = hex.DecodeString(strings.Join(strings.Fields(*template), "")); err != nil {
+		exitf("请求模板无效: %v", err)
+	}
+	if need := cfg.idOffset + 4; len(cfg.template) < need {
+		cfg.template = append(cfg.template, make([]byte, need-len(cfg.template))...)
+	}
+	if len(cfg.template) < *headerSize || cfg.idOffset < 0 {
+		exitf("请求模板短于包头或关联ID偏移无效")
+	}
+	if err = cfg.protocol.CheckPacket(cfg.template); err != nil {
+		exitf("请求模板无效: %v", err)
+	}
+
+	if cfg.clients <= 0 || cfg.inflight <= 0 || (cfg.mode == "open" && cfg.rate <= 0) {
+		exitf("clients、inflight 与 rate 必须大于 0")
+	}
+	if cfg.mode != "open" && cfg.mode != "closed" {
+		exitf("未知的模式: %s", cfg.mode)
+	}
+
+	report := run(&cfg)
+
+	switch *output {
+	case "json":
+		err = report.writeJSON(os.Stdout)
+	default:
+		report.writeText(os.Stdout)
+	}
+	if err != nil {
+		exitf("输出结果失败: %v", err)
+	}
+}
+
+// run 建立所有会话并压测 cfg.duration, 返回统计结果
+func run(cfg *benchConfig) *benchReport {
+	stats := newStats()
+
+	var clients []*benchClient
+	for i := 0; i < cfg.clients; i++ {
+		client, err := dial(cfg, stats)
+		if err != nil {
+			stats.addError("connect", err)
+			continue
+		}
+		clients = append(clients, client)
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+
+	// 开环模式的总速率由连接成功的会话分摊
+	start := time.Now()
+	for _, client := range clients {
+		wg.Add(1)
+		go func(client *benchClient) {
+			defer wg.Done()
+			if cfg.mode == "open" {
+				client.openLoop(cfg.rate/float64(len(clients)), stop)
+			} else {
+				client.closedLoop(cfg.inflight, stop)
+			}
+		}(client)
+	}
+
+	time.Sleep(cfg.duration)
+	close(stop)
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	// 会话随进程退出关闭, 避免关闭时打印的日志混入结果
+	return stats.report(cfg, len(clients), elapsed)
+}
+
+func exitf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	socket "github.com/datochan/socketgo"
+)
+
+// stats 汇总所有会话的结果
+type stats struct {
+	lock      sync.Mutex
+	latencies []time.Duration
+	errors    map[string]int
+
+	requests  int64
+	unmatched int64
+}
+
+func newStats() *stats {
+	return &stats{errors: make(map[string]int)}
+}
+
+func (s *stats) observe(latency time.Duration) {
+	s.lock.Lock()
+	s.latencies = append(s.latencies, latency)
+	s.lock.Unlock()
+}
+
+// addError 按阶段与原因分类计数
+func (s *stats) addError(stage string, err error) {
+	reason := err.Error()
+	var closeReason *socket.CloseReason
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		reason = "timeout"
+	case errors.As(err, &closeReason):
+		reason = "session closed: " + closeReason.Kind.String()
+	case errors.Is(err, socket.ErrSessionClosed):
+		reason = "session closed"
+	case errors.Is(err, socket.ErrSendChanBlocking):
+		reason = "send queue full"
+	}
+
+	s.lock.Lock()
+	s.errors[stage+": "+reason]++
+	s.lock.Unlock()
+}
+
+// benchReport 压测结果
+type benchReport struct {
+	Mode       string         `json:"mode"`
+	Clients    int            `json:"clients"`
+	Duration   float64        `json:"duration_sec"`
+	Requests   int64          `json:"requests"`
+	Responses  int            `json:"responses"`
+	Throughput float64        `json:"throughput_rps"` // 每秒收到的应答数
+	Latency    latencyReport  `json:"latency_ms"`
+	Errors     map[string]int `json:"errors"`
+	Unmatched  int64          `json:"unmatched"` // 未匹配到请求的封包, 通常是超时后才到达的应答
+}
+
+type latencyReport struct {
+	Min  float64 `json:"min"`
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P99  float64 `json:"p99"`
+	P999 float64 `json:"p999"`
+	Max  float64 `json:"max"`
+}
+
+func (s *stats) report(cfg *benchConfig, clients int, elapsed time.Duration) *benchReport {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	r := &benchReport{
+		Mode:       cfg.mode,
+		Clients:    clients,
+		Duration:   elapsed.Seconds(),
+		Requests:   s.requests,
+		Responses:  len(s.latencies),
+		Throughput: float64(len(s.latencies)) / elapsed.Seconds(),
+		Errors:     s.errors,
+		Unmatched:  s.unmatched,
+	}
+
+	if n := len(s.latencies); n > 0 {
+		sort.Slice(s.latencies, func(i, j int) bool { return s.latencies[i] < s.latencies[j] })
+
+		var total time.Duration
+		for _, latency := range s.latencies {
+			total += latency
+		}
+
+		r.Latency = latencyReport{
+			Min:  millis(s.latencies[0]),
+			Mean: millis(total / time.Duration(n)),
+			P50:  millis(percentile(s.latencies, 0.5)),
+			P99:  millis(percentile(s.latencies, 0.99)),
+			P999: millis(percentile(s.latencies, 0.999)),
+			Max:  millis(s.latencies[n-1]),
+		}
+	}
+
+	return r
+}
+
+// percentile 已排序的延迟中 q 分位的值
+func percentile(sorted []time.Duration, q float64) time.Duration {
+	i := int(math.Ceil(q*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func (r *benchReport) writeJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+func (r *benchReport) writeText(w io.Writer) {
+	fmt.Fprintf(w, "模式: %s  会话: %d  时长: %.2fs\n", r.Mode, r.Clients, r.Duration)
+	fmt.Fprintf(w, "请求: %d  应答: %d  吞吐量: %.1f/s  未匹配: %d\n", r.Requests, r.Responses, r.Throughput, r.Unmatched)
+	fmt.Fprintf(w, "延迟(ms): min %.3f  mean %.3f  p50 %.3f  p99 %.3f  p999 %.3f  max %.3f\n",
+		r.Latency.Min, r.Latency.Mean, r.Latency.P50, r.Latency.P99, r.Latency.P999, r.Latency.Max)
+
+	if len(r.Errors) == 0 {
+		return
+	}
+
+	reasons := make([]string, 0, len(r.Errors))
+	for reason := range r.Errors {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+
+	fmt.Fprintln(w, "错误:")
+	for _, reason := range reasons {
+		fmt.Fprintf(w, "  %-40s %d\n", reason, r.Errors[reason])
+	}
+}
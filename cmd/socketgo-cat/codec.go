@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// messageCodec 按描述文件中的消息类型在 JSON 与 protobuf 之间转换
+type messageCodec struct {
+	files *protoregistry.Files
+}
+
+// loadDescriptorSet 加载 protoc --descriptor_set_out(建议同时加 --include_imports)生成的文件
+func loadDescriptorSet(path string) (*messageCodec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var set descriptorpb.FileDescriptorSet
+	if err = proto.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return nil, err
+	}
+
+	return &messageCodec{files: files}, nil
+}
+
+func (c *messageCodec) find(name string) (protoreflect.MessageDescriptor, error) {
+	desc, err := c.files.FindDescriptorByName(protoreflect.FullName(name))
+	if err != nil {
+		return nil, err
+	}
+
+	md, ok := desc.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s 不是消息类型", name)
+	}
+
+	return md, nil
+}
+
+// encode 将 JSON 编码为 protobuf
+func (c *messageCodec) encode(name, text string) ([]byte, error) {
+	md, err := c.find(name)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := dynamicpb.NewMessage(md)
+	if err = protojson.Unmarshal([]byte(text), msg); err != nil {
+		return nil, err
+	}
+
+	return proto.Marshal(msg)
+}
+
+// decode 将 protobuf 解码为缩进的 JSON
+func (c *messageCodec) decode(name string, data []byte) (string, error) {
+	md, err := c.find(name)
+	if err != nil {
+		return "", err
+	}
+
+	msg := dynamicpb.NewMessage(md)
+	if err = proto.Unmarshal(data, msg); err != nil {
+		return "", err
+	}
+
+	text, err := protojson.MarshalOptions{Multiline: true, Indent: "  "}.Marshal(msg)
+	return string(text), err
+}
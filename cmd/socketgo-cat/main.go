@@ -0,0 +1,300 @@
+// socketgo-cat 类似 netcat 的调试工具, 按命令行给出的包头格式连接 socketgo 服务端, 收发完整封包。
+//
+// 包头 8 字节, 偏移 4 处为 4 字节小端的包体长度, 交互模式:
+//
+//	socketgo-cat -addr 127.0.0.1:7190 -header 8 -length-offset 4 -length-size 4 -endian little -i
+//
+// 每行一条命令, 发送的封包会自动回填长度字段:
+//
+//	hex 0100000000000000 0a03616263   发送十六进制表示的完整封包(包头+包体)
+//	file frame.bin                    发送文件内容作为完整封包
+//	json pkg.Message {"id": 1}        按 -descriptor 中的消息类型把 JSON 编码为 protobuf 包体, 包头取自 -header-hex
+//
+// 非交互模式下从标准输入逐行读取命令, 读完后等待 -wait 时间接收应答再退出。
+// 指定 -descriptor 与 -recv-type 时收到的包体按 protobuf JSON 打印, 否则打印十六进制。
+//
+// 上述包头参数描述收发共用的格式。请求与应答包头不同的服务端通过 -preset 选择预设格式,
+// 此时忽略 -header、-length-offset、-length-size、-endian 与 -length-adjust。
+// 如连接仓库中的 example/server(请求包头 8 字节, 应答包头 12 字节):
+//
+//	socketgo-cat -addr 127.0.0.1:7190 -preset example -i
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	socket "github.com/datochan/socketgo"
+)
+
+const socketBufferSize = 64 * 1024
+
+// presets -preset 可选的包头格式, 依次返回发送与接收使用的协议
+var presets = map[string]func() (send, recv *socket.LengthFieldProtocol){
+	// example/server: 请求包头为 Flag、BodyLength, 应答包头为 RespFlag、ReqFlag、BodyLength, 均为小端 uint32
+	"example": func() (*socket.LengthFieldProtocol, *socket.LengthFieldProtocol) {
+		return &socket.LengthFieldProtocol{HeaderSize: 8, LengthOffset: 4, LengthSize: 4, ByteOrder: binary.LittleEndian},
+			&socket.LengthFieldProtocol{HeaderSize: 12, LengthOffset: 8, LengthSize: 4, ByteOrder: binary.LittleEndian}
+	},
+}
+
+// splitProtocol 发送与接收的包头格式不同时, 按各自的格式组包与拆包
+type splitProtocol struct {
+	send *socket.LengthFieldProtocol
+	recv *socket.LengthFieldProtocol
+}
+
+func (p splitProtocol) ReadPacket(conn net.Conn) (interface{}, error) {
+	return p.recv.ReadPacket(conn)
+}
+
+func (p splitProtocol) BuildPacket(packet interface{}) []byte {
+	return p.send.BuildPacket(packet)
+}
+
+func (p splitProtocol) CheckPacket(packet interface{}) error {
+	return p.send.CheckPacket(packet)
+}
+
+func (p splitProtocol) SendPacket(conn net.Conn, buff []byte) error {
+	return p.send.SendPacket(conn, buff)
+}
+
+// catClient 保存封包格式与连接
+type catClient struct {
+	client   *socket.Client
+	protocol *socket.LengthFieldProtocol // 发送的封包格式
+	recv     *socket.LengthFieldProtocol // 接收的封包格式
+	header   []byte                      // json 命令使用的包头模板
+	codec    *messageCodec               // 未指定 -descriptor 时为 nil
+	recvType string                      // 收到的包体按此 protobuf 类型解析
+}
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:7190", "服务端地址")
+	network := flag.String("network", "tcp", "网络类型")
+	headerSize := flag.Int("header", 8, "包头长度")
+	lengthOffset := flag.Int("length-offset", 4, "长度字段在包头中的偏移")
+	lengthSize := flag.Int("length-size", 4, "长度字段字节数: 1、2、4、8")
+	endian := flag.String("endian", "little", "长度字段字节序: little 或 big")
+	lengthAdjust := flag.Int("length-adjust", 0, "包体长度 = 长度字段值 + length-adjust, 长度包含包头时为 -header")
+	maxFrame := flag.Int("max-frame", 0, "单个封包的最大长度, 0 表示默认值")
+	preset := flag.String("preset", "", "预设的包头格式: example, 指定后忽略上述包头参数")
+	headerHex := flag.String("header-hex", "", "json 命令使用的包头模板(十六进制), 不足包头长度时补 0")
+	descriptor := flag.String("descriptor", "", "protoc --descriptor_set_out 生成的描述文件")
+	recvType := flag.String("recv-type", "", "收到的包体对应的 protobuf 消息类型")
+	interactive := flag.Bool("i", false, "交互模式")
+	wait := flag.Duration("wait", time.Second, "非交互模式下命令发送完后等待应答的时间")
+	flag.Parse()
+
+	var protocol, recv *socket.LengthFieldProtocol
+	if *preset != "" {
+		newProtocols, ok := presets[*preset]
+		if !ok {
+			exitf("未知的预设格式: %s", *preset)
+		}
+		protocol, recv = newProtocols()
+	} else {
+		protocol = &socket.LengthFieldProtocol{
+			HeaderSize:   *headerSize,
+			LengthOffset: *lengthOffset,
+			LengthSize:   *lengthSize,
+			LengthAdjust: *lengthAdjust,
+		}
+
+		switch *endian {
+		case "little":
+			protocol.ByteOrder = binary.LittleEndian
+		case "big":
+			protocol.ByteOrder = binary.BigEndian
+		default:
+			exitf("未知的字节序: %s", *endian)
+		}
+
+		recv = protocol
+	}
+
+	protocol.MaxFrameSize = *maxFrame
+	recv.MaxFrameSize = *maxFrame
+	if err := protocol.Validate(); err != nil {
+		exitf("包头参数无效: %v", err)
+	}
+	if err := recv.Validate(); err != nil {
+		exitf("包头参数无效: %v", err)
+	}
+
+	header, err := parseHex(*headerHex)
+	if err != nil || len(header) > protocol.HeaderSize {
+		exitf("包头模板无效: %s", *headerHex)
+	}
+	header = append(header, make([]byte, protocol.HeaderSize-len(header))...)
+
+	cat := &catClient{protocol: protocol, recv: recv, header: header, recvType: *recvType}
+	if *descriptor != "" {
+		if cat.codec, err = loadDescriptorSet(*descriptor); err != nil {
+			exitf("加载描述文件失败: %v", err)
+		}
+	}
+	if cat.recvType != "" && cat.codec == nil {
+		exitf("-recv-type 需要同时指定 -descriptor")
+	}
+
+	if recv == protocol {
+		cat.client = socket.NewClient(protocol)
+	} else {
+		cat.client = socket.NewClient(splitProtocol{send: protocol, recv: recv})
+	}
+	if err = cat.client.Conn(*network, *addr, socketBufferSize, socketBufferSize); err != nil {
+		exitf("连接 %s 失败: %v", *addr, err)
+	}
+
+	// Client 会拦截中断信号, 这里同样接收以便退出
+	stopSignal := make(chan os.Signal, 1)
+	signal.Notify(stopSignal, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-stopSignal
+		cat.client.Close()
+		os.Exit(130)
+	}()
+
+	go cat.recvLoop()
+
+	if *interactive {
+		cat.repl()
+		return
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(nil, socket.DefaultMaxFrameSize)
+	for scanner.Scan() {
+		if err = cat.execute(scanner.Text()); err != nil {
+			exitf("%v", err)
+		}
+	}
+
+	time.Sleep(*wait)
+}
+
+// repl 交互模式, 命令出错时打印错误并继续
+func (c *catClient) repl() {
+	fmt.Println("输入 help 查看命令, quit 或 Ctrl-D 退出")
+
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(nil, socket.DefaultMaxFrameSize)
+	for fmt.Print("> "); scanner.Scan(); fmt.Print("> ") {
+		line := strings.TrimSpace(scanner.Text())
+		switch line {
+		case "quit", "exit":
+			return
+		case "help":
+			fmt.Println("hex <十六进制完整封包> | file <封包文件> | json <消息类型> <JSON> | quit")
+			continue
+		}
+
+		if err := c.execute(line); err != nil {
+			fmt.Printf("错误: %v\n", err)
+		}
+	}
+}
+
+// execute 执行一条发送命令, 空行与 # 开头的注释被忽略
+func (c *catClient) execute(line string) error {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+
+	command, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+
+	var frame []byte
+	var err error
+
+	switch command {
+	case "hex":
+		frame, err = parseHex(arg)
+	case "file":
+		frame, err = os.ReadFile(arg)
+	case "json":
+		frame, err = c.encodeJSON(arg)
+	default:
+		return fmt.Errorf("未知的命令: %s", command)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if len(frame) < c.protocol.HeaderSize {
+		return fmt.Errorf("封包长度 %d 小于包头长度 %d", len(frame), c.protocol.HeaderSize)
+	}
+
+	return c.client.Send(frame)
+}
+
+// encodeJSON 把 "消息类型 JSON" 编码为包头模板加 protobuf 包体
+func (c *catClient) encodeJSON(arg string) ([]byte, error) {
+	if c.codec == nil {
+		return nil, fmt.Errorf("json 命令需要指定 -descriptor")
+	}
+
+	name, text, _ := strings.Cut(arg, " ")
+	body, err := c.codec.encode(name, text)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(append([]byte(nil), c.header...), body...), nil
+}
+
+// recvLoop 打印收到的封包, 连接断开后退出进程
+func (c *catClient) recvLoop() {
+	for {
+		packet, err := c.client.Recv()
+		if err != nil {
+			fmt.Println("\n连接已断开")
+			os.Exit(0)
+		}
+
+		buf := packet.(*socket.Buffer)
+		c.print(buf.B)
+		buf.Release()
+	}
+}
+
+func (c *catClient) print(frame []byte) {
+	fmt.Printf("\n<- %s %d bytes\n", time.Now().Format("15:04:05.000"), len(frame))
+
+	if c.recvType != "" {
+		text, err := c.codec.decode(c.recvType, c.recv.Body(frame))
+		if err == nil {
+			fmt.Printf("header: % x\n%s\n", frame[:c.recv.HeaderSize], text)
+			return
+		}
+		fmt.Printf("按 %s 解析失败: %v\n", c.recvType, err)
+	}
+
+	fmt.Print(hex.Dump(frame))
+}
+
+// parseHex 解析十六进制字符串, 允许空白分隔与 0x 前缀
+func parseHex(text string) ([]byte, error) {
+	text = strings.Join(strings.Fields(text), "")
+	text = strings.ReplaceAll(strings.ReplaceAll(text, "0x", ""), "0X", "")
+
+	return hex.DecodeString(text)
+}
+
+func exitf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
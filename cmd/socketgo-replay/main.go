@@ -0,0 +1,154 @@
+// socketgo-replay 回放 Recorder 录制的会话数据。
+//
+// 回放到服务端(按原始节奏):
+//
+//	socketgo-replay -file session.rec -target 127.0.0.1:7190
+//
+// 尽快回放指定会话的出站数据(录制于客户端时使用):
+//
+//	socketgo-replay -file session.rec -target 127.0.0.1:7190 -speed 0 -dir out -session 3
+//
+// 回放到事件分发器, 按 -header 等参数描述的包头格式拆包, 打印每个封包的事件ID与内容,
+// 用于复现封包解析问题; 拆包失败时打印会话的关闭原因:
+//
+//	socketgo-replay -file session.rec -dispatch -header 8 -length-offset 4 -length-size 4 -id-offset 0 -id-size 4
+//
+// 不指定 -target 与 -dispatch 时打印录制内容。
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	socket "github.com/datochan/socketgo"
+)
+
+func main() {
+	file := flag.String("file", "", "录制文件")
+	target := flag.String("target", "", "回放的目标地址, 为空时打印录制内容")
+	network := flag.String("network", "tcp", "网络类型")
+	speed := flag.Float64("speed", 1, "回放速度倍数, 1 为原始节奏, 0 表示尽快回放")
+	dir := flag.String("dir", "in", "回放的方向: in 为录制端收到的数据, out 为录制端发出的数据")
+	session := flag.Uint64("session", 0, "只回放指定的会话ID, 0 表示全部会话")
+	dispatch := flag.Bool("dispatch", false, "回放到事件分发器并打印拆出的封包, 与 -target 互斥")
+	headerSize := flag.Int("header", 8, "-dispatch 时的包头长度")
+	lengthOffset := flag.Int("length-offset", 4, "长度字段在包头中的偏移")
+	lengthSize := flag.Int("length-size", 4, "长度字段字节数: 1、2、4、8")
+	lengthAdjust := flag.Int("length-adjust", 0, "包体长度 = 长度字段值 + length-adjust, 长度包含包头时为 -header")
+	idOffset := flag.Int("id-offset", 0, "事件ID在包头中的偏移")
+	idSize := flag.Int("id-size", 0, "事件ID字节数: 0、1、2、4, 0 表示不打印事件ID")
+	endian := flag.String("endian", "little", "字节序: little 或 big")
+	maxFrame := flag.Int("max-frame", 0, "单个封包的最大长度, 0 表示默认值")
+	flag.Parse()
+
+	if *file == "" || (*dispatch && *target != "") {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var protocol *socket.LengthFieldProtocol
+	if *dispatch {
+		protocol = &socket.LengthFieldProtocol{
+			HeaderSize:   *headerSize,
+			LengthOffset: *lengthOffset,
+			LengthSize:   *lengthSize,
+			LengthAdjust: *lengthAdjust,
+			IDOffset:     *idOffset,
+			IDSize:       *idSize,
+			MaxFrameSize: *maxFrame,
+		}
+
+		switch *endian {
+		case "little":
+			protocol.ByteOrder = binary.LittleEndian
+		case "big":
+			protocol.ByteOrder = binary.BigEndian
+		default:
+			fmt.Fprintf(os.Stderr, "未知的字节序: %s\n", *endian)
+			os.Exit(2)
+		}
+
+		if err := protocol.Validate(); err != nil {
+			fmt.Fprintf(os.Stderr, "包头参数无效: %v\n", err)
+			os.Exit(2)
+		}
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "打开录制文件失败: %v\n", err)
+		os.Exit(1)
+	}
+	defer f.Close()
+
+	opts := socket.ReplayOptions{SessionID: *session, Speed: *speed, Direction: socket.RecordInbound}
+	switch *dir {
+	case "in":
+	case "out":
+		opts.Direction = socket.RecordOutbound
+	default:
+		fmt.Fprintf(os.Stderr, "未知的方向: %s\n", *dir)
+		os.Exit(2)
+	}
+
+	switch {
+	case *dispatch:
+		err = socket.ReplayToDispatcher(f, protocol, &printDispatcher{Dispatcher: socket.NewDispatcher(), protocol: protocol}, opts)
+	case *target != "":
+		err = socket.ReplayToConn(f, func() (net.Conn, error) { return net.Dial(*network, *target) }, opts)
+	default:
+		err = dump(f, *session)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "回放失败: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// printDispatcher 打印回放时拆出的每个封包, 会话ID为回放时新建的内存会话的ID, 与录制中的会话ID无关
+type printDispatcher struct {
+	*socket.Dispatcher
+	protocol *socket.LengthFieldProtocol
+}
+
+func (d *printDispatcher) HandleProc(session socket.ISession, packet interface{}) {
+	frame := packet.(*socket.Buffer).B
+	fmt.Printf("session=%d id=%d %d bytes\n%s", session.ID(), d.protocol.PacketID(packet), len(frame), hex.Dump(frame))
+}
+
+// dump 打印录制内容
+func dump(r io.Reader, session uint64) error {
+	reader, err := socket.NewRecordReader(r)
+	if err != nil {
+		return err
+	}
+
+	for {
+		entry, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if session != 0 && entry.SessionID != session {
+			continue
+		}
+
+		direction := "<-"
+		if entry.Direction == socket.RecordOutbound {
+			direction = "->"
+		}
+
+		fmt.Printf("%s session=%d %s %d bytes\n%s", entry.Time.Format(time.RFC3339Nano),
+			entry.SessionID, direction, len(entry.Data), hex.Dump(entry.Data))
+	}
+}
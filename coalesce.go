@@ -0,0 +1,132 @@
+package socketgo
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	DefaultCoalesceBytes   = 64 * 1024 // 单次合并写入的默认字节上限
+	DefaultCoalescePackets = 128       // 单次合并写入的默认封包数上限
+)
+
+// CoalesceConfig 合并写入配置, sendLoop 会把队列中已有的封包合并为一次 writev 系统调用
+type CoalesceConfig struct {
+	MaxBytes   int           // 单次写入的字节上限, 超过后立即写出
+	MaxPackets int           // 单次写入的封包数上限
+	MaxDelay   time.Duration // 为凑批次最多等待的时长, 0 表示只合并已在队列中的封包
+}
+
+func (c CoalesceConfig) normalize() *CoalesceConfig {
+	if c.MaxBytes <= 0 {
+		c.MaxBytes = DefaultCoalesceBytes
+	}
+	if c.MaxPackets <= 0 {
+		c.MaxPackets = DefaultCoalescePackets
+	}
+
+	return &c
+}
+
+// SetWriteCoalescing 开启合并写入, 需在 Start 之前调用。
+// 开启后封包经 BuildPacket 组包后直接通过 net.Buffers 写入连接, 不再调用 SendPacket。
+func (s *Session) SetWriteCoalescing(cfg CoalesceConfig) {
+	s.coalesce = cfg.normalize()
+}
+
+// sendBatch 以 first 为起点收集队列中的封包, 并一次性写入连接
+func (s *Session) sendBatch(first *sendItem) error {
+	cfg := s.coalesce
+
+	s.batch = s.batch[:0]
+	size := s.appendBatch(first)
+
+	var timer Timer
+
+collect:
+	for len(s.batch) < cfg.MaxPackets && size < cfg.MaxBytes {
+		item := s.pollItem()
+		if item == nil {
+			if cfg.MaxDelay <= 0 {
+				break collect
+			}
+
+			if timer == nil {
+				timer = s.clock.NewTimer(cfg.MaxDelay)
+			}
+
+			if item = s.waitItem(timer.C()); item == nil {
+				break collect
+			}
+		}
+
+		size += s.appendBatch(item)
+	}
+
+	if timer != nil {
+		timer.Stop()
+	}
+
+	// WriteTo 会消费 net.Buffers, 这里复用 batchWrite 避免修改 batchBufs
+	s.batchWrite = append(s.batchWrite[:0], s.batchBufs...)
+	written, err := s.batchWrite.WriteTo(s.conn)
+
+	// 按写入的字节数确定哪些封包已完整写出, 第一个未写完的封包及其后的封包都以 err 结束
+	for i, item := range s.batch {
+		n := len(s.batchBufs[i])
+		if err != nil && written < int64(n) {
+			for _, rest := range s.batch[i:] {
+				rest.done(0, err)
+			}
+			break
+		}
+
+		written -= int64(n)
+		s.countWrite(n)
+		item.done(n, nil)
+		if s.sendCallback != nil {
+			s.sendCallback(s.conn, item.packet)
+		}
+	}
+
+	s.batch = s.batch[:0]
+	for i := range s.batchBufs {
+		s.batchBufs[i] = nil
+	}
+	s.batchBufs = s.batchBufs[:0]
+	for i, pooled := range s.batchPool {
+		pooled.Release()
+		s.batchPool[i] = nil
+	}
+	s.batchPool = s.batchPool[:0]
+
+	return err
+}
+
+// appendBatch 组包并加入当前批次, 返回封包长度; 需要分片或不合法的封包不计入批次。
+// 组包 panic 时 item 尚未加入批次, sendLoop 无从得知, 因此先回传结果再继续 panic 关闭会话。
+func (s *Session) appendBatch(item *sendItem) int {
+	defer func() {
+		if p := recover(); p != nil {
+			item.done(0, fmt.Errorf("%w: %w", ErrWritePacketFailed, newPanicError(p)))
+			panic(p)
+		}
+	}()
+
+	pkgcnt, pooled, err := buildPacket(s.protocol, item.packet)
+	if err != nil {
+		item.done(0, err)
+		return 0
+	}
+	if s.startFragments(item, pkgcnt, pooled) {
+		return 0
+	}
+
+	s.batch = append(s.batch, item)
+	s.batchBufs = append(s.batchBufs, pkgcnt)
+	if pooled != nil {
+		s.batchPool = append(s.batchPool, pooled)
+	}
+
+	return len(pkgcnt)
+}
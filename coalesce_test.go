@@ -0,0 +1,156 @@
+package socketgo
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// benchmarkSend 通过本地 TCP 连接发送 b.N 个小封包, 等待对端读完全部字节
+func benchmarkSend(b *testing.B, coalesce *CoalesceConfig) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer listener.Close()
+
+	packet := newTestFrame(1, make([]byte, 56))
+	frame := newTestProtocol().BuildPacket(packet)
+	total := int64(b.N) * int64(len(frame))
+
+	drained := make(chan error, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			drained <- err
+			return
+		}
+		defer conn.Close()
+
+		_, err = io.CopyN(io.Discard, conn, total)
+		drained <- err
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	session := NewSession(conn, newTestProtocol(), func(ISession, interface{}) {}, 1024)
+	session.SetSendPolicy(SendPolicyBlock)
+	if coalesce != nil {
+		session.SetWriteCoalescing(*coalesce)
+	}
+	session.Start()
+	defer session.Close()
+
+	b.SetBytes(int64(len(frame)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if err := session.Send(packet); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	if err := <-drained; err != nil {
+		b.Fatal(err)
+	}
+}
+
+// BenchmarkSessionSend 比较逐个写出与合并写入小封包的吞吐
+func BenchmarkSessionSend(b *testing.B) {
+	b.Run("PerMessage", func(b *testing.B) {
+		benchmarkSend(b, nil)
+	})
+	b.Run("Coalesced", func(b *testing.B) {
+		benchmarkSend(b, &CoalesceConfig{})
+	})
+}
+
+var errShortWrite = errors.New("short write")
+
+// shortConn 累计写出 limit 字节后写入失败
+type shortConn struct {
+	net.Conn
+	limit int
+}
+
+func (c *shortConn) Write(p []byte) (int, error) {
+	n := min(len(p), c.limit)
+	c.limit -= n
+	if n < len(p) {
+		return n, errShortWrite
+	}
+	return n, nil
+}
+
+// waitResult 等待 SendAsync 的结果
+func waitResult(t *testing.T, result <-chan SendResult) SendResult {
+	t.Helper()
+
+	select {
+	case r := <-result:
+		return r
+	case <-time.After(time.Second):
+		t.Fatal("SendAsync result not resolved")
+		return SendResult{}
+	}
+}
+
+func TestCoalesceShortWriteFailsRest(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+
+	// 第一个封包只写出一半, 之后较短的封包同样没有写出
+	session := NewSession(&shortConn{Conn: local, limit: 50}, newTestProtocol(), func(ISession, interface{}) {}, 4)
+	session.SetWriteCoalescing(CoalesceConfig{})
+	large := session.SendAsync(newTestFrame(1, make([]byte, 92)))
+	small := session.SendAsync(newTestFrame(2, []byte("x")))
+	session.Start()
+	defer session.Close()
+
+	for _, result := range []<-chan SendResult{large, small} {
+		if r := waitResult(t, result); !errors.Is(r.Err, errShortWrite) {
+			t.Fatalf("SendAsync = %+v, want errShortWrite", r)
+		}
+	}
+}
+
+// panicProtocol 组包 "boom" 时 panic
+type panicProtocol struct {
+	*LengthFieldProtocol
+}
+
+func (p panicProtocol) CheckPacket(interface{}) error { return nil }
+
+func (p panicProtocol) AppendPacket(dst []byte, packet interface{}) []byte {
+	if packet == "boom" {
+		panic("boom")
+	}
+	return p.LengthFieldProtocol.AppendPacket(dst, packet)
+}
+
+func TestCoalescePanicResolvesPolledItem(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+
+	session := NewSession(local, panicProtocol{newTestProtocol()}, func(ISession, interface{}) {}, 4)
+	session.SetWriteCoalescing(CoalesceConfig{})
+	first := session.SendAsync(newTestFrame(1, []byte("ok")))
+	polled := session.SendAsync("boom")
+	session.Start()
+	defer session.Close()
+
+	if r := waitResult(t, first); r.Err == nil {
+		t.Fatalf("first SendAsync = %+v, want error", r)
+	}
+
+	var panicErr *PanicError
+	if r := waitResult(t, polled); !errors.Is(r.Err, ErrWritePacketFailed) || !errors.As(r.Err, &panicErr) {
+		t.Fatalf("polled SendAsync = %+v, want ErrWritePacketFailed with *PanicError", r)
+	}
+}
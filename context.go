@@ -0,0 +1,114 @@
+package socketgo
+
+import (
+	"context"
+	"time"
+)
+
+// ContextHandler 带 context 的事件处理句柄, 通过 HandleWithContext 注册到分发器
+type ContextHandler func(ctx context.Context, session ISession, packet interface{})
+
+// ITraceCarrier 可选接口, 协议实现后可在封包中携带跟踪ID:
+// 收到的封包通过 ExtractTraceID 将跟踪ID放入 handler 的 ctx, SendContext/Call 时通过 InjectTraceID 写入发出的封包
+type ITraceCarrier interface {
+	// ExtractTraceID 取出封包携带的跟踪ID, 没有时返回 ""
+	ExtractTraceID(packet interface{}) string
+	// InjectTraceID 返回携带跟踪ID的封包, 可以是原封包本身
+	InjectTraceID(packet interface{}, traceID string) interface{}
+}
+
+type sessionKey struct{}
+type principalKey struct{}
+type traceIDKey struct{}
+
+// HandleWithContext 将 ContextHandler 包装为 PacketHandler, 可直接通过 AddHandler 注册。
+// ctx 派生自会话的 Context, 会话关闭时取消; timeout > 0 时另设处理的截止时间, 按会话的 Clock 计时。
+func HandleWithContext(handler ContextHandler, timeout time.Duration) PacketHandler {
+	return func(session ISession, packet interface{}) {
+		ctx := PacketContext(session, packet)
+		if timeout > 0 {
+			clock := RealClock
+			if s, ok := session.(*Session); ok {
+				clock = s.clock
+			}
+
+			var cancel context.CancelFunc
+			ctx, cancel = contextWithTimeout(clock, ctx, timeout)
+			defer cancel()
+		}
+
+		handler(ctx, session, packet)
+	}
+}
+
+// PacketContext 处理 packet 使用的 context: 会话的 Context, 协议实现 ITraceCarrier 时附带封包的跟踪ID
+func PacketContext(session ISession, packet interface{}) context.Context {
+	ctx := session.Context()
+
+	if s, ok := session.(*Session); ok {
+		if carrier, ok := s.protocol.(ITraceCarrier); ok {
+			if traceID := carrier.ExtractTraceID(packet); traceID != "" {
+				ctx = WithTraceID(ctx, traceID)
+			}
+		}
+	}
+
+	return ctx
+}
+
+// SessionFromContext 取出 ctx 所属的会话
+func SessionFromContext(ctx context.Context) (ISession, bool) {
+	session, ok := ctx.Value(sessionKey{}).(ISession)
+	return session, ok
+}
+
+// SessionIDFromContext 取出 ctx 所属会话的ID
+func SessionIDFromContext(ctx context.Context) (uint64, bool) {
+	if session, ok := SessionFromContext(ctx); ok {
+		return session.ID(), true
+	}
+	return 0, false
+}
+
+// WithPrincipal 返回携带认证主体的 ctx, 优先于会话通过 SetPrincipal 设置的主体
+func WithPrincipal(ctx context.Context, principal interface{}) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFromContext 取出认证主体: 先查找 WithPrincipal 设置的值, 其次为所属会话的 Principal
+func PrincipalFromContext(ctx context.Context) interface{} {
+	if principal := ctx.Value(principalKey{}); principal != nil {
+		return principal
+	}
+
+	if session, ok := SessionFromContext(ctx); ok {
+		return session.Principal()
+	}
+
+	return nil
+}
+
+// WithTraceID 返回携带跟踪ID的 ctx
+func WithTraceID(ctx context.Context, traceID string) context.Context {
+	return context.WithValue(ctx, traceIDKey{}, traceID)
+}
+
+// TraceIDFromContext 取出跟踪ID, 没有时返回 ""
+func TraceIDFromContext(ctx context.Context) string {
+	traceID, _ := ctx.Value(traceIDKey{}).(string)
+	return traceID
+}
+
+// injectTrace ctx 携带跟踪ID且协议实现 ITraceCarrier 时, 将跟踪ID写入发出的封包
+func injectTrace(ctx context.Context, protocol IPacketProtocol, packet interface{}) interface{} {
+	carrier, ok := protocol.(ITraceCarrier)
+	if !ok {
+		return packet
+	}
+
+	if traceID := TraceIDFromContext(ctx); traceID != "" {
+		return carrier.InjectTraceID(packet, traceID)
+	}
+
+	return packet
+}
@@ -0,0 +1,84 @@
+package socketgo_test
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	socket "github.com/datochan/socketgo"
+	"github.com/datochan/socketgo/socketgotest"
+)
+
+func TestHandleWithContextSessionClock(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+
+	protocol := &socket.LengthFieldProtocol{HeaderSize: 8, LengthOffset: 4, LengthSize: 4, IDSize: 4, ByteOrder: binary.LittleEndian}
+	session := socket.NewSession(local, protocol, func(socket.ISession, interface{}) {}, 1)
+	defer session.Close()
+
+	clock := socketgotest.NewVirtualClock(time.Now())
+	session.SetClock(clock)
+
+	errs := make(chan error, 1)
+	handler := socket.HandleWithContext(func(ctx context.Context, _ socket.ISession, _ interface{}) {
+		<-ctx.Done()
+		errs <- ctx.Err()
+	}, time.Second)
+	go handler(session, nil)
+
+	// 截止时间按会话的虚拟时钟计算, 真实时间经过不应使其超时
+	clock.BlockUntil(1)
+	select {
+	case err := <-errs:
+		t.Fatalf("handler finished before clock advanced: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	clock.Advance(time.Second)
+	select {
+	case err := <-errs:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("ctx.Err() = %v, want DeadlineExceeded", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("handler ctx not cancelled after clock advanced")
+	}
+}
+
+func TestHandleWithContextDeadlineAndCancel(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+
+	protocol := &socket.LengthFieldProtocol{HeaderSize: 8, LengthOffset: 4, LengthSize: 4, IDSize: 4, ByteOrder: binary.LittleEndian}
+	session := socket.NewSession(local, protocol, func(socket.ISession, interface{}) {}, 1)
+
+	start := time.Unix(1000, 0)
+	session.SetClock(socketgotest.NewVirtualClock(start))
+	session.Start()
+
+	errs := make(chan error, 1)
+	handler := socket.HandleWithContext(func(ctx context.Context, _ socket.ISession, _ interface{}) {
+		// 截止时间按虚拟时钟计算, Client.Call 等据此设置连接的截止时间
+		if deadline, ok := ctx.Deadline(); !ok || !deadline.Equal(start.Add(time.Second)) {
+			t.Errorf("ctx.Deadline() = %v, %v, want %v", deadline, ok, start.Add(time.Second))
+		}
+
+		<-ctx.Done()
+		errs <- ctx.Err()
+	}, time.Second)
+	go handler(session, nil)
+
+	_ = session.Close()
+	select {
+	case err := <-errs:
+		if err != context.Canceled {
+			t.Fatalf("ctx.Err() after session close = %v, want context.Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("handler ctx not cancelled after session closed")
+	}
+}
@@ -8,6 +8,11 @@ import (
 // PacketHandler 事件处理句柄,用于解析相应的封包
 type PacketHandler func(ISession, interface{})
 
+// IPacketIdentifier 可选接口, 协议实现后可从封包中取出事件ID, 供分发器与测试工具按ID匹配封包
+type IPacketIdentifier interface {
+	PacketID(packet interface{}) uint32
+}
+
 type IDispatcher interface {
 	AddHandler(id uint32, handler PacketHandler)
 	DelHandler(id uint32)
@@ -3,11 +3,33 @@ package socketgo
 import "errors"
 
 var (
-	ErrWritePacketFailed = errors.New("socket: Write packet failed")
-	ErrReadPacketFailed  = errors.New("socket: read packet failed")
-	ErrSignalStopped     = errors.New("socket: Signal Stopped")
-	ErrListenFailed      = errors.New("socket: listen Failed Error")
-	ErrAcceptFailed      = errors.New("socket: accept Failed Error")
-	ErrSessionClosed     = errors.New("socket: Session was closed")
-	ErrSendChanBlocking  = errors.New("socket: buff Length is not enough")
+	ErrWritePacketFailed   = errors.New("socket: Write packet failed")
+	ErrReadPacketFailed    = errors.New("socket: read packet failed")
+	ErrSignalStopped       = errors.New("socket: Signal Stopped")
+	ErrClientBroken        = errors.New("socket: connection broken by an interrupted call, reconnect first")
+	ErrListenFailed        = errors.New("socket: listen Failed Error")
+	ErrAcceptFailed        = errors.New("socket: accept Failed Error")
+	ErrSessionClosed       = errors.New("socket: Session was closed")
+	ErrSendChanBlocking    = errors.New("socket: buff Length is not enough")
+	ErrPacketDropped       = errors.New("socket: packet dropped from full send queue")
+	ErrFrameTooLarge       = errors.New("socket: frame exceeds max frame size")
+	ErrInvalidPacket       = errors.New("socket: packet does not match the protocol")
+	ErrInvalidProtocol     = errors.New("socket: invalid protocol configuration")
+	ErrFragmentUnsupported = errors.New("socket: protocol does not support fragmentation")
+	ErrFragmentCorrupted   = errors.New("socket: unexpected fragment")
+	ErrReassemblyOverflow  = errors.New("socket: reassembly buffer exceeds limit")
+	ErrMuxClosed           = errors.New("socket: mux was closed")
+	ErrMuxProtocol         = errors.New("socket: mux protocol error")
+	ErrStreamClosed        = errors.New("socket: stream was closed")
+	ErrStreamReset         = errors.New("socket: stream was reset")
+	ErrPoolClosed          = errors.New("socket: client pool was closed")
+	ErrNoEndpoint          = errors.New("socket: no available endpoint")
+	ErrSlowConsumer        = errors.New("socket: slow consumer, session closed")
+	ErrInvalidEndpoints    = errors.New("socket: invalid endpoint list")
+	ErrBadRecording        = errors.New("socket: not a session recording")
+	ErrInvalidTopic        = errors.New("socket: invalid topic")
+	ErrBadControlFrame     = errors.New("socket: malformed control frame")
+	ErrControlUnsupported  = errors.New("socket: protocol does not support control frames")
+	ErrFilterUnsupported   = errors.New("socket: subscription filters are not enabled")
+	ErrSubscribeRejected   = errors.New("socket: subscription rejected")
 )
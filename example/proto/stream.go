@@ -106,14 +106,13 @@ func (impl *BigEndianStreamImpl) ReadBuff(size int) (buff []byte, err error) {
 }
 
 // PeekBuff 从缓存中预读取指定字节数据不修改pos
+// 返回的是缓存的切片而非拷贝, 仅在下一次写入或 CleanBuff 之前有效
 func (impl *BigEndianStreamImpl) PeekBuff(size int) (buff []byte, err error) {
 	if impl.Right() < size {
 		return nil, ErrBuffOverflow
 	}
-	buff = make([]byte, size, size)
-	copy(buff, impl.buff[impl.pos:impl.pos+size])
 
-	return buff, nil
+	return impl.buff[impl.pos : impl.pos+size], nil
 }
 
 func (impl *BigEndianStreamImpl) CopyBuff(b []byte) error {
@@ -169,15 +168,15 @@ func (impl *BigEndianStreamImpl) WriteBuff(buff []byte) error {
 	return nil
 }
 
-// CleanBuff 将已经读取过的数据从缓存中清除
+// CleanBuff 将已经读取过的数据从缓存中清除, 未读取的数据原地移动到缓存头部
 func (impl *BigEndianStreamImpl) CleanBuff() error {
-	tmpBuffer, err := impl.ReadBuff(impl.len - impl.pos)
-	if nil != err {
-		return err
+	if impl.len < impl.pos {
+		return ErrBuffOverflow
 	}
 
-	impl.Reset(make([]byte, len(impl.buff), len(impl.buff)))
-	return impl.WriteBuff(tmpBuffer)
+	impl.len = copy(impl.buff, impl.buff[impl.pos:impl.len])
+	impl.pos = 0
+	return nil
 }
 
 type LittleEndianStreamImpl struct {
@@ -249,14 +248,13 @@ func (impl *LittleEndianStreamImpl) ReadBuff(size int) (buff []byte, err error)
 }
 
 // PeekBuff 从缓存中预读取指定字节数据不修改pos
+// 返回的是缓存的切片而非拷贝, 仅在下一次写入或 CleanBuff 之前有效
 func (impl *LittleEndianStreamImpl) PeekBuff(size int) (buff []byte, err error) {
 	if impl.Right() < size {
 		return nil, ErrBuffOverflow
 	}
-	buff = make([]byte, size, size)
-	copy(buff, impl.buff[impl.pos:impl.pos+size])
 
-	return buff, nil
+	return impl.buff[impl.pos : impl.pos+size], nil
 }
 
 func (impl *LittleEndianStreamImpl) CopyBuff(b []byte) error {
@@ -312,13 +310,13 @@ func (impl *LittleEndianStreamImpl) WriteBuff(buff []byte) error {
 	return nil
 }
 
-// CleanBuff 将已经读取过的数据从缓存中清除
+// CleanBuff 将已经读取过的数据从缓存中清除, 未读取的数据原地移动到缓存头部
 func (impl *LittleEndianStreamImpl) CleanBuff() error {
-	tmpBuffer, err := impl.ReadBuff(impl.len - impl.pos)
-	if nil != err {
-		return err
+	if impl.len < impl.pos {
+		return ErrBuffOverflow
 	}
 
-	impl.Reset(make([]byte, len(impl.buff), len(impl.buff)))
-	return impl.WriteBuff(tmpBuffer)
+	impl.len = copy(impl.buff, impl.buff[impl.pos:impl.len])
+	impl.pos = 0
+	return nil
 }
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	socket "github.com/datochan/socketgo"
+	"github.com/datochan/socketgo/example/proto"
+	"github.com/datochan/socketgo/socketgotest"
+)
+
+// requestProtocol 服务端读取的是客户端组装的请求封包, 组包时按请求格式组包, 读取仍使用服务端的实现
+type requestProtocol struct {
+	*ExampleProtocolImpl
+}
+
+func (p requestProtocol) BuildPacket(pkgNode interface{}) []byte {
+	reqNode := pkgNode.(proto.IRequestNode)
+	return CombineBytes(reqNode.GenerateHeader(), reqNode.GetRawData().([]byte))
+}
+
+func TestExampleProtocolConformance(t *testing.T) {
+	oversized := make([]byte, 8)
+	binary.LittleEndian.PutUint32(oversized, 0x0C)
+	binary.LittleEndian.PutUint32(oversized[4:], socket.DefaultMaxFrameSize+1)
+
+	socketgotest.ProtocolSuite{
+		NewProtocol: func() socket.IPacketProtocol { return requestProtocol{NewExampleProtocolImpl()} },
+		Packets: []interface{}{
+			proto.NewRequestNode(0x0C, []byte("hello")),
+			proto.NewRequestNode(0x01, []byte{}),
+		},
+		Equal: func(sent, received interface{}) bool {
+			req, node := sent.(*proto.RequestNode), received.(proto.RequestNode)
+			return node.Flag == req.Flag && bytes.Equal(node.Data.([]byte), req.Data.([]byte))
+		},
+		Oversized: oversized,
+	}.Run(t)
+}
@@ -8,6 +8,9 @@ import (
 	"time"
 )
 
+// broadcastGroup 发送过心跳的客户端加入此分组, 接收定时广播
+const broadcastGroup = "heartbeat"
+
 type ExampleServer struct {
 	*socket.Server
 }
@@ -44,6 +47,7 @@ func (c *ExampleServer) OnHearbeat(session socket.ISession, packet interface{})
 	_ = goproto.Unmarshal(respNode.Data.([]byte), hearbeat)
 
 	fmt.Printf("收到心跳封包, 内容为: %d, 正在应答\n", hearbeat.HearBeatId)
+	_ = c.Join(broadcastGroup, session)
 	_ = session.Send(c.Hearbeat())
 }
 
@@ -60,10 +64,8 @@ func main() {
 	go func() {
 		for {
 			time.Sleep(30 * time.Second)
-			for i, s := range server.SessionMng {
-				fmt.Printf("向第 %d 个客户端广播通知...\n", i+1)
-				_ = s.Send(server.Broadcast())
-			}
+			report := server.Server.Broadcast(broadcastGroup, server.Broadcast())
+			fmt.Printf("向 %d 个客户端广播通知, 失败 %d 个...\n", report.Sent, len(report.Failed))
 		}
 	}()
 
@@ -4,8 +4,9 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
-	"github.com/Re-volution/sizestruct"
+	socket "github.com/datochan/socketgo"
 	"github.com/datochan/socketgo/example/proto"
+	"io"
 	"net"
 )
 
@@ -23,51 +24,34 @@ func CombineBytes(pBytes ...[]byte) []byte {
 }
 
 // ExampleProtocolImpl 只做最简单实现: IPacketProtocol 接口
-type ExampleProtocolImpl struct {
-	PacketBuffer *proto.LittleEndianStreamImpl // 封包接收的缓冲区
-}
+// ReadPacket 不保存任何状态, 同一实例可以安全地用于多个连接
+type ExampleProtocolImpl struct{}
 
 func NewExampleProtocolImpl() *ExampleProtocolImpl {
-	return &ExampleProtocolImpl{
-		PacketBuffer: proto.NewLittleEndianStream(make([]byte, 1024*5, 1024*5)),
-	}
+	return &ExampleProtocolImpl{}
 }
 
-// ReadPacket 直接返回所有封包内容，不做任何处理
+// ReadPacket 先读取完整的包头, 再按包头中的长度读取包体, 不会多读属于下一个封包的数据
 func (pool *ExampleProtocolImpl) ReadPacket(s net.Conn) (interface{}, error) {
-	var newBuffer bytes.Buffer
 	var header proto.RequestHeader
 
-	headerSize := sizestruct.SizeOf(proto.RequestHeader{})
-
-	tmpBuffer := make([]byte, 1024*5, 1024*5)
-	recvLen, err := s.Read(tmpBuffer[:])
-	if recvLen <= 0 || err != nil {
-		return 0, err
+	// binary.Read 内部使用 io.ReadFull, 包头不完整时返回 io.ErrUnexpectedEOF
+	if err := binary.Read(s, binary.LittleEndian, &header); err != nil {
+		return nil, err
 	}
 
-	err = pool.PacketBuffer.WriteBuff(tmpBuffer[:recvLen])
-	_ = pool.PacketBuffer.CleanBuff()
-
-	// 预读并解析包头信息
-	tmpHeader, _ := pool.PacketBuffer.PeekBuff(headerSize)
-
-	newBuffer.Write(tmpHeader)
-	err = binary.Read(&newBuffer, binary.LittleEndian, &header)
+	if header.BodyLength > socket.DefaultMaxFrameSize {
+		return nil, socket.ErrFrameTooLarge
+	}
 
-	if nil != err {
+	pkgBody := make([]byte, header.BodyLength)
+	if _, err := io.ReadFull(s, pkgBody); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
 		return nil, err
 	}
 
-	// buffer中已经有完整的封包
-	_, _ = pool.PacketBuffer.ReadBuff(headerSize) // 跳过包头
-
-	// 开始读取封包体
-	pkgBody, _ := pool.PacketBuffer.ReadBuff(int(header.BodyLength))
-
-	// 清理掉已经处理的数据
-	_ = pool.PacketBuffer.CleanBuff()
-
 	//fmt.Printf("--> 收到封包: %s\n", hex.EncodeToString(pkgBody))
 	return proto.RequestNode{RequestHeader: header, Data: pkgBody}, nil
 }
@@ -80,6 +64,14 @@ func (pool *ExampleProtocolImpl) BuildPacket(pkgNode interface{}) []byte {
 	return CombineBytes(byteHeader, respNode.GetRawData().([]byte))
 }
 
+// AppendPacket 组包时直接写入 dst, 由 Session 提供池化的缓冲区
+func (pool *ExampleProtocolImpl) AppendPacket(dst []byte, pkgNode interface{}) []byte {
+	respNode := pkgNode.(proto.IResponseNode)
+	dst = append(dst, respNode.GenerateHeader()...)
+
+	return append(dst, respNode.GetRawData().([]byte)...)
+}
+
 func (pool *ExampleProtocolImpl) SendPacket(conn net.Conn, buff []byte) error {
 	_, err := conn.Write(buff)
 
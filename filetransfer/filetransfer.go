@@ -0,0 +1,150 @@
+// Package filetransfer 在 socketgo 的连接(TCP 连接或 Mux 中的流)上分块传输文件。
+//
+// 传输过程: 发送方发出 Offer, 接收方根据本地未完成的 .part 文件回复 Accept 及续传偏移,
+// 发送方随后按固定大小发送带 CRC32 校验的分块, 接收方校验并落盘后回复 Ack,
+// 校验失败时回复 Nack, 发送方从该偏移重发。全部分块确认后发送方发出 Done,
+// 接收方校验整个文件的 SHA-256 并回复 Complete。连接中断后再次发送同一文件即可从最后确认的偏移续传。
+package filetransfer
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+const (
+	DefaultChunkSize = 64 * 1024 // 默认分块大小
+	DefaultWindow    = 16        // 默认未确认分块数上限
+	MaxChunkSize     = 4 << 20   // 分块大小上限, 接收方拒绝更大的 Offer
+
+	maxControlSize = 64 * 1024 // 控制消息的最大长度
+	chunkHeader    = 12        // 分块消息头: offset(8) + crc32(4)
+)
+
+// 消息类型, 每条消息格式为 type(1) + length(4, 大端) + payload
+const (
+	msgOffer    byte = 1
+	msgAccept   byte = 2
+	msgReject   byte = 3
+	msgChunk    byte = 4
+	msgAck      byte = 5
+	msgNack     byte = 6
+	msgDone     byte = 7
+	msgComplete byte = 8
+	msgError    byte = 9
+)
+
+var (
+	ErrRejected        = errors.New("filetransfer: offer rejected")
+	ErrChecksumFailed  = errors.New("filetransfer: sha256 mismatch")
+	ErrUnexpectedMsg   = errors.New("filetransfer: unexpected message")
+	ErrControlTooLarge = errors.New("filetransfer: control message too large")
+	ErrRemote          = errors.New("filetransfer: remote error")
+)
+
+// Offer 发送方发起传输时的描述信息
+type Offer struct {
+	ID        string `json:"id"`         // 传输标识, 默认为文件的 SHA-256, 用于判断能否续传
+	Name      string `json:"name"`       // 文件名, 不含路径
+	Size      int64  `json:"size"`       // 文件大小
+	ChunkSize int    `json:"chunk_size"` // 分块大小
+	SHA256    string `json:"sha256"`     // 整个文件的 SHA-256(hex)
+}
+
+// Progress 传输进度, Transferred 为已被接收方确认的字节数
+type Progress struct {
+	Offer       *Offer
+	Transferred int64
+	Total       int64
+}
+
+// FnProgress 进度回调
+type FnProgress func(Progress)
+
+type acceptMsg struct {
+	Offset int64 `json:"offset"` // 续传偏移
+}
+
+type reasonMsg struct {
+	Reason string `json:"reason"`
+}
+
+type offsetMsg struct {
+	Offset int64 `json:"offset"`
+}
+
+func writeMsg(w io.Writer, typ byte, payload []byte) error {
+	var header [5]byte
+	header[0] = typ
+	binary.BigEndian.PutUint32(header[1:], uint32(len(payload)))
+
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	if len(payload) == 0 {
+		return nil
+	}
+
+	_, err := w.Write(payload)
+	return err
+}
+
+func writeJSON(w io.Writer, typ byte, v interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return writeMsg(w, typ, payload)
+}
+
+// readHeader 读取消息头, 返回类型与负载长度
+func readHeader(r io.Reader) (byte, int, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, 0, err
+	}
+
+	return header[0], int(binary.BigEndian.Uint32(header[1:])), nil
+}
+
+// readControl 读取一条控制消息
+func readControl(r io.Reader) (byte, []byte, error) {
+	typ, length, err := readHeader(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if length > maxControlSize {
+		return 0, nil, ErrControlTooLarge
+	}
+
+	payload := make([]byte, length)
+	if _, err = io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+
+	return typ, payload, nil
+}
+
+// remoteError 将对端的 Reject/Error 消息转为错误
+func remoteError(base error, payload []byte) error {
+	var msg reasonMsg
+	_ = json.Unmarshal(payload, &msg)
+
+	return &RemoteError{Err: base, Reason: msg.Reason}
+}
+
+// RemoteError 对端拒绝或中止传输
+type RemoteError struct {
+	Err    error
+	Reason string
+}
+
+func (e *RemoteError) Error() string {
+	return e.Err.Error() + ": " + e.Reason
+}
+
+func (e *RemoteError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,303 @@
+package filetransfer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// writeSource 在 dir 下生成 size 字节的测试文件, 返回路径与内容
+func writeSource(t *testing.T, dir string, size int) (string, []byte) {
+	t.Helper()
+
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+
+	path := filepath.Join(dir, "data.bin")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path, data
+}
+
+// transfer 在内存连接上运行发送方与接收方, 返回双方的结果
+func transfer(t *testing.T, s *Sender, r *Receiver, path string, wrapSender, wrapReceiver func(net.Conn) net.Conn) (string, error, error) {
+	t.Helper()
+
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	type result struct {
+		path string
+		err  error
+	}
+	received := make(chan result, 1)
+	go func() {
+		conn := net.Conn(remote)
+		if wrapReceiver != nil {
+			conn = wrapReceiver(conn)
+		}
+		saved, err := r.Receive(conn)
+		received <- result{saved, err}
+	}()
+
+	conn := net.Conn(local)
+	if wrapSender != nil {
+		conn = wrapSender(conn)
+	}
+	sendErr := s.SendFile(conn, path)
+	if sendErr != nil {
+		_ = local.Close()
+	}
+
+	res := <-received
+	return res.path, sendErr, res.err
+}
+
+func TestTransferResumeFromPart(t *testing.T) {
+	const chunkSize = 1024
+
+	src, dst := t.TempDir(), t.TempDir()
+	path, data := writeSource(t, src, 10*chunkSize+100)
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Sender{ChunkSize: chunkSize}
+	offer, err := s.makeOffer(f, "data.bin")
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 上次传输留下两个完整分块与半个分块, 只有完整分块会被保留
+	target := filepath.Join(dst, "data.bin")
+	meta, _ := json.Marshal(offer)
+	if err = os.WriteFile(target+partSuffix+metaSuffix, meta, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(target+partSuffix, data[:2*chunkSize+500], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var acks []int64
+	s.OnProgress = func(p Progress) { acks = append(acks, p.Transferred) }
+
+	// 通过 TCP 连接传输, 发送方走 sendfile 路径
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	errs := make(chan error, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			errs <- err
+			return
+		}
+		defer conn.Close()
+		_, err = (&Receiver{Dir: dst}).Receive(conn)
+		errs <- err
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err = s.SendFile(conn, path); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-errs; err != nil {
+		t.Fatal(err)
+	}
+
+	if len(acks) == 0 || acks[0] != 3*chunkSize {
+		t.Fatalf("first ack %v, want resume from %d", acks, 2*chunkSize)
+	}
+
+	got, err := os.ReadFile(target)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("received file differs from source: %v", err)
+	}
+	for _, leftover := range []string{target + partSuffix, target + partSuffix + metaSuffix} {
+		if _, err = os.Stat(leftover); !os.IsNotExist(err) {
+			t.Fatalf("%s not removed after completion", leftover)
+		}
+	}
+}
+
+// corruptConn 翻转第一个分块的最后一个字节
+type corruptConn struct {
+	net.Conn
+	chunk     bool
+	corrupted bool
+}
+
+func (c *corruptConn) Write(b []byte) (int, error) {
+	if c.chunk && !c.corrupted {
+		b = append([]byte(nil), b...)
+		b[len(b)-1] ^= 0xff
+		c.corrupted = true
+	}
+	c.chunk = len(b) == 5 && b[0] == msgChunk
+
+	return c.Conn.Write(b)
+}
+
+// recordConn 记录写出的消息类型, 消息头总是单独写出
+type recordConn struct {
+	net.Conn
+	lock  sync.Mutex
+	types []byte
+}
+
+func (c *recordConn) Write(b []byte) (int, error) {
+	if len(b) == 5 {
+		c.lock.Lock()
+		c.types = append(c.types, b[0])
+		c.lock.Unlock()
+	}
+
+	return c.Conn.Write(b)
+}
+
+func (c *recordConn) count(typ byte) int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	n := 0
+	for _, written := range c.types {
+		if written == typ {
+			n++
+		}
+	}
+	return n
+}
+
+func TestTransferNackResend(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	path, data := writeSource(t, src, 4096+10)
+
+	record := &recordConn{}
+	saved, sendErr, recvErr := transfer(t, &Sender{ChunkSize: 1024, Window: 2}, &Receiver{Dir: dst}, path,
+		func(c net.Conn) net.Conn { return &corruptConn{Conn: c} },
+		func(c net.Conn) net.Conn { record.Conn = c; return record })
+	if sendErr != nil || recvErr != nil {
+		t.Fatalf("SendFile = %v, Receive = %v", sendErr, recvErr)
+	}
+
+	if n := record.count(msgNack); n != 1 {
+		t.Fatalf("receiver sent %d Nacks, want 1", n)
+	}
+
+	got, err := os.ReadFile(saved)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("received file differs from source after resend: %v", err)
+	}
+}
+
+func TestTransferChecksumMismatch(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	path, _ := writeSource(t, src, 100)
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// 分块 CRC32 均正确, 但 Offer 中的 SHA-256 与文件内容不符
+	offer := &Offer{ID: "forged", Name: "data.bin", Size: 100, ChunkSize: 1024, SHA256: "00"}
+
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := (&Receiver{Dir: dst}).Receive(remote)
+		errs <- err
+	}()
+
+	if err = writeJSON(local, msgOffer, offer); err != nil {
+		t.Fatal(err)
+	}
+	if typ, _, err := readControl(local); err != nil || typ != msgAccept {
+		t.Fatalf("got %d, %v, want Accept", typ, err)
+	}
+
+	buf := make([]byte, chunkHeader+100)
+	if err = sendChunk(local, f, buf, 0); err != nil {
+		t.Fatal(err)
+	}
+	if typ, _, err := readControl(local); err != nil || typ != msgAck {
+		t.Fatalf("got %d, %v, want Ack", typ, err)
+	}
+
+	if err = writeMsg(local, msgDone, nil); err != nil {
+		t.Fatal(err)
+	}
+	typ, payload, err := readControl(local)
+	if err != nil || typ != msgError {
+		t.Fatalf("got %d, %v, want Error", typ, err)
+	}
+	if err = remoteError(ErrRemote, payload); !errors.Is(err, ErrRemote) {
+		t.Fatalf("remote error %v", err)
+	}
+
+	if err = <-errs; !errors.Is(err, ErrChecksumFailed) {
+		t.Fatalf("Receive = %v, want ErrChecksumFailed", err)
+	}
+
+	// 校验失败后不保留进度, 下次从头传输
+	target := filepath.Join(dst, "data.bin")
+	if info, err := os.Stat(target + partSuffix); err != nil || info.Size() != 0 {
+		t.Fatalf("part file not truncated: %v", err)
+	}
+	if _, err = os.Stat(target); !os.IsNotExist(err) {
+		t.Fatal("unverified file was renamed to its final name")
+	}
+}
+
+func TestTransferRejected(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	path, _ := writeSource(t, src, 100)
+
+	var offered *Offer
+	r := &Receiver{Dir: dst, OnOffer: func(offer *Offer) bool {
+		offered = offer
+		return false
+	}}
+
+	_, sendErr, recvErr := transfer(t, &Sender{}, r, path, nil, nil)
+
+	var remoteErr *RemoteError
+	if !errors.As(sendErr, &remoteErr) || !errors.Is(sendErr, ErrRejected) || remoteErr.Reason == "" {
+		t.Fatalf("SendFile = %v, want RemoteError wrapping ErrRejected", sendErr)
+	}
+	if !errors.Is(recvErr, ErrRejected) {
+		t.Fatalf("Receive = %v, want ErrRejected", recvErr)
+	}
+	if offered == nil || offered.Name != "data.bin" || offered.Size != 100 {
+		t.Fatalf("OnOffer got %+v", offered)
+	}
+
+	entries, _ := os.ReadDir(dst)
+	if len(entries) != 0 {
+		t.Fatalf("rejected offer left %d files in the target dir", len(entries))
+	}
+}
@@ -0,0 +1,220 @@
+package filetransfer
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+
+	socket "github.com/datochan/socketgo"
+)
+
+const (
+	partSuffix = ".part" // 未完成的文件
+	metaSuffix = ".meta" // 未完成文件对应的 Offer, 用于判断能否续传
+)
+
+// Receiver 文件接收方, 未完成的文件保存为 Dir 下的 <name>.part, 完成后改名为 <name>
+type Receiver struct {
+	Dir        string                  // 保存目录
+	OnOffer    func(offer *Offer) bool // 决定是否接收, 为 nil 时全部接收
+	OnProgress FnProgress              // 每个分块落盘后回调
+}
+
+// Receive 接收一个文件, 返回保存的路径
+func (r *Receiver) Receive(conn net.Conn) (string, error) {
+	typ, payload, err := readControl(conn)
+	if err != nil {
+		return "", err
+	}
+	if typ != msgOffer {
+		return "", fmt.Errorf("%w: %d", ErrUnexpectedMsg, typ)
+	}
+
+	offer := &Offer{}
+	if err = json.Unmarshal(payload, offer); err != nil {
+		return "", err
+	}
+
+	name := filepath.Base(offer.Name)
+	if name == "." || name == string(filepath.Separator) || offer.ChunkSize <= 0 || offer.ChunkSize > MaxChunkSize || offer.Size < 0 {
+		_ = writeJSON(conn, msgReject, reasonMsg{Reason: "invalid offer"})
+		return "", fmt.Errorf("%w: invalid offer", ErrUnexpectedMsg)
+	}
+
+	if r.OnOffer != nil && !r.OnOffer(offer) {
+		_ = writeJSON(conn, msgReject, reasonMsg{Reason: "rejected by receiver"})
+		return "", ErrRejected
+	}
+
+	target := filepath.Join(r.Dir, name)
+	f, offset, err := r.openPart(target, offer)
+	if err != nil {
+		_ = writeJSON(conn, msgReject, reasonMsg{Reason: err.Error()})
+		return "", err
+	}
+	defer f.Close()
+
+	if err = writeJSON(conn, msgAccept, acceptMsg{Offset: offset}); err != nil {
+		return "", err
+	}
+
+	if err = r.recvChunks(conn, f, offer, offset); err != nil {
+		return "", err
+	}
+
+	if err = verifyFile(f, offer.SHA256); err != nil {
+		// 文件内容有误时不保留进度, 下次从头传输
+		_ = writeJSON(conn, msgError, reasonMsg{Reason: err.Error()})
+		_ = f.Truncate(0)
+		return "", err
+	}
+
+	if err = os.Rename(target+partSuffix, target); err != nil {
+		_ = writeJSON(conn, msgError, reasonMsg{Reason: err.Error()})
+		return "", err
+	}
+	_ = os.Remove(target + partSuffix + metaSuffix)
+
+	return target, writeMsg(conn, msgComplete, nil)
+}
+
+// openPart 打开未完成的文件, 与之前的 Offer 一致时从已落盘的完整分块处续传
+func (r *Receiver) openPart(target string, offer *Offer) (*os.File, int64, error) {
+	partPath, metaPath := target+partSuffix, target+partSuffix+metaSuffix
+
+	var offset int64
+	if meta, err := os.ReadFile(metaPath); err == nil {
+		var prev Offer
+		if json.Unmarshal(meta, &prev) == nil && prev == *offer {
+			if info, err := os.Stat(partPath); err == nil {
+				offset = info.Size() / int64(offer.ChunkSize) * int64(offer.ChunkSize)
+			}
+		}
+	}
+
+	f, err := os.OpenFile(partPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if err = f.Truncate(offset); err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+
+	meta, _ := json.Marshal(offer)
+	if err = os.WriteFile(metaPath, meta, 0644); err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+
+	return f, offset, nil
+}
+
+// recvChunks 接收分块直到收到 Done, 只接受偏移连续的分块
+func (r *Receiver) recvChunks(conn net.Conn, f *os.File, offer *Offer, expected int64) error {
+	verify := socket.GetBuffer(offer.ChunkSize)
+	defer verify.Release()
+
+	for {
+		typ, length, err := readHeader(conn)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case typ == msgDone:
+			if expected != offer.Size {
+				return fmt.Errorf("%w: done at offset %d of %d", ErrUnexpectedMsg, expected, offer.Size)
+			}
+			return nil
+		case typ != msgChunk || length < chunkHeader || length-chunkHeader > offer.ChunkSize:
+			return fmt.Errorf("%w: %d", ErrUnexpectedMsg, typ)
+		}
+
+		var header [chunkHeader]byte
+		if _, err = io.ReadFull(conn, header[:]); err != nil {
+			return err
+		}
+
+		offset := int64(binary.BigEndian.Uint64(header[:]))
+		checksum := binary.BigEndian.Uint32(header[8:])
+		size := int64(length - chunkHeader)
+
+		if offset != expected || offset+size > offer.Size {
+			// Nack 之后仍在途的分块, 丢弃等待重发
+			if _, err = io.CopyN(io.Discard, conn, size); err != nil {
+				return err
+			}
+			continue
+		}
+
+		ok, err := writeChunk(conn, f, verify.B[:size], offset, checksum)
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			if err = writeJSON(conn, msgNack, offsetMsg{Offset: expected}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		expected += size
+		if err = writeJSON(conn, msgAck, offsetMsg{Offset: expected}); err != nil {
+			return err
+		}
+
+		if r.OnProgress != nil {
+			r.OnProgress(Progress{Offer: offer, Transferred: expected, Total: offer.Size})
+		}
+	}
+}
+
+// writeChunk 将分块写入文件并校验 CRC32, 校验失败时截断回写入前的长度。
+// 数据由 conn 直接复制到文件, TCP 连接在 Linux 上会使用 splice。
+func writeChunk(conn net.Conn, f *os.File, verify []byte, offset int64, checksum uint32) (bool, error) {
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return false, err
+	}
+
+	if _, err := io.CopyN(f, conn, int64(len(verify))); err != nil {
+		return false, err
+	}
+
+	if _, err := f.ReadAt(verify, offset); err != nil {
+		return false, err
+	}
+
+	if crc32.ChecksumIEEE(verify) == checksum {
+		return true, nil
+	}
+
+	return false, f.Truncate(offset)
+}
+
+// verifyFile 校验整个文件的 SHA-256
+func verifyFile(f *os.File, expected string) error {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+
+	if hex.EncodeToString(h.Sum(nil)) != expected {
+		return ErrChecksumFailed
+	}
+
+	return nil
+}
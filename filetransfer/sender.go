@@ -0,0 +1,253 @@
+package filetransfer
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+
+	socket "github.com/datochan/socketgo"
+)
+
+// Sender 文件发送方
+type Sender struct {
+	ChunkSize  int        // 分块大小, 0 表示 DefaultChunkSize
+	Window     int        // 未确认分块数上限, 0 表示 DefaultWindow
+	OnProgress FnProgress // 每次收到确认后回调
+}
+
+// ackEvent 接收方的确认消息
+type ackEvent struct {
+	typ    byte
+	offset int64
+	err    error
+}
+
+// SendFile 发送文件, 由接收方决定续传偏移。
+// conn 为 *net.TCPConn 时分块数据通过 sendfile 直接从文件写入 socket。
+// 返回错误时读取确认的 goroutine 仍阻塞在 conn 上, 调用方需关闭 conn。
+func (s *Sender) SendFile(conn net.Conn, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	offer, err := s.makeOffer(f, filepath.Base(path))
+	if err != nil {
+		return err
+	}
+
+	if err = writeJSON(conn, msgOffer, offer); err != nil {
+		return err
+	}
+
+	typ, payload, err := readControl(conn)
+	if err != nil {
+		return err
+	}
+
+	var accept acceptMsg
+	switch typ {
+	case msgAccept:
+		if err = json.Unmarshal(payload, &accept); err != nil {
+			return err
+		}
+	case msgReject:
+		return remoteError(ErrRejected, payload)
+	default:
+		return fmt.Errorf("%w: %d", ErrUnexpectedMsg, typ)
+	}
+
+	if accept.Offset < 0 || accept.Offset > offer.Size {
+		return fmt.Errorf("%w: resume offset %d", ErrUnexpectedMsg, accept.Offset)
+	}
+
+	events := make(chan ackEvent, s.window()+1)
+	stop := make(chan struct{})
+	defer close(stop)
+	go readAcks(conn, events, stop)
+
+	if err = s.sendChunks(conn, f, offer, accept.Offset, events); err != nil {
+		return err
+	}
+
+	if err = writeMsg(conn, msgDone, nil); err != nil {
+		return err
+	}
+
+	ev := <-events
+	switch {
+	case ev.err != nil:
+		return ev.err
+	case ev.typ != msgComplete:
+		return fmt.Errorf("%w: %d", ErrUnexpectedMsg, ev.typ)
+	}
+
+	return nil
+}
+
+// makeOffer 计算文件大小与 SHA-256
+func (s *Sender) makeOffer(f *os.File, name string) (*Offer, error) {
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := hex.EncodeToString(h.Sum(nil))
+
+	return &Offer{ID: sum, Name: name, Size: size, ChunkSize: s.chunkSize(), SHA256: sum}, nil
+}
+
+// sendChunks 滑动窗口发送分块, 收到 Nack 时从对应偏移重发
+func (s *Sender) sendChunks(conn net.Conn, f *os.File, offer *Offer, start int64, events <-chan ackEvent) error {
+	chunkSize := int64(offer.ChunkSize)
+	window := int64(s.window()) * chunkSize
+	next, acked := start, start
+
+	buf := socket.GetBuffer(offer.ChunkSize + chunkHeader)
+	defer buf.Release()
+
+	for acked < offer.Size {
+		for next < offer.Size && next-acked < window {
+			n := offer.Size - next
+			if n > chunkSize {
+				n = chunkSize
+			}
+
+			if err := sendChunk(conn, f, buf.B[:chunkHeader+int(n)], next); err != nil {
+				return err
+			}
+			next += n
+
+			if len(events) > 0 {
+				break
+			}
+		}
+
+		ev := <-events
+		switch {
+		case ev.err != nil:
+			return ev.err
+		case ev.typ == msgAck:
+			if ev.offset > acked {
+				acked = ev.offset
+			}
+			if s.OnProgress != nil {
+				s.OnProgress(Progress{Offer: offer, Transferred: acked, Total: offer.Size})
+			}
+		case ev.typ == msgNack:
+			// 回退重发, 接收方会丢弃该偏移之后已在途的分块
+			next = ev.offset
+		default:
+			return fmt.Errorf("%w: %d", ErrUnexpectedMsg, ev.typ)
+		}
+	}
+
+	return nil
+}
+
+// sendChunk 发送一个分块, chunk 前 chunkHeader 字节用于消息头, 之后用于读取文件内容。
+// 其他连接直接写出计算 CRC32 时已读出的数据, TCP 连接则通过 sendfile 从文件写入 socket,
+// 避免数据在用户态再复制一次。
+func sendChunk(conn net.Conn, f *os.File, chunk []byte, offset int64) error {
+	data := chunk[chunkHeader:]
+	if _, err := f.ReadAt(data, offset); err != nil {
+		return err
+	}
+
+	binary.BigEndian.PutUint64(chunk, uint64(offset))
+	binary.BigEndian.PutUint32(chunk[8:], crc32.ChecksumIEEE(data))
+
+	var header [5]byte
+	header[0] = msgChunk
+	binary.BigEndian.PutUint32(header[1:], uint32(len(chunk)))
+
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		bufs := net.Buffers{header[:], chunk}
+		_, err := bufs.WriteTo(conn)
+		return err
+	}
+
+	// TCP 连接: 消息头与分块头通过 writev 写出, 数据通过 sendfile 写出
+	bufs := net.Buffers{header[:], chunk[:chunkHeader]}
+	if _, err := bufs.WriteTo(tcpConn); err != nil {
+		return err
+	}
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	n, err := tcpConn.ReadFrom(&io.LimitedReader{R: f, N: int64(len(data))})
+	if err == nil && n != int64(len(data)) {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// readAcks 持续读取接收方的确认消息, stop 关闭后不再投递, 避免 SendFile 返回后阻塞在 events 上
+func readAcks(conn net.Conn, events chan<- ackEvent, stop <-chan struct{}) {
+	emit := func(ev ackEvent) bool {
+		select {
+		case events <- ev:
+			return true
+		case <-stop:
+			return false
+		}
+	}
+
+	for {
+		typ, payload, err := readControl(conn)
+		if err != nil {
+			emit(ackEvent{err: err})
+			return
+		}
+
+		switch typ {
+		case msgAck, msgNack:
+			var msg offsetMsg
+			if err = json.Unmarshal(payload, &msg); err != nil {
+				emit(ackEvent{err: err})
+				return
+			}
+			if !emit(ackEvent{typ: typ, offset: msg.Offset}) {
+				return
+			}
+		case msgComplete:
+			emit(ackEvent{typ: typ})
+			return
+		case msgError:
+			emit(ackEvent{err: remoteError(ErrRemote, payload)})
+			return
+		default:
+			emit(ackEvent{err: fmt.Errorf("%w: %d", ErrUnexpectedMsg, typ)})
+			return
+		}
+	}
+}
+
+func (s *Sender) chunkSize() int {
+	switch {
+	case s.ChunkSize <= 0:
+		return DefaultChunkSize
+	case s.ChunkSize > MaxChunkSize:
+		return MaxChunkSize
+	}
+	return s.ChunkSize
+}
+
+func (s *Sender) window() int {
+	if s.Window <= 0 {
+		return DefaultWindow
+	}
+	return s.Window
+}
@@ -0,0 +1,190 @@
+package socketgo
+
+import "fmt"
+
+// DefaultMaxReassembly 单个会话默认允许同时重组的封包总字节数
+const DefaultMaxReassembly = 64 * 1024 * 1024
+
+// Fragment 大封包拆分后的分片
+type Fragment struct {
+	MsgID uint32 // 会话内唯一的封包编号
+	Index uint32 // 分片序号, 从0开始
+	Total uint32 // 分片总数
+	Data  []byte // 分片内容
+}
+
+// IFragmentProtocol 可选接口, 协议实现后才能拆分与重组大封包。
+// 协议需要能区分分片与普通封包: ReadPacket 读到分片时返回 *Fragment,
+// 会话收齐所有分片后调用 ParsePacket 还原封包, 再交给 handler 处理。
+type IFragmentProtocol interface {
+	// BuildFragment 将分片组装为可直接发送的封包
+	BuildFragment(frag *Fragment) []byte
+	// ParsePacket 解析重组后的完整数据, 即对端 BuildPacket 的输出
+	ParsePacket(data []byte) (interface{}, error)
+}
+
+// IFragmentSwitch 可选接口, 实现了 IFragmentProtocol 的协议可按配置关闭分片,
+// 如未设置 FragmentID 的 LengthFieldProtocol。嵌入 LengthFieldProtocol 并自行实现分片时需覆盖 FragmentEnabled。
+type IFragmentSwitch interface {
+	FragmentEnabled() bool
+}
+
+// FragmentConfig 分片配置
+type FragmentConfig struct {
+	Threshold     int // 组包后超过该长度的封包将被拆分
+	ChunkSize     int // 每个分片携带的数据长度, 0 表示与 Threshold 相同
+	MaxReassembly int // 重组中的封包总字节上限, 超过后关闭会话, 0 表示 DefaultMaxReassembly
+}
+
+// fragmentJob 正在分片发送的封包
+type fragmentJob struct {
+	item   *sendItem
+	data   []byte
+	pooled *Buffer
+	msgID  uint32
+	index  uint32
+	total  uint32
+}
+
+// partialPacket 正在重组的封包
+type partialPacket struct {
+	data  []byte
+	next  uint32
+	total uint32
+}
+
+// SetFragmentation 开启大封包分片发送, 需在 Start 之前调用, 协议未实现 IFragmentProtocol 时不生效。
+// 分片与其它封包在 sendLoop 中交替发送, 因此大封包不会长时间阻塞连接。
+func (s *Session) SetFragmentation(cfg FragmentConfig) {
+	if cfg.ChunkSize <= 0 {
+		cfg.ChunkSize = cfg.Threshold
+	}
+	if cfg.MaxReassembly <= 0 {
+		cfg.MaxReassembly = DefaultMaxReassembly
+	}
+
+	s.fragment = &cfg
+}
+
+// startFragments 组包后的数据超过阈值时转为分片任务, 返回 false 表示无需分片
+func (s *Session) startFragments(item *sendItem, data []byte, pooled *Buffer) bool {
+	if s.fragment == nil || s.fragment.Threshold <= 0 || len(data) <= s.fragment.Threshold {
+		return false
+	}
+	if _, ok := s.protocol.(IFragmentProtocol); !ok {
+		return false
+	}
+	if sw, ok := s.protocol.(IFragmentSwitch); ok && !sw.FragmentEnabled() {
+		return false
+	}
+
+	s.nextMsgID++
+	s.fragJobs = append(s.fragJobs, &fragmentJob{
+		item:   item,
+		data:   data,
+		pooled: pooled,
+		msgID:  s.nextMsgID,
+		total:  uint32((len(data) + s.fragment.ChunkSize - 1) / s.fragment.ChunkSize),
+	})
+
+	return true
+}
+
+// sendFragment 发送队首任务的下一个分片, 未发完的任务移到队尾, 多个大封包之间轮流发送
+func (s *Session) sendFragment() error {
+	job := s.fragJobs[0]
+	s.fragJobs = append(s.fragJobs[:0], s.fragJobs[1:]...)
+
+	chunkSize := s.fragment.ChunkSize
+	start := int(job.index) * chunkSize
+	end := start + chunkSize
+	if end > len(job.data) {
+		end = len(job.data)
+	}
+
+	content := s.protocol.(IFragmentProtocol).BuildFragment(&Fragment{
+		MsgID: job.msgID,
+		Index: job.index,
+		Total: job.total,
+		Data:  job.data[start:end],
+	})
+
+	if err := s.protocol.SendPacket(s.conn, content); err != nil {
+		job.finish(0, err)
+		return err
+	}
+	s.countWrite(len(content))
+
+	job.index++
+	if job.index < job.total {
+		s.fragJobs = append(s.fragJobs, job)
+		return nil
+	}
+
+	job.finish(len(job.data), nil)
+	if s.sendCallback != nil {
+		s.sendCallback(s.conn, job.item.packet)
+	}
+
+	return nil
+}
+
+// abortFragments 会话关闭时结束所有未发完的分片任务
+func (s *Session) abortFragments(err error) {
+	for _, job := range s.fragJobs {
+		job.finish(0, err)
+	}
+	s.fragJobs = nil
+}
+
+func (job *fragmentJob) finish(n int, err error) {
+	job.item.done(n, err)
+	if job.pooled != nil {
+		job.pooled.Release()
+		job.pooled = nil
+	}
+}
+
+// reassemble 收集分片, 收齐后返回还原的封包, 未收齐时返回 nil
+func (s *Session) reassemble(frag *Fragment) (interface{}, error) {
+	fragProtocol, ok := s.protocol.(IFragmentProtocol)
+	if !ok {
+		return nil, ErrFragmentUnsupported
+	}
+
+	partial := s.partials[frag.MsgID]
+	if partial == nil {
+		if frag.Index != 0 || frag.Total == 0 {
+			return nil, fmt.Errorf("%w: msg %d index %d", ErrFragmentCorrupted, frag.MsgID, frag.Index)
+		}
+
+		partial = &partialPacket{total: frag.Total}
+		if s.partials == nil {
+			s.partials = make(map[uint32]*partialPacket)
+		}
+		s.partials[frag.MsgID] = partial
+	} else if frag.Index != partial.next || frag.Total != partial.total {
+		return nil, fmt.Errorf("%w: msg %d index %d", ErrFragmentCorrupted, frag.MsgID, frag.Index)
+	}
+
+	maxReassembly := DefaultMaxReassembly
+	if s.fragment != nil {
+		maxReassembly = s.fragment.MaxReassembly
+	}
+	if s.reassembling+len(frag.Data) > maxReassembly {
+		return nil, ErrReassemblyOverflow
+	}
+
+	partial.data = append(partial.data, frag.Data...)
+	partial.next++
+	s.reassembling += len(frag.Data)
+
+	if partial.next < partial.total {
+		return nil, nil
+	}
+
+	delete(s.partials, frag.MsgID)
+	s.reassembling -= len(partial.data)
+
+	return fragProtocol.ParsePacket(partial.data)
+}
@@ -0,0 +1,131 @@
+package socketgo
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func newFragmentProtocol() *LengthFieldProtocol {
+	protocol := newTestProtocol()
+	protocol.FragmentID = 0xFFFE
+	return protocol
+}
+
+// newFragmentPair 在内存连接两端各启动一个会话, 接收端收到的封包写入返回的通道
+func newFragmentPair(t *testing.T, sender, receiver FragmentConfig) (*Session, *Session, <-chan []byte) {
+	t.Helper()
+
+	received := make(chan []byte, 16)
+	local, remote := net.Pipe()
+
+	out := NewSession(local, newFragmentProtocol(), func(ISession, interface{}) {}, 16)
+	out.SetFragmentation(sender)
+	in := NewSession(remote, newFragmentProtocol(), func(_ ISession, packet interface{}) {
+		received <- append([]byte(nil), packet.(*Buffer).B...)
+	}, 16)
+	in.SetFragmentation(receiver)
+
+	out.Start()
+	in.Start()
+	t.Cleanup(func() {
+		_ = out.Close()
+		_ = in.Close()
+	})
+
+	return out, in, received
+}
+
+func TestLengthFieldFragmentRoundTrip(t *testing.T) {
+	out, _, received := newFragmentPair(t, FragmentConfig{Threshold: 64}, FragmentConfig{})
+
+	protocol := newFragmentProtocol()
+	large := newTestFrame(1, bytes.Repeat([]byte("0123456789"), 100))
+	small := newTestFrame(2, []byte("small"))
+
+	if err := out.Send(large); err != nil {
+		t.Fatal(err)
+	}
+	if err := out.Send(small); err != nil {
+		t.Fatal(err)
+	}
+
+	// 小封包可能在大封包的分片之间先到达
+	want := map[uint32][]byte{1: protocol.BuildPacket(large), 2: protocol.BuildPacket(small)}
+	for len(want) > 0 {
+		select {
+		case packet := <-received:
+			id := protocol.PacketID(packet)
+			if !bytes.Equal(packet, want[id]) {
+				t.Fatalf("packet %d mismatch: %d bytes", id, len(packet))
+			}
+			delete(want, id)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("missing packets %v", want)
+		}
+	}
+}
+
+func TestLengthFieldFragmentOverflow(t *testing.T) {
+	out, in, received := newFragmentPair(t, FragmentConfig{Threshold: 64}, FragmentConfig{MaxReassembly: 256})
+
+	if err := out.Send(newTestFrame(1, make([]byte, 1000))); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-in.stopedChan:
+	case packet := <-received:
+		t.Fatalf("reassembled %d bytes past MaxReassembly", len(packet))
+	case <-time.After(5 * time.Second):
+		t.Fatal("receiver not closed")
+	}
+
+	var reason *CloseReason
+	if !errors.As(in.Err(), &reason) || reason.Kind != CloseDecodeError || !errors.Is(reason, ErrReassemblyOverflow) {
+		t.Fatalf("receiver err = %v, want reassembly overflow", in.Err())
+	}
+}
+
+func TestLengthFieldFragmentCorrupted(t *testing.T) {
+	protocol := newFragmentProtocol()
+
+	// 分片帧的包体不足以容纳分片信息
+	frame := protocol.BuildPacket(newTestFrame(protocol.FragmentID, []byte{1, 2, 3}))
+	if _, err := readFrom(t, protocol, frame); !errors.Is(err, ErrFragmentCorrupted) {
+		t.Fatalf("got %v, want ErrFragmentCorrupted", err)
+	}
+
+	if _, err := protocol.ParsePacket(newTestFrame(1, []byte("body"))); !errors.Is(err, ErrFragmentCorrupted) {
+		t.Fatalf("got %v, want ErrFragmentCorrupted for an unfilled length field", err)
+	}
+}
+
+func TestLengthFieldFragmentDisabled(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+
+	// 未设置 FragmentID 时即使配置了分片也整包发送
+	session := NewSession(local, newTestProtocol(), func(ISession, interface{}) {}, 4)
+	session.SetFragmentation(FragmentConfig{Threshold: 16})
+	session.Start()
+	defer session.Close()
+
+	frame := newTestFrame(1, make([]byte, 100))
+	if err := session.Send(frame); err != nil {
+		t.Fatal(err)
+	}
+
+	packet, err := newTestProtocol().ReadPacket(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := packet.(*Buffer)
+	defer buf.Release()
+
+	if len(buf.B) != len(frame) {
+		t.Fatalf("got %d bytes, want the whole %d byte frame", len(buf.B), len(frame))
+	}
+}
@@ -0,0 +1,123 @@
+package socketgo
+
+// EncodedPacket 已组包的封包, 发送时不再调用 BuildPacket, 直接写出这些字节。
+// 同一个 EncodedPacket 可以发送给多个会话, 发送期间不能修改其内容。
+type EncodedPacket []byte
+
+// EncodePacket 使用 protocol 组包一次, 结果可发送给多个会话。封包未通过 IPacketChecker 的检查时 panic, 与 BuildPacket 相同。
+func EncodePacket(protocol IPacketProtocol, packet interface{}) EncodedPacket {
+	content, pooled, err := buildPacket(protocol, packet)
+	if err != nil {
+		panic(err)
+	}
+	if pooled == nil {
+		return content
+	}
+
+	// 池化的缓冲区会被复用, 广播的封包需要独立的副本
+	encoded := append(EncodedPacket(nil), content...)
+	pooled.Release()
+
+	return encoded
+}
+
+// BroadcastFailure 广播时发送失败的成员
+type BroadcastFailure struct {
+	Session ISession
+	Err     error
+}
+
+// BroadcastReport 广播结果, 成功表示封包已进入成员的发送队列
+type BroadcastReport struct {
+	Sent   int                // 成功的成员数
+	Failed []BroadcastFailure // 失败的成员及原因
+}
+
+// Join 将会话加入分组, 会话关闭时自动离开所有分组。会话已关闭时返回关闭原因。
+func (s *Server) Join(group string, session ISession) error {
+	s.sessionLock.Lock()
+	if s.groups == nil {
+		s.groups = make(map[string]map[ISession]struct{})
+	}
+	members, ok := s.groups[group]
+	if !ok {
+		members = make(map[ISession]struct{})
+		s.groups[group] = members
+	}
+	members[session] = struct{}{}
+	s.sessionLock.Unlock()
+
+	// 会话的关闭钩子可能在加入前已执行, 此时需自行移除
+	if err := session.Err(); err != nil {
+		s.Leave(group, session)
+		return err
+	}
+
+	return nil
+}
+
+// Leave 将会话移出分组, 分组为空时删除
+func (s *Server) Leave(group string, session ISession) {
+	s.sessionLock.Lock()
+	defer s.sessionLock.Unlock()
+
+	if members, ok := s.groups[group]; ok {
+		delete(members, session)
+		if len(members) == 0 {
+			delete(s.groups, group)
+		}
+	}
+}
+
+// Members 获取分组成员的快照
+func (s *Server) Members(group string) []ISession {
+	s.sessionLock.Lock()
+	defer s.sessionLock.Unlock()
+
+	members := make([]ISession, 0, len(s.groups[group]))
+	for session := range s.groups[group] {
+		members = append(members, session)
+	}
+
+	return members
+}
+
+// Broadcast 向分组的所有成员发送封包: 只组包一次, 之后将相同的字节放入各成员的发送队列。
+// packet 已是 EncodedPacket 时不再组包。
+func (s *Server) Broadcast(group string, packet interface{}) BroadcastReport {
+	encoded, ok := packet.(EncodedPacket)
+	if !ok {
+		encoded = EncodePacket(s.protocol, packet)
+	}
+
+	var report BroadcastReport
+	for _, session := range s.Members(group) {
+		if err := session.Send(encoded); err != nil {
+			report.Failed = append(report.Failed, BroadcastFailure{Session: session, Err: err})
+			continue
+		}
+		report.Sent++
+	}
+
+	return report
+}
+
+// removeSession 会话关闭后将其移出 SessionMng 与所有分组
+func (s *Server) removeSession(session ISession) {
+	s.sessionLock.Lock()
+	defer s.sessionLock.Unlock()
+
+	for i, member := range s.SessionMng {
+		if member == session {
+			s.SessionMng = append(s.SessionMng[:i], s.SessionMng[i+1:]...)
+			break
+		}
+	}
+
+	for group, members := range s.groups {
+		delete(members, session)
+		if len(members) == 0 {
+			delete(s.groups, group)
+		}
+	}
+}
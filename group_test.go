@@ -0,0 +1,170 @@
+package socketgo_test
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	socket "github.com/datochan/socketgo"
+	"github.com/datochan/socketgo/socketgotest"
+)
+
+func newGroupProtocol() *socket.LengthFieldProtocol {
+	return &socket.LengthFieldProtocol{HeaderSize: 8, LengthOffset: 4, LengthSize: 4, IDSize: 4, ByteOrder: binary.LittleEndian}
+}
+
+// groupServer 在内存 listener 上启动服务端, 返回服务端与 n 个客户端连接对应的服务端会话
+func groupServer(t *testing.T, sendChanSize, pipeCapacity, n int) (*socket.Server, []net.Conn, []socket.ISession) {
+	t.Helper()
+
+	listener := socketgotest.NewListener("group")
+	listener.SetPipeCapacity(pipeCapacity)
+
+	server := socket.NewServerWithListener(listener, newGroupProtocol(), socket.NewDispatcher())
+	server.SetSendChanSize(sendChanSize)
+	go server.AcceptLoop()
+	t.Cleanup(server.Close)
+
+	var clients []net.Conn
+	for i := 0; i < n; i++ {
+		conn, err := listener.Dial("mem", "group")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = conn.Close() })
+		clients = append(clients, conn)
+	}
+
+	// 按客户端的顺序排列服务端会话
+	sessions := make([]socket.ISession, n)
+	deadline := time.Now().Add(5 * time.Second)
+	for found := 0; found < n; {
+		if time.Now().After(deadline) {
+			t.Fatalf("only %d of %d sessions accepted", found, n)
+		}
+
+		found = 0
+		for _, session := range server.Sessions() {
+			for i, conn := range clients {
+				if session.RawConn().RemoteAddr().String() == conn.LocalAddr().String() {
+					sessions[i] = session
+					found++
+				}
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	return server, clients, sessions
+}
+
+// groupFrame 事件7的空包体封包
+func groupFrame() []byte {
+	frame := make([]byte, 8)
+	binary.LittleEndian.PutUint32(frame, 7)
+	return frame
+}
+
+func hasMember(members []socket.ISession, session socket.ISession) bool {
+	for _, member := range members {
+		if member == session {
+			return true
+		}
+	}
+	return false
+}
+
+func TestGroupJoinLeaveBroadcast(t *testing.T) {
+	server, clients, sessions := groupServer(t, socket.DefaultSendChanSize, socketgotest.DefaultPipeCapacity, 3)
+
+	for _, session := range sessions {
+		if err := server.Join("room", session); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := server.Join("other", sessions[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	report := server.Broadcast("room", groupFrame())
+	if report.Sent != 3 || len(report.Failed) != 0 {
+		t.Fatalf("report %+v, want 3 sent", report)
+	}
+	for i, conn := range clients {
+		buf := make([]byte, 8)
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, err := conn.Read(buf); err != nil || binary.LittleEndian.Uint32(buf) != 7 {
+			t.Fatalf("client %d read %v, %v", i, buf, err)
+		}
+	}
+
+	server.Leave("room", sessions[1])
+	if members := server.Members("room"); len(members) != 2 || hasMember(members, sessions[1]) {
+		t.Fatalf("members after Leave: %d, left member still present: %v", len(members), hasMember(members, sessions[1]))
+	}
+
+	// 成员关闭后自动离开所有分组, 空分组被删除
+	_ = clients[0].Close()
+	select {
+	case <-sessions[0].Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("server session not closed after the client closed")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for hasMember(server.Members("room"), sessions[0]) || len(server.Members("other")) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("closed member not removed from its groups")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if members := server.Members("room"); len(members) != 1 || members[0] != sessions[2] {
+		t.Fatalf("room has %d members, want only the remaining session", len(members))
+	}
+
+	// 已关闭的会话不能再加入分组
+	if err := server.Join("room", sessions[0]); err == nil || hasMember(server.Members("room"), sessions[0]) {
+		t.Fatalf("Join of a closed session = %v", err)
+	}
+}
+
+func TestGroupBroadcastReportsFailedMember(t *testing.T) {
+	// 发送队列与管道都很小, 不读取的客户端很快使其会话的发送队列满载
+	server, clients, sessions := groupServer(t, 1, 16, 2)
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			if _, err := clients[0].Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	for _, session := range sessions {
+		if err := server.Join("room", session); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	encoded := socket.EncodePacket(newGroupProtocol(), groupFrame())
+	for i := 0; i < 100; i++ {
+		report := server.Broadcast("room", encoded)
+		if report.Sent+len(report.Failed) != 2 {
+			t.Fatalf("report %+v does not cover both members", report)
+		}
+
+		for _, failure := range report.Failed {
+			if failure.Session == sessions[1] {
+				if !errors.Is(failure.Err, socket.ErrSendChanBlocking) {
+					t.Fatalf("failure %v, want ErrSendChanBlocking", failure.Err)
+				}
+				return
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	t.Fatal("the member that stopped reading never showed up in BroadcastReport.Failed")
+}
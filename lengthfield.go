@@ -0,0 +1,325 @@
+package socketgo
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+)
+
+// DefaultMaxFrameSize LengthFieldProtocol 默认允许的最大封包长度
+const DefaultMaxFrameSize = 16 * 1024 * 1024
+
+// LengthFieldProtocol 基于包头长度字段分帧的通用协议, 实现了 IPacketProtocol。
+// ReadPacket 返回包含完整封包(包头+包体)的 *Buffer, 遵循 Buffer 的生命周期约定;
+// 发送时 packet 为完整封包的 []byte, 组包时会自动回填长度字段。
+type LengthFieldProtocol struct {
+	HeaderSize   int              // 包头长度
+	LengthOffset int              // 长度字段在包头中的偏移
+	LengthSize   int              // 长度字段字节数: 1、2、4、8
+	ByteOrder    binary.ByteOrder // 长度字段字节序
+	LengthAdjust int              // 包体长度 = 长度字段值 + LengthAdjust, 长度字段包含包头时为 -HeaderSize
+	MaxFrameSize int              // 单个封包的最大长度, 0 表示 DefaultMaxFrameSize
+	IDOffset     int              // 事件ID字段在包头中的偏移
+	IDSize       int              // 事件ID字段字节数: 0(无事件ID)、1、2、4
+	ControlID    uint32           // 订阅控制帧的事件ID, 0 表示不使用控制帧, 需同时设置 IDSize
+	FragmentID   uint32           // 分片帧的事件ID, 0 表示不支持分片, 需同时设置 IDSize
+}
+
+// fragmentHeader 分片帧包体开头的分片信息: MsgID(4) + Index(4) + Total(4), 字节序同 ByteOrder
+const fragmentHeader = 12
+
+// Validate 检查包头各字段的配置, 配置协议后可先调用它; ReadPacket 返回同样的错误, 组包时则 panic
+func (p *LengthFieldProtocol) Validate() error {
+	switch {
+	case p.HeaderSize <= 0:
+		return fmt.Errorf("%w: HeaderSize must be positive", ErrInvalidProtocol)
+	case !validFieldSize(p.LengthSize, false):
+		return fmt.Errorf("%w: LengthSize must be 1, 2, 4 or 8", ErrInvalidProtocol)
+	case p.LengthOffset < 0 || p.LengthOffset+p.LengthSize > p.HeaderSize:
+		return fmt.Errorf("%w: length field exceeds header", ErrInvalidProtocol)
+	case !validFieldSize(p.IDSize, true) || p.IDSize == 8:
+		return fmt.Errorf("%w: IDSize must be 0, 1, 2 or 4", ErrInvalidProtocol)
+	case p.IDOffset < 0 || p.IDOffset+p.IDSize > p.HeaderSize:
+		return fmt.Errorf("%w: id field exceeds header", ErrInvalidProtocol)
+	case p.ByteOrder == nil && (p.LengthSize > 1 || p.IDSize > 1):
+		return fmt.Errorf("%w: ByteOrder is required", ErrInvalidProtocol)
+	case p.ControlID != 0 && (p.IDSize == 0 || uint64(p.ControlID)>>(8*p.IDSize) != 0):
+		return fmt.Errorf("%w: ControlID does not fit in the id field", ErrInvalidProtocol)
+	case p.FragmentID != 0 && (p.IDSize == 0 || uint64(p.FragmentID)>>(8*p.IDSize) != 0):
+		return fmt.Errorf("%w: FragmentID does not fit in the id field", ErrInvalidProtocol)
+	case p.FragmentID != 0 && p.FragmentID == p.ControlID:
+		return fmt.Errorf("%w: FragmentID and ControlID must differ", ErrInvalidProtocol)
+	case p.FragmentID != 0 && p.ByteOrder == nil:
+		return fmt.Errorf("%w: ByteOrder is required", ErrInvalidProtocol)
+	}
+
+	return nil
+}
+
+func validFieldSize(size int, allowZero bool) bool {
+	switch size {
+	case 1, 2, 4, 8:
+		return true
+	case 0:
+		return allowZero
+	default:
+		return false
+	}
+}
+
+// Body 返回封包中的包体部分
+func (p *LengthFieldProtocol) Body(frame []byte) []byte {
+	return frame[p.HeaderSize:]
+}
+
+// ReadPacket 读取一个完整封包, 不会多读属于下一个封包的数据
+func (p *LengthFieldProtocol) ReadPacket(conn net.Conn) (interface{}, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	header := GetBuffer(p.HeaderSize)
+	defer header.Release()
+
+	if _, err := io.ReadFull(conn, header.B); err != nil {
+		return nil, err
+	}
+
+	// 先以 uint64 与上限比较, 8 字节的长度字段转换为 int 时可能溢出
+	length := p.readLength(header.B)
+	if length > uint64(p.maxFrameSize()) {
+		return nil, ErrFrameTooLarge
+	}
+
+	bodyLen := int(length) + p.LengthAdjust
+	if bodyLen < 0 || p.HeaderSize+bodyLen > p.maxFrameSize() {
+		return nil, ErrFrameTooLarge
+	}
+
+	frame := GetBuffer(p.HeaderSize + bodyLen)
+	copy(frame.B, header.B)
+	if _, err := io.ReadFull(conn, frame.B[p.HeaderSize:]); err != nil {
+		frame.Release()
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	if p.FragmentID != 0 && p.PacketID(frame) == p.FragmentID {
+		return p.readFragment(frame)
+	}
+
+	return frame, nil
+}
+
+// readFragment 将分片帧解析为 *Fragment。
+// Data 直接引用 frame, 重组时会被复制, 因此 frame 不再归还池中, 交给 GC 回收。
+func (p *LengthFieldProtocol) readFragment(frame *Buffer) (interface{}, error) {
+	body := p.Body(frame.B)
+	if len(body) < fragmentHeader {
+		frame.Release()
+		return nil, fmt.Errorf("%w: short fragment header", ErrFragmentCorrupted)
+	}
+
+	return &Fragment{
+		MsgID: p.ByteOrder.Uint32(body[0:]),
+		Index: p.ByteOrder.Uint32(body[4:]),
+		Total: p.ByteOrder.Uint32(body[8:]),
+		Data:  body[fragmentHeader:],
+	}, nil
+}
+
+// FragmentEnabled 实现 IFragmentSwitch, 设置了 FragmentID 且包头可以容纳它时才拆分封包
+func (p *LengthFieldProtocol) FragmentEnabled() bool {
+	return p.FragmentID != 0 && p.Validate() == nil
+}
+
+// BuildFragment 实现 IFragmentProtocol, 分片帧的事件ID为 FragmentID, 包体为分片信息与分片内容
+func (p *LengthFieldProtocol) BuildFragment(frag *Fragment) []byte {
+	if err := p.Validate(); err != nil {
+		panic(err)
+	}
+
+	frame := make([]byte, p.HeaderSize+fragmentHeader+len(frag.Data))
+	p.writeField(frame, p.IDOffset, p.IDSize, uint64(p.FragmentID))
+	p.writeLength(frame, uint64(len(frame)-p.HeaderSize-p.LengthAdjust))
+
+	body := p.Body(frame)
+	p.ByteOrder.PutUint32(body[0:], frag.MsgID)
+	p.ByteOrder.PutUint32(body[4:], frag.Index)
+	p.ByteOrder.PutUint32(body[8:], frag.Total)
+	copy(body[fragmentHeader:], frag.Data)
+
+	return frame
+}
+
+// ParsePacket 实现 IFragmentProtocol, 重组后的数据即对端组好的完整封包, 以 *Buffer 返回
+func (p *LengthFieldProtocol) ParsePacket(data []byte) (interface{}, error) {
+	length := len(data) - p.HeaderSize - p.LengthAdjust
+	if len(data) < p.HeaderSize || length < 0 || p.readLength(data) != uint64(length) {
+		return nil, fmt.Errorf("%w: reassembled frame length mismatch", ErrFragmentCorrupted)
+	}
+
+	return &Buffer{B: data, refs: 1}, nil
+}
+
+// BuildPacket 复制封包并回填长度字段
+func (p *LengthFieldProtocol) BuildPacket(packet interface{}) []byte {
+	return p.AppendPacket(nil, packet)
+}
+
+// CheckPacket 实现 IPacketChecker: 封包须为 []byte 或 *Buffer 且包含完整的包头,
+// 整个封包不超过 MaxFrameSize, 包体长度能写入长度字段而不被截断
+func (p *LengthFieldProtocol) CheckPacket(packet interface{}) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+
+	var frame []byte
+	switch v := packet.(type) {
+	case []byte:
+		frame = v
+	case *Buffer:
+		frame = v.B
+	default:
+		return fmt.Errorf("%w: packet must be []byte or *Buffer, got %T", ErrInvalidPacket, packet)
+	}
+
+	if len(frame) < p.HeaderSize {
+		return fmt.Errorf("%w: %d bytes is shorter than the %d byte header", ErrInvalidPacket, len(frame), p.HeaderSize)
+	}
+	if len(frame) > p.maxFrameSize() {
+		return fmt.Errorf("%w: %d bytes", ErrFrameTooLarge, len(frame))
+	}
+
+	length := len(frame) - p.HeaderSize - p.LengthAdjust
+	if length < 0 || (p.LengthSize < 8 && uint64(length)>>(8*p.LengthSize) != 0) {
+		return fmt.Errorf("%w: length %d does not fit in the %d byte length field", ErrInvalidPacket, length, p.LengthSize)
+	}
+
+	return nil
+}
+
+// AppendPacket 实现 IPacketAppender, 封包未通过 CheckPacket 时 panic
+func (p *LengthFieldProtocol) AppendPacket(dst []byte, packet interface{}) []byte {
+	if err := p.CheckPacket(packet); err != nil {
+		panic(err)
+	}
+
+	frame, _ := packet.([]byte)
+	if buf, ok := packet.(*Buffer); ok {
+		frame = buf.B
+	}
+
+	start := len(dst)
+	dst = append(dst, frame...)
+	p.writeLength(dst[start:], uint64(len(frame)-p.HeaderSize-p.LengthAdjust))
+
+	return dst
+}
+
+func (p *LengthFieldProtocol) SendPacket(conn net.Conn, buff []byte) error {
+	_, err := conn.Write(buff)
+	return err
+}
+
+func (p *LengthFieldProtocol) maxFrameSize() int {
+	if p.MaxFrameSize <= 0 {
+		return DefaultMaxFrameSize
+	}
+
+	return p.MaxFrameSize
+}
+
+// PacketID 实现 IPacketIdentifier, 未设置 IDSize 时返回 0
+func (p *LengthFieldProtocol) PacketID(packet interface{}) uint32 {
+	var frame []byte
+	switch v := packet.(type) {
+	case []byte:
+		frame = v
+	case *Buffer:
+		frame = v.B
+	}
+
+	if p.IDSize == 0 || len(frame) < p.IDOffset+p.IDSize {
+		return 0
+	}
+
+	return uint32(p.readField(frame, p.IDOffset, p.IDSize))
+}
+
+// ControlPayload 实现 IControlCodec, 事件ID为 ControlID 的封包为控制帧, 包体为载荷
+func (p *LengthFieldProtocol) ControlPayload(packet interface{}) ([]byte, bool) {
+	if !p.controlEnabled() || p.PacketID(packet) != p.ControlID {
+		return nil, false
+	}
+
+	switch v := packet.(type) {
+	case []byte:
+		return p.Body(v), true
+	case *Buffer:
+		return p.Body(v.B), true
+	}
+
+	return nil, false
+}
+
+// ControlPacket 实现 IControlCodec, 组装事件ID为 ControlID、包体为载荷的封包, 长度字段在组包时回填。
+// 未设置 ControlID 或配置不合法时返回 nil。
+func (p *LengthFieldProtocol) ControlPacket(payload []byte) interface{} {
+	if !p.controlEnabled() {
+		return nil
+	}
+
+	frame := make([]byte, p.HeaderSize, p.HeaderSize+len(payload))
+	p.writeField(frame, p.IDOffset, p.IDSize, uint64(p.ControlID))
+
+	return append(frame, payload...)
+}
+
+// controlEnabled 设置了 ControlID 且包头可以容纳它
+func (p *LengthFieldProtocol) controlEnabled() bool {
+	return p.ControlID != 0 && p.Validate() == nil
+}
+
+func (p *LengthFieldProtocol) readLength(header []byte) uint64 {
+	return p.readField(header, p.LengthOffset, p.LengthSize)
+}
+
+func (p *LengthFieldProtocol) readField(header []byte, offset, size int) uint64 {
+	field := header[offset : offset+size]
+
+	switch size {
+	case 1:
+		return uint64(field[0])
+	case 2:
+		return uint64(p.ByteOrder.Uint16(field))
+	case 4:
+		return uint64(p.ByteOrder.Uint32(field))
+	case 8:
+		return p.ByteOrder.Uint64(field)
+	default:
+		return 0 // 不合法的长度已由 Validate 拒绝
+	}
+}
+
+func (p *LengthFieldProtocol) writeLength(frame []byte, length uint64) {
+	p.writeField(frame, p.LengthOffset, p.LengthSize, length)
+}
+
+func (p *LengthFieldProtocol) writeField(frame []byte, offset, size int, value uint64) {
+	field := frame[offset : offset+size]
+
+	switch size {
+	case 1:
+		field[0] = byte(value)
+	case 2:
+		p.ByteOrder.PutUint16(field, uint16(value))
+	case 4:
+		p.ByteOrder.PutUint32(field, uint32(value))
+	case 8:
+		p.ByteOrder.PutUint64(field, value)
+	}
+}
@@ -0,0 +1,202 @@
+package socketgo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+func newTestProtocol() *LengthFieldProtocol {
+	return &LengthFieldProtocol{
+		HeaderSize:   8,
+		LengthOffset: 4,
+		LengthSize:   4,
+		IDOffset:     0,
+		IDSize:       4,
+		ByteOrder:    binary.LittleEndian,
+	}
+}
+
+// newTestFrame 组装事件ID为 id、包体为 body 的封包, 长度字段在组包时回填
+func newTestFrame(id uint32, body []byte) []byte {
+	frame := make([]byte, 8, 8+len(body))
+	binary.LittleEndian.PutUint32(frame, id)
+	return append(frame, body...)
+}
+
+// readFrom 将 data 写入内存连接后用 protocol 读取一个封包
+func readFrom(t *testing.T, protocol IPacketProtocol, data []byte) (interface{}, error) {
+	t.Helper()
+
+	local, remote := net.Pipe()
+	t.Cleanup(func() {
+		_ = local.Close()
+		_ = remote.Close()
+	})
+
+	go func() {
+		_, _ = remote.Write(data)
+		_ = remote.Close()
+	}()
+
+	return protocol.ReadPacket(local)
+}
+
+func TestLengthFieldRoundTrip(t *testing.T) {
+	protocol := newTestProtocol()
+
+	packet, err := readFrom(t, protocol, protocol.BuildPacket(newTestFrame(7, []byte("hello"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := packet.(*Buffer)
+	defer buf.Release()
+
+	if protocol.PacketID(buf) != 7 || string(protocol.Body(buf.B)) != "hello" {
+		t.Fatalf("unexpected frame %v", buf.B)
+	}
+}
+
+func TestLengthFieldHugeLength(t *testing.T) {
+	protocol := &LengthFieldProtocol{
+		HeaderSize:   9,
+		LengthOffset: 1,
+		LengthSize:   8,
+		ByteOrder:    binary.BigEndian,
+		LengthAdjust: -9,
+	}
+
+	// int 转换后溢出为负数的长度
+	for _, length := range []uint64{1<<63 + 5, 1<<64 - 1, 1 << 40} {
+		header := make([]byte, 9)
+		binary.BigEndian.PutUint64(header[1:], length)
+
+		if _, err := readFrom(t, protocol, header); !errors.Is(err, ErrFrameTooLarge) {
+			t.Fatalf("length %d: got %v, want ErrFrameTooLarge", length, err)
+		}
+	}
+}
+
+func TestLengthFieldValidate(t *testing.T) {
+	cases := map[string]*LengthFieldProtocol{
+		"length size 3": {HeaderSize: 8, LengthOffset: 4, LengthSize: 3, ByteOrder: binary.LittleEndian},
+		"id size 3":     {HeaderSize: 8, LengthOffset: 4, LengthSize: 4, IDSize: 3, ByteOrder: binary.LittleEndian},
+		"id size 8":     {HeaderSize: 16, LengthOffset: 0, LengthSize: 4, IDOffset: 8, IDSize: 8, ByteOrder: binary.LittleEndian},
+		"past header":   {HeaderSize: 4, LengthOffset: 2, LengthSize: 4, ByteOrder: binary.LittleEndian},
+		"no byte order": {HeaderSize: 8, LengthOffset: 4, LengthSize: 4},
+		"control no id": {HeaderSize: 8, LengthOffset: 4, LengthSize: 4, ByteOrder: binary.LittleEndian, ControlID: 1},
+		"control range": {HeaderSize: 8, LengthOffset: 4, LengthSize: 4, IDSize: 1, ByteOrder: binary.LittleEndian, ControlID: 256},
+		"fragment id":   {HeaderSize: 8, LengthOffset: 4, LengthSize: 4, IDSize: 4, ByteOrder: binary.LittleEndian, ControlID: 9, FragmentID: 9},
+	}
+
+	for name, protocol := range cases {
+		if err := protocol.Validate(); !errors.Is(err, ErrInvalidProtocol) {
+			t.Errorf("%s: Validate = %v, want ErrInvalidProtocol", name, err)
+		}
+		if _, err := readFrom(t, protocol, make([]byte, 16)); !errors.Is(err, ErrInvalidProtocol) {
+			t.Errorf("%s: ReadPacket = %v, want ErrInvalidProtocol", name, err)
+		}
+		if packet := protocol.ControlPacket(nil); packet != nil {
+			t.Errorf("%s: ControlPacket = %v, want nil", name, packet)
+		}
+	}
+
+	if err := newTestProtocol().Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLengthFieldCheckPacket(t *testing.T) {
+	small := &LengthFieldProtocol{HeaderSize: 2, LengthOffset: 1, LengthSize: 1}
+
+	cases := []struct {
+		name     string
+		protocol *LengthFieldProtocol
+		packet   interface{}
+		want     error
+	}{
+		{"short header", newTestProtocol(), []byte{1, 2, 3}, ErrInvalidPacket},
+		{"wrong type", newTestProtocol(), "frame", ErrInvalidPacket},
+		{"length overflow", small, make([]byte, 2+300), ErrInvalidPacket},
+		{"max frame", &LengthFieldProtocol{HeaderSize: 8, LengthOffset: 4, LengthSize: 4, ByteOrder: binary.LittleEndian, MaxFrameSize: 16}, make([]byte, 17), ErrFrameTooLarge},
+	}
+
+	for _, c := range cases {
+		if err := c.protocol.CheckPacket(c.packet); !errors.Is(err, c.want) {
+			t.Errorf("%s: CheckPacket = %v, want %v", c.name, err, c.want)
+		}
+	}
+
+	if err := small.CheckPacket(make([]byte, 2+255)); err != nil {
+		t.Fatalf("CheckPacket for the largest 1-byte length = %v", err)
+	}
+}
+
+func TestSessionRejectsInvalidPacket(t *testing.T) {
+	session, remote := newPipeSession(t, newTestProtocol(), 4)
+
+	// 不合法的封包只让本次发送失败, 会话继续可用
+	if r := waitResult(t, session.SendAsync([]byte{1, 2, 3})); !errors.Is(r.Err, ErrInvalidPacket) {
+		t.Fatalf("SendAsync = %+v, want ErrInvalidPacket", r)
+	}
+
+	frame := newTestProtocol().BuildPacket(newTestFrame(1, []byte("ok")))
+	result := session.SendAsync(newTestFrame(1, []byte("ok")))
+	received := make([]byte, len(frame))
+	if _, err := io.ReadFull(remote, received); err != nil {
+		t.Fatal(err)
+	}
+	if r := waitResult(t, result); r.Err != nil || !bytes.Equal(received, frame) {
+		t.Fatalf("SendAsync = %+v, received %v, want %v", r, received, frame)
+	}
+}
+
+// repeatConn 不断重复返回同一段数据的连接
+type repeatConn struct {
+	net.Conn
+	data []byte
+	off  int
+}
+
+func (c *repeatConn) Read(p []byte) (int, error) {
+	n := copy(p, c.data[c.off:])
+	c.off = (c.off + n) % len(c.data)
+	return n, nil
+}
+
+func BenchmarkLengthFieldReadPacket(b *testing.B) {
+	protocol := newTestProtocol()
+	conn := &repeatConn{data: protocol.BuildPacket(newTestFrame(1, make([]byte, 120)))}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		packet, err := protocol.ReadPacket(conn)
+		if err != nil {
+			b.Fatal(err)
+		}
+		packet.(*Buffer).Release()
+	}
+}
+
+func BenchmarkLengthFieldAppendPacket(b *testing.B) {
+	protocol := newTestProtocol()
+	frame := newTestFrame(1, make([]byte, 120))
+	var packet interface{} = frame // 装箱的开销不计入组包
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		content, pooled, err := buildPacket(protocol, packet)
+		if err != nil || len(content) != len(frame) {
+			b.Fatal("short packet")
+		}
+		pooled.Release()
+	}
+}
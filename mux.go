@@ -0,0 +1,594 @@
+package socketgo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// 多路复用帧格式(参考 yamux), 包头固定 12 字节, 大端序:
+// | version(1) | type(1) | flags(2) | streamID(4) | length(4) |
+// Data 帧的 length 为数据长度, WindowUpdate 帧的 length 为窗口增量。
+const (
+	muxVersion    = 0
+	muxHeaderSize = 12
+
+	muxTypeData         = 0
+	muxTypeWindowUpdate = 1
+	muxTypeGoAway       = 2
+
+	muxFlagSYN = 1 << 0 // 新建流
+	muxFlagFIN = 1 << 2 // 半关闭, 不再发送数据
+	muxFlagRST = 1 << 3 // 重置流
+
+	DefaultStreamWindow = 256 * 1024 // 每个流初始的接收窗口
+	muxMaxDataFrame     = 32 * 1024  // 单个 Data 帧的最大长度, 避免单个流长时间占用连接
+	muxAcceptBacklog    = 256
+	muxGoAwayTimeout    = time.Second // Close 时写出 GoAway 的超时, 对端不读取时不阻塞关闭
+)
+
+// Mux 在单个连接上复用多个逻辑流, 每个流都实现了 net.Conn,
+// 因此现有的 IPacketProtocol 与 Session 可以原样运行在流上。
+// Mux 同时实现了 net.Listener, 可直接交给 NewServerWithListener 使用。
+type Mux struct {
+	conn   net.Conn
+	client bool
+	clock  Clock
+
+	lock    sync.Mutex
+	streams map[uint32]*Stream
+	nextID  uint32
+
+	writeLock sync.Mutex // 保证帧的完整写入
+	writeBufs net.Buffers
+
+	acceptChan chan *Stream
+	stopedChan chan struct{}
+	once       sync.Once
+	err        error
+}
+
+// NewMux 在 conn 上建立多路复用, 连接两端一方 client 为 true, 另一方为 false
+func NewMux(conn net.Conn, client bool) *Mux {
+	m := &Mux{
+		conn:       conn,
+		client:     client,
+		clock:      RealClock,
+		streams:    make(map[uint32]*Stream),
+		acceptChan: make(chan *Stream, muxAcceptBacklog),
+		stopedChan: make(chan struct{}),
+	}
+
+	// 客户端使用奇数编号, 服务端使用偶数编号, 避免双方同时新建流时冲突
+	if client {
+		m.nextID = 1
+	} else {
+		m.nextID = 2
+	}
+
+	go m.recvLoop()
+
+	return m
+}
+
+// SetClock 替换流的读写截止时间使用的时钟, 需在打开或接受流之前调用
+func (m *Mux) SetClock(clock Clock) {
+	m.clock = clockOrDefault(clock)
+}
+
+// OpenStream 新建一个流, 对端通过 AcceptStream 获得
+func (m *Mux) OpenStream() (*Stream, error) {
+	m.lock.Lock()
+	if m.isClosed() {
+		m.lock.Unlock()
+		return nil, ErrMuxClosed
+	}
+
+	stream := newStream(m, m.nextID)
+	m.streams[stream.id] = stream
+	m.nextID += 2
+	m.lock.Unlock()
+
+	if err := m.writeFrame(muxTypeWindowUpdate, muxFlagSYN, stream.id, 0, nil); err != nil {
+		m.removeStream(stream.id)
+		return nil, err
+	}
+
+	return stream, nil
+}
+
+// AcceptStream 等待对端新建的流
+func (m *Mux) AcceptStream() (*Stream, error) {
+	select {
+	case stream := <-m.acceptChan:
+		return stream, nil
+	case <-m.stopedChan:
+		return nil, ErrMuxClosed
+	}
+}
+
+// Accept 实现 net.Listener
+func (m *Mux) Accept() (net.Conn, error) {
+	return m.AcceptStream()
+}
+
+// Addr 实现 net.Listener
+func (m *Mux) Addr() net.Addr {
+	return m.conn.LocalAddr()
+}
+
+// Close 关闭底层连接, 所有流随之失效
+func (m *Mux) Close() error {
+	m.shutdown(nil)
+	return nil
+}
+
+// NumStreams 当前打开的流数量
+func (m *Mux) NumStreams() int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return len(m.streams)
+}
+
+func (m *Mux) isClosed() bool {
+	select {
+	case <-m.stopedChan:
+		return true
+	default:
+		return false
+	}
+}
+
+func (m *Mux) shutdown(err error) {
+	m.once.Do(func() {
+		if err == nil {
+			// 写超时同时打断其它阻塞中的写入, 否则 writeLock 可能一直被占用
+			_ = m.conn.SetWriteDeadline(m.clock.Now().Add(muxGoAwayTimeout))
+			_ = m.writeFrame(muxTypeGoAway, 0, 0, 0, nil)
+			err = ErrMuxClosed
+		}
+
+		m.lock.Lock()
+		m.err = err
+		close(m.stopedChan)
+		streams := m.streams
+		m.streams = make(map[uint32]*Stream)
+		m.lock.Unlock()
+
+		_ = m.conn.Close()
+		for _, stream := range streams {
+			stream.notify()
+		}
+	})
+}
+
+func (m *Mux) removeStream(id uint32) {
+	m.lock.Lock()
+	delete(m.streams, id)
+	m.lock.Unlock()
+}
+
+func (m *Mux) writeFrame(typ uint8, flags uint16, id uint32, length uint32, data []byte) error {
+	var header [muxHeaderSize]byte
+	header[0] = muxVersion
+	header[1] = typ
+	binary.BigEndian.PutUint16(header[2:], flags)
+	binary.BigEndian.PutUint32(header[4:], id)
+	binary.BigEndian.PutUint32(header[8:], length)
+
+	m.writeLock.Lock()
+	defer m.writeLock.Unlock()
+
+	m.writeBufs = append(m.writeBufs[:0], header[:], data)
+	if _, err := m.writeBufs.WriteTo(m.conn); err != nil {
+		go m.shutdown(err)
+		return err
+	}
+
+	return nil
+}
+
+func (m *Mux) recvLoop() {
+	var header [muxHeaderSize]byte
+
+	for {
+		if _, err := io.ReadFull(m.conn, header[:]); err != nil {
+			m.shutdown(err)
+			return
+		}
+
+		if header[0] != muxVersion {
+			m.shutdown(fmt.Errorf("%w: version %d", ErrMuxProtocol, header[0]))
+			return
+		}
+
+		typ := header[1]
+		flags := binary.BigEndian.Uint16(header[2:])
+		id := binary.BigEndian.Uint32(header[4:])
+		length := binary.BigEndian.Uint32(header[8:])
+
+		if typ == muxTypeGoAway {
+			m.shutdown(ErrMuxClosed)
+			return
+		}
+
+		stream := m.lookupStream(id, flags)
+
+		switch typ {
+		case muxTypeData:
+			if err := m.recvData(stream, length); err != nil {
+				m.shutdown(err)
+				return
+			}
+		case muxTypeWindowUpdate:
+			if stream != nil {
+				stream.addSendWindow(length)
+			}
+		default:
+			m.shutdown(fmt.Errorf("%w: type %d", ErrMuxProtocol, typ))
+			return
+		}
+
+		if stream != nil && flags&muxFlagFIN != 0 {
+			stream.remoteClose()
+		}
+		if stream != nil && flags&muxFlagRST != 0 {
+			stream.remoteReset()
+		}
+	}
+}
+
+// lookupStream 查找流, 带 SYN 标记时新建对端发起的流
+func (m *Mux) lookupStream(id uint32, flags uint16) *Stream {
+	m.lock.Lock()
+	stream := m.streams[id]
+	if stream != nil || flags&muxFlagSYN == 0 || m.isClosed() {
+		m.lock.Unlock()
+		return stream
+	}
+
+	stream = newStream(m, id)
+	m.streams[id] = stream
+	m.lock.Unlock()
+
+	select {
+	case m.acceptChan <- stream:
+	default:
+		// 来不及处理的新流直接重置
+		fmt.Printf("mux accept backlog full, reset stream %d\n", id)
+		stream.Reset()
+	}
+
+	return stream
+}
+
+func (m *Mux) recvData(stream *Stream, length uint32) error {
+	if stream == nil {
+		// 已关闭的流仍可能收到在途数据, 直接丢弃
+		_, err := io.CopyN(io.Discard, m.conn, int64(length))
+		return err
+	}
+
+	return stream.recvData(m.conn, length)
+}
+
+// Stream 多路复用中的单个逻辑流, 实现 net.Conn
+type Stream struct {
+	id  uint32
+	mux *Mux
+
+	lock         sync.Mutex
+	recvBuf      bytes.Buffer
+	recvWindow   uint32 // 对端剩余可发送的字节数
+	recvConsumed uint32 // 上次窗口更新后已读取的字节数
+	sendWindow   uint32 // 本端剩余可发送的字节数
+
+	localClosed  bool // 已发送 FIN 或调用了 Close
+	readClosed   bool // 调用了 Close, 不再读取
+	remoteClosed bool // 已收到 FIN
+	reset        bool
+
+	readNotify    chan struct{} // 收到数据或状态变化时通知读取方
+	writeNotify   chan struct{} // 窗口更新或状态变化时通知写入方
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+func newStream(mux *Mux, id uint32) *Stream {
+	return &Stream{
+		id:          id,
+		mux:         mux,
+		recvWindow:  DefaultStreamWindow,
+		sendWindow:  DefaultStreamWindow,
+		readNotify:  make(chan struct{}, 1),
+		writeNotify: make(chan struct{}, 1),
+	}
+}
+
+// ID 流编号
+func (s *Stream) ID() uint32 {
+	return s.id
+}
+
+// notify 状态变化时同时唤醒读写双方
+func (s *Stream) notify() {
+	wakeup(s.readNotify)
+	wakeup(s.writeNotify)
+}
+
+func wakeup(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// waitNotify 等待 notifyChan 的通知, 按 clock 计时超过 deadline 返回 os.ErrDeadlineExceeded
+func waitNotify(clock Clock, notifyChan chan struct{}, deadline time.Time) error {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		delay := deadline.Sub(clock.Now())
+		if delay <= 0 {
+			return os.ErrDeadlineExceeded
+		}
+
+		timer := clock.NewTimer(delay)
+		defer timer.Stop()
+		timeout = timer.C()
+	}
+
+	select {
+	case <-notifyChan:
+		return nil
+	case <-timeout:
+		return os.ErrDeadlineExceeded
+	}
+}
+
+// Read 实现 net.Conn, 对端半关闭且数据读完后返回 io.EOF
+func (s *Stream) Read(b []byte) (int, error) {
+	for {
+		s.lock.Lock()
+		switch {
+		case s.readClosed:
+			s.lock.Unlock()
+			return 0, ErrStreamClosed
+		case s.recvBuf.Len() > 0:
+			n, _ := s.recvBuf.Read(b)
+			delta := s.consumed(uint32(n))
+			s.lock.Unlock()
+
+			if delta > 0 {
+				_ = s.mux.writeFrame(muxTypeWindowUpdate, 0, s.id, delta, nil)
+			}
+			return n, nil
+		case s.reset:
+			s.lock.Unlock()
+			return 0, ErrStreamReset
+		case s.remoteClosed:
+			s.lock.Unlock()
+			return 0, io.EOF
+		case s.mux.isClosed():
+			s.lock.Unlock()
+			return 0, ErrMuxClosed
+		}
+		deadline := s.readDeadline
+		s.lock.Unlock()
+
+		if err := waitNotify(s.mux.clock, s.readNotify, deadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// consumed 记录已读取的字节数, 累计超过半个窗口时返回需要归还给对端的窗口增量
+func (s *Stream) consumed(n uint32) uint32 {
+	s.recvConsumed += n
+	if s.recvConsumed < DefaultStreamWindow/2 || s.remoteClosed {
+		return 0
+	}
+
+	delta := s.recvConsumed
+	s.recvConsumed = 0
+	s.recvWindow += delta
+
+	return delta
+}
+
+// Write 实现 net.Conn, 受对端接收窗口限制
+func (s *Stream) Write(b []byte) (int, error) {
+	written := 0
+
+	for written < len(b) {
+		s.lock.Lock()
+		switch {
+		case s.reset:
+			s.lock.Unlock()
+			return written, ErrStreamReset
+		case s.localClosed:
+			s.lock.Unlock()
+			return written, ErrStreamClosed
+		case s.mux.isClosed():
+			s.lock.Unlock()
+			return written, ErrMuxClosed
+		}
+
+		if s.sendWindow == 0 {
+			deadline := s.writeDeadline
+			s.lock.Unlock()
+
+			if err := waitNotify(s.mux.clock, s.writeNotify, deadline); err != nil {
+				return written, err
+			}
+			continue
+		}
+
+		chunk := len(b) - written
+		if chunk > int(s.sendWindow) {
+			chunk = int(s.sendWindow)
+		}
+		if chunk > muxMaxDataFrame {
+			chunk = muxMaxDataFrame
+		}
+		s.sendWindow -= uint32(chunk)
+		s.lock.Unlock()
+
+		if err := s.mux.writeFrame(muxTypeData, 0, s.id, uint32(chunk), b[written:written+chunk]); err != nil {
+			return written, err
+		}
+		written += chunk
+	}
+
+	return written, nil
+}
+
+// CloseWrite 半关闭, 通知对端不再发送数据, 仍可继续读取
+func (s *Stream) CloseWrite() error {
+	s.lock.Lock()
+	if s.localClosed || s.reset {
+		s.lock.Unlock()
+		return nil
+	}
+	s.localClosed = true
+	done := s.remoteClosed
+	s.lock.Unlock()
+
+	err := s.mux.writeFrame(muxTypeData, muxFlagFIN, s.id, 0, nil)
+	if done {
+		s.mux.removeStream(s.id)
+	}
+
+	return err
+}
+
+// Close 实现 net.Conn, 关闭读写两个方向。
+// 对端尚未半关闭时重置流, 否则对端之后的 Write 会因窗口耗尽而一直阻塞。
+func (s *Stream) Close() error {
+	s.lock.Lock()
+	s.readClosed = true
+	s.recvBuf.Reset()
+	remoteOpen := !s.remoteClosed
+	s.lock.Unlock()
+	s.notify()
+
+	if remoteOpen {
+		return s.Reset()
+	}
+	return s.CloseWrite()
+}
+
+// Reset 立即终止流, 双方未读写的数据全部丢弃
+func (s *Stream) Reset() error {
+	s.lock.Lock()
+	if s.reset {
+		s.lock.Unlock()
+		return nil
+	}
+	s.reset = true
+	s.lock.Unlock()
+	s.notify()
+
+	s.mux.removeStream(s.id)
+	return s.mux.writeFrame(muxTypeWindowUpdate, muxFlagRST, s.id, 0, nil)
+}
+
+func (s *Stream) recvData(r io.Reader, length uint32) error {
+	// 先检查窗口再分配缓冲区, 对端声明的长度不可信。
+	// recvWindow 只在 recvLoop 中减少, 释放锁后读取数据期间它只会增加。
+	s.lock.Lock()
+	if length > s.recvWindow {
+		s.lock.Unlock()
+		return fmt.Errorf("%w: stream %d exceeds receive window", ErrMuxProtocol, s.id)
+	}
+	discard := s.readClosed || s.reset
+	s.lock.Unlock()
+
+	// 不再读取的流直接丢弃数据, 也不占用窗口, 否则窗口耗尽后不会再有窗口更新
+	if discard {
+		_, err := io.CopyN(io.Discard, r, int64(length))
+		return err
+	}
+
+	// 先读出完整数据再加锁, 避免网络读取阻塞本流的 Read
+	buf := GetBuffer(int(length))
+	defer buf.Release()
+
+	if _, err := io.ReadFull(r, buf.B); err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.readClosed || s.reset {
+		return nil
+	}
+
+	s.recvWindow -= length
+	s.recvBuf.Write(buf.B)
+	wakeup(s.readNotify)
+
+	return nil
+}
+
+func (s *Stream) addSendWindow(delta uint32) {
+	s.lock.Lock()
+	s.sendWindow += delta
+	s.lock.Unlock()
+	wakeup(s.writeNotify)
+}
+
+func (s *Stream) remoteClose() {
+	s.lock.Lock()
+	s.remoteClosed = true
+	done := s.localClosed
+	s.lock.Unlock()
+	s.notify()
+
+	if done {
+		s.mux.removeStream(s.id)
+	}
+}
+
+func (s *Stream) remoteReset() {
+	s.lock.Lock()
+	s.reset = true
+	s.lock.Unlock()
+	s.notify()
+
+	s.mux.removeStream(s.id)
+}
+
+func (s *Stream) LocalAddr() net.Addr {
+	return s.mux.conn.LocalAddr()
+}
+
+func (s *Stream) RemoteAddr() net.Addr {
+	return s.mux.conn.RemoteAddr()
+}
+
+func (s *Stream) SetDeadline(t time.Time) error {
+	_ = s.SetReadDeadline(t)
+	return s.SetWriteDeadline(t)
+}
+
+func (s *Stream) SetReadDeadline(t time.Time) error {
+	s.lock.Lock()
+	s.readDeadline = t
+	s.lock.Unlock()
+	s.notify()
+	return nil
+}
+
+func (s *Stream) SetWriteDeadline(t time.Time) error {
+	s.lock.Lock()
+	s.writeDeadline = t
+	s.lock.Unlock()
+	s.notify()
+	return nil
+}
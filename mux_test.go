@@ -0,0 +1,299 @@
+package socketgo
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// muxHeader 组装 Mux 帧头
+func muxHeader(typ uint8, flags uint16, id uint32, length uint32) []byte {
+	header := make([]byte, muxHeaderSize)
+	header[0] = muxVersion
+	header[1] = typ
+	binary.BigEndian.PutUint16(header[2:], flags)
+	binary.BigEndian.PutUint32(header[4:], id)
+	binary.BigEndian.PutUint32(header[8:], length)
+	return header
+}
+
+// waitMuxErr 等待 Mux 关闭并返回关闭原因
+func waitMuxErr(t *testing.T, m *Mux) error {
+	t.Helper()
+
+	select {
+	case <-m.stopedChan:
+	case <-time.After(5 * time.Second):
+		t.Fatal("mux not closed")
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.err
+}
+
+func TestMuxDataExceedsWindow(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+	m := NewMux(local, false)
+	defer m.Close()
+
+	// 只发送帧头, 声明的长度远超接收窗口, 不应等待或分配包体
+	go func() { _, _ = remote.Write(muxHeader(muxTypeData, muxFlagSYN, 1, 1<<32-1)) }()
+
+	if err := waitMuxErr(t, m); !errors.Is(err, ErrMuxProtocol) {
+		t.Fatalf("got %v, want ErrMuxProtocol", err)
+	}
+}
+
+func TestMuxClosedStreamKeepsWindow(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+	m := NewMux(local, false)
+	defer m.Close()
+
+	go func() {
+		_, _ = remote.Write(muxHeader(muxTypeData, muxFlagSYN, 1, 0))
+	}()
+	stream, err := m.AcceptStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.lock.Lock()
+	stream.readClosed = true
+	stream.lock.Unlock()
+
+	// 关闭读取后对端继续发送多于一个窗口的数据, 均被丢弃而不消耗窗口
+	chunk := make([]byte, muxMaxDataFrame)
+	for sent := 0; sent <= DefaultStreamWindow; sent += len(chunk) {
+		if _, err := remote.Write(append(muxHeader(muxTypeData, 0, 1, uint32(len(chunk))), chunk...)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stream.lock.Lock()
+	window := stream.recvWindow
+	stream.lock.Unlock()
+	if window != DefaultStreamWindow {
+		t.Fatalf("recvWindow = %d, want %d", window, DefaultStreamWindow)
+	}
+}
+
+// newMuxPair 在内存连接两端分别建立客户端与服务端 Mux
+func newMuxPair(t *testing.T) (*Mux, *Mux) {
+	t.Helper()
+
+	local, remote := net.Pipe()
+	client, server := NewMux(local, true), NewMux(remote, false)
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = server.Close()
+	})
+
+	return client, server
+}
+
+// openPair 客户端新建流并在服务端接受, 新建流的 SYN 随第一次写入或窗口帧送达
+func openPair(t *testing.T, client, server *Mux) (*Stream, *Stream) {
+	t.Helper()
+
+	local, err := client.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote, err := server.AcceptStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return local, remote
+}
+
+func TestMuxStreamRoundTrip(t *testing.T) {
+	client, server := newMuxPair(t)
+	local, remote := openPair(t, client, server)
+
+	if _, err := local.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(remote, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("server read %q, %v", buf, err)
+	}
+
+	if _, err := remote.Write([]byte("pong")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(local, buf); err != nil || string(buf) != "pong" {
+		t.Fatalf("client read %q, %v", buf, err)
+	}
+
+	if n := client.NumStreams(); n != 1 {
+		t.Fatalf("NumStreams = %d, want 1", n)
+	}
+}
+
+func TestMuxFlowControl(t *testing.T) {
+	client, server := newMuxPair(t)
+	local, remote := openPair(t, client, server)
+
+	data := make([]byte, 2*DefaultStreamWindow)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	// 对端不读取时写满一个窗口后阻塞
+	_ = local.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
+	n, err := local.Write(data)
+	if n != DefaultStreamWindow || !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Write = %d, %v, want %d, ErrDeadlineExceeded", n, err, DefaultStreamWindow)
+	}
+
+	// 对端读取后归还窗口, 剩余数据继续写出
+	_ = local.SetWriteDeadline(time.Time{})
+	written := make(chan error, 1)
+	go func() {
+		_, err := local.Write(data[n:])
+		written <- err
+	}()
+
+	received := make([]byte, len(data))
+	if _, err = io.ReadFull(remote, received); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-written; err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(received, data) {
+		t.Fatal("received data differs from sent data")
+	}
+}
+
+func TestMuxCloseWriteEOF(t *testing.T) {
+	client, server := newMuxPair(t)
+	local, remote := openPair(t, client, server)
+
+	if _, err := local.Write([]byte("request")); err != nil {
+		t.Fatal(err)
+	}
+	if err := local.CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := io.ReadAll(remote)
+	if err != nil || string(data) != "request" {
+		t.Fatalf("ReadAll = %q, %v, want request", data, err)
+	}
+
+	// 半关闭后仍可接收对端的数据
+	if _, err = remote.Write([]byte("response")); err != nil {
+		t.Fatal(err)
+	}
+	if err = remote.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if data, err = io.ReadAll(local); err != nil || string(data) != "response" {
+		t.Fatalf("ReadAll = %q, %v, want response", data, err)
+	}
+}
+
+func TestMuxReset(t *testing.T) {
+	client, server := newMuxPair(t)
+	local, remote := openPair(t, client, server)
+
+	if err := local.Reset(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := remote.Read(make([]byte, 1)); !errors.Is(err, ErrStreamReset) {
+		t.Fatalf("Read = %v, want ErrStreamReset", err)
+	}
+	if _, err := remote.Write([]byte("x")); !errors.Is(err, ErrStreamReset) {
+		t.Fatalf("Write = %v, want ErrStreamReset", err)
+	}
+	if _, err := local.Write([]byte("x")); !errors.Is(err, ErrStreamReset) {
+		t.Fatalf("Write after Reset = %v, want ErrStreamReset", err)
+	}
+}
+
+func TestMuxClosedPeerFailsWrite(t *testing.T) {
+	client, server := newMuxPair(t)
+	local, remote := openPair(t, client, server)
+
+	if err := remote.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 对端已关闭, 写入不能因等待窗口而阻塞
+	_ = local.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	if _, err := local.Write(make([]byte, 2*DefaultStreamWindow)); !errors.Is(err, ErrStreamReset) {
+		t.Fatalf("Write = %v, want ErrStreamReset", err)
+	}
+}
+
+func TestMuxCloseWithoutPeerReading(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+	m := NewMux(local, false)
+
+	closed := make(chan struct{})
+	go func() {
+		_ = m.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close blocked while the peer was not reading")
+	}
+}
+
+func TestMuxSessionOnStream(t *testing.T) {
+	client, server := newMuxPair(t)
+
+	// 服务端在接受的流上运行 Session, 以事件2应答每个封包
+	sessions := make(chan *Session, 1)
+	go func() {
+		stream, err := server.AcceptStream()
+		if err != nil {
+			close(sessions)
+			return
+		}
+		session := NewSession(stream, newTestProtocol(), func(s ISession, _ interface{}) {
+			_ = s.Send(newTestFrame(2, []byte("pong")))
+		}, 4)
+		session.Start()
+		sessions <- session
+	}()
+	defer func() {
+		if session := <-sessions; session != nil {
+			_ = session.Close()
+		}
+	}()
+
+	protocol := newTestProtocol()
+	c := NewClient(protocol)
+	c.SetDialer(func(string, string) (net.Conn, error) { return client.OpenStream() })
+	if err := c.Conn("mux", "", 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resp, err := c.Call(ctx, newTestFrame(1, []byte("ping")), func(p interface{}) bool { return protocol.PacketID(p) == 2 })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body := protocol.Body(resp.(*Buffer).B); string(body) != "pong" {
+		t.Fatalf("response body %q, want pong", body)
+	}
+}
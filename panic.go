@@ -0,0 +1,85 @@
+package socketgo
+
+import "fmt"
+
+// PanicAction handler 发生 panic 后会话的处理方式
+type PanicAction int
+
+const (
+	PanicContinue PanicAction = iota // 丢弃该封包, 继续处理之后的封包(默认)
+	PanicReply                       // 发送钩子返回的应答封包后继续
+	PanicClose                       // 以 ClosePanic 关闭会话
+)
+
+// HandlerPanic 处理单个封包时发生的 panic
+type HandlerPanic struct {
+	PanicError             // panic 的值与调用栈
+	Session    ISession    // 发生 panic 的会话
+	MessageID  uint32      // 协议实现 IPacketIdentifier 时为封包的事件ID, 否则为 0
+	Packet     interface{} // 引发 panic 的封包, 钩子返回后可能被归还到缓冲池, 不可保留
+}
+
+// FnHandlerPanic handler 发生 panic 时的钩子, 返回处理方式;
+// action 为 PanicReply 时 reply 作为应答发送给对端
+type FnHandlerPanic func(info *HandlerPanic) (action PanicAction, reply interface{})
+
+// SetHandlerPanicHook 设置 handler 发生 panic 时的钩子, 需在 Start 之前调用。
+// 未设置时打印 panic 与调用栈后继续处理之后的封包。
+func (s *Session) SetHandlerPanicHook(hook FnHandlerPanic) {
+	s.panicHook = hook
+}
+
+// dispatch 将封包交给 packetHandler, 单个封包的 panic 不会导致会话退出
+func (s *Session) dispatch(packet interface{}) {
+	defer func() {
+		if p := recover(); p != nil {
+			s.handlePanic(packet, p)
+		}
+	}()
+
+	s.packetHandler(s, packet)
+}
+
+func (s *Session) handlePanic(packet interface{}, p interface{}) {
+	info := &HandlerPanic{PanicError: *newPanicError(p), Session: s, Packet: packet}
+	if identifier, ok := s.protocol.(IPacketIdentifier); ok {
+		info.MessageID = packetID(identifier, packet)
+	}
+
+	if s.panicHook == nil {
+		fmt.Printf("handler panic recover! session: %d, id: %d, p: %+v\n%s\n", s.id, info.MessageID, p, info.Stack)
+		return
+	}
+
+	switch action, reply := s.callPanicHook(info); action {
+	case PanicReply:
+		if reply != nil {
+			_ = s.SendPriority(reply, PriorityHigh)
+		}
+	case PanicClose:
+		_ = s.CloseWithReason(ClosePanic, &info.PanicError)
+	}
+}
+
+// packetID 获取封包的事件ID, 引发 panic 的封包可能同样使 PacketID panic, 此时返回 0
+func packetID(identifier IPacketIdentifier, packet interface{}) (id uint32) {
+	defer func() {
+		if recover() != nil {
+			id = 0
+		}
+	}()
+
+	return identifier.PacketID(packet)
+}
+
+// callPanicHook 调用钩子, 钩子自身 panic 时打印后按 PanicContinue 处理, 不会使 recvLoop 退出
+func (s *Session) callPanicHook(info *HandlerPanic) (action PanicAction, reply interface{}) {
+	defer func() {
+		if p := recover(); p != nil {
+			fmt.Printf("handler panic hook panic recover! session: %d, id: %d, p: %+v\n%s\n", s.id, info.MessageID, p, newPanicError(p).Stack)
+			action, reply = PanicContinue, nil
+		}
+	}()
+
+	return s.panicHook(info)
+}
@@ -0,0 +1,148 @@
+package socketgo
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// badIDProtocol 对包体为 "bad" 的封包, PacketID 同样 panic
+type badIDProtocol struct {
+	*LengthFieldProtocol
+}
+
+func (p badIDProtocol) PacketID(packet interface{}) uint32 {
+	if string(p.Body(packet.(*Buffer).B)) == "bad" {
+		panic("bad packet id")
+	}
+	return p.LengthFieldProtocol.PacketID(packet)
+}
+
+// newPanicSession 事件1的 handler 发生 panic, 其它封包的事件ID写入 handled
+func newPanicSession(t *testing.T, protocol IPacketProtocol, hook FnHandlerPanic) (*Session, net.Conn, <-chan uint32) {
+	t.Helper()
+
+	handled := make(chan uint32, 4)
+	local, remote := net.Pipe()
+	session := NewSession(local, protocol, func(_ ISession, packet interface{}) {
+		id := newTestProtocol().PacketID(packet)
+		if id == 1 {
+			panic("boom")
+		}
+		handled <- id
+	}, 4)
+	session.SetHandlerPanicHook(hook)
+	session.Start()
+	t.Cleanup(func() {
+		_ = remote.Close()
+		_ = session.Close()
+	})
+
+	return session, remote, handled
+}
+
+func writeFrame(t *testing.T, conn net.Conn, id uint32, body string) {
+	t.Helper()
+
+	if _, err := conn.Write(newTestProtocol().BuildPacket(newTestFrame(id, []byte(body)))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expectHandled(t *testing.T, handled <-chan uint32, want uint32) {
+	t.Helper()
+
+	select {
+	case id := <-handled:
+		if id != want {
+			t.Fatalf("handled %d, want %d", id, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("packet %d not handled, session stopped processing", want)
+	}
+}
+
+func TestPanicContinue(t *testing.T) {
+	infos := make(chan HandlerPanic, 1)
+	session, remote, handled := newPanicSession(t, newTestProtocol(), func(info *HandlerPanic) (PanicAction, interface{}) {
+		infos <- *info
+		return PanicContinue, nil
+	})
+
+	writeFrame(t, remote, 1, "")
+	writeFrame(t, remote, 2, "")
+	expectHandled(t, handled, 2)
+
+	info := <-infos
+	if info.MessageID != 1 || info.Value != "boom" || len(info.Stack) == 0 || info.Session != session {
+		t.Fatalf("hook got id %d, value %v, stack %d bytes", info.MessageID, info.Value, len(info.Stack))
+	}
+	if err := session.Err(); err != nil {
+		t.Fatalf("session closed after PanicContinue: %v", err)
+	}
+}
+
+func TestPanicReply(t *testing.T) {
+	protocol := newTestProtocol()
+	_, remote, handled := newPanicSession(t, protocol, func(*HandlerPanic) (PanicAction, interface{}) {
+		return PanicReply, newTestFrame(9, []byte("internal error"))
+	})
+
+	writeFrame(t, remote, 1, "")
+
+	reply, err := protocol.ReadPacket(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, body := protocol.PacketID(reply), protocol.Body(reply.(*Buffer).B); id != 9 || string(body) != "internal error" {
+		t.Fatalf("reply %d %q, want 9 internal error", id, body)
+	}
+
+	writeFrame(t, remote, 2, "")
+	expectHandled(t, handled, 2)
+}
+
+func TestPanicClose(t *testing.T) {
+	session, remote, _ := newPanicSession(t, newTestProtocol(), func(*HandlerPanic) (PanicAction, interface{}) {
+		return PanicClose, nil
+	})
+
+	writeFrame(t, remote, 1, "")
+
+	select {
+	case <-session.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("session not closed after PanicClose")
+	}
+
+	var reason *CloseReason
+	if err := session.Err(); !errors.As(err, &reason) || reason.Kind != ClosePanic {
+		t.Fatalf("Err() = %v, want ClosePanic", err)
+	}
+	var panicErr *PanicError
+	if !errors.As(reason.Err, &panicErr) || panicErr.Value != "boom" {
+		t.Fatalf("close reason %v does not carry the panic", reason.Err)
+	}
+}
+
+func TestPanicInPacketIDAndHook(t *testing.T) {
+	infos := make(chan uint32, 2)
+	protocol := badIDProtocol{newTestProtocol()}
+	session, remote, handled := newPanicSession(t, protocol, func(info *HandlerPanic) (PanicAction, interface{}) {
+		infos <- info.MessageID
+		panic("hook")
+	})
+
+	// PacketID 与钩子都 panic 时会话照常处理之后的封包
+	writeFrame(t, remote, 1, "bad")
+	writeFrame(t, remote, 2, "")
+	expectHandled(t, handled, 2)
+
+	if id := <-infos; id != 0 {
+		t.Fatalf("MessageID = %d, want 0 when PacketID panics", id)
+	}
+	if err := session.Err(); err != nil {
+		t.Fatalf("session closed after a panicking hook: %v", err)
+	}
+}
@@ -0,0 +1,356 @@
+package socketgo
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultHealthCheckInterval = 30 * time.Second // 空闲连接的默认检查间隔
+	DefaultPingTimeout         = 3 * time.Second  // 默认的心跳超时时间
+)
+
+// IPingProtocol 可选接口, 协议实现后 ClientPool 用它检查空闲连接是否可用
+type IPingProtocol interface {
+	// PingPacket 心跳请求封包
+	PingPacket() interface{}
+	// IsPong 判断封包是否为心跳应答
+	IsPong(packet interface{}) bool
+}
+
+// PoolConfig 连接池配置
+type PoolConfig struct {
+	Network         string
+	Address         string
+	ReadBufferSize  int
+	WriteBufferSize int
+
+	MinIdle             int           // 保持的最少空闲连接数
+	MaxOpen             int           // 最多同时打开的连接数, 0 表示不限制
+	MaxLifetime         time.Duration // 连接的最长存活时间, 0 表示不限制
+	HealthCheckInterval time.Duration // 检查空闲连接的间隔, 0 表示 DefaultHealthCheckInterval
+	PingTimeout         time.Duration // 心跳超时时间, 0 表示 DefaultPingTimeout
+	Clock               Clock         // 存活时间与健康检查使用的时钟, nil 表示 RealClock
+}
+
+// PoolStats 连接池统计信息
+type PoolStats struct {
+	Open    int // 已打开的连接数, 包含使用中与空闲的
+	Idle    int // 空闲连接数
+	Waiting int // 等待连接的调用数
+}
+
+// ClientPool 同步客户端连接池, 通过 Get 取出连接, 用完后 Put 归还
+type ClientPool struct {
+	config   PoolConfig
+	protocol IPacketProtocol
+	dialer   Dialer
+
+	lock    sync.Mutex
+	idle    []*PooledClient
+	open    int
+	waiters []chan struct{}
+	closed  bool
+
+	stopedChan chan struct{}
+}
+
+// PooledClient 从连接池取出的连接, 任意读写出错后会被标记为不可用, 归还时直接关闭
+type PooledClient struct {
+	*Client
+	pool      *ClientPool
+	createdAt time.Time
+	broken    bool
+	returned  bool // 已归还(空闲或已关闭), 由连接池的锁保护, 重复 Put 时忽略
+}
+
+// NewClientPool 新建连接池, 并在后台维持最少空闲连接与健康检查
+func NewClientPool(protocol IPacketProtocol, config PoolConfig) *ClientPool {
+	if config.HealthCheckInterval <= 0 {
+		config.HealthCheckInterval = DefaultHealthCheckInterval
+	}
+	if config.PingTimeout <= 0 {
+		config.PingTimeout = DefaultPingTimeout
+	}
+	config.Clock = clockOrDefault(config.Clock)
+
+	p := &ClientPool{
+		config:     config,
+		protocol:   protocol,
+		stopedChan: make(chan struct{}),
+	}
+
+	go p.maintainLoop()
+
+	return p
+}
+
+// SetDialer 替换建立连接的方法
+func (p *ClientPool) SetDialer(dialer Dialer) {
+	p.lock.Lock()
+	p.dialer = dialer
+	p.lock.Unlock()
+}
+
+// Get 取出一个可用连接, 连接数已达上限时等待其它连接归还或 ctx 结束
+func (p *ClientPool) Get(ctx context.Context) (*PooledClient, error) {
+	for {
+		p.lock.Lock()
+		if p.closed {
+			p.lock.Unlock()
+			return nil, ErrPoolClosed
+		}
+
+		if n := len(p.idle); n > 0 {
+			pc := p.idle[n-1]
+			p.idle[n-1] = nil
+			p.idle = p.idle[:n-1]
+			pc.returned = false
+			p.lock.Unlock()
+
+			if p.expired(pc) {
+				p.discard(pc)
+				continue
+			}
+			return pc, nil
+		}
+
+		if p.config.MaxOpen <= 0 || p.open < p.config.MaxOpen {
+			p.open++
+			p.lock.Unlock()
+
+			pc, err := p.dial()
+			if err != nil {
+				p.release()
+				return nil, err
+			}
+			return pc, nil
+		}
+
+		waiter := make(chan struct{}, 1)
+		p.waiters = append(p.waiters, waiter)
+		p.lock.Unlock()
+
+		select {
+		case <-waiter:
+		case <-ctx.Done():
+			p.removeWaiter(waiter)
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Put 归还连接, 出错过或超过存活时间的连接直接关闭; 同一连接重复归还时忽略, 避免被两个调用方同时取出
+func (p *ClientPool) Put(pc *PooledClient) {
+	p.lock.Lock()
+	if pc.returned {
+		p.lock.Unlock()
+		return
+	}
+	pc.returned = true
+
+	if p.closed || pc.broken || p.expired(pc) {
+		p.lock.Unlock()
+		p.discard(pc)
+		return
+	}
+
+	p.idle = append(p.idle, pc)
+	p.notifyWaiter()
+	p.lock.Unlock()
+}
+
+// Stats 获取统计信息
+func (p *ClientPool) Stats() PoolStats {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return PoolStats{Open: p.open, Idle: len(p.idle), Waiting: len(p.waiters)}
+}
+
+// Close 关闭连接池及所有空闲连接, 使用中的连接在归还时关闭
+func (p *ClientPool) Close() {
+	p.lock.Lock()
+	if p.closed {
+		p.lock.Unlock()
+		return
+	}
+
+	p.closed = true
+	idle := p.idle
+	p.idle = nil
+	for _, waiter := range p.waiters {
+		waiter <- struct{}{}
+	}
+	p.waiters = nil
+	p.lock.Unlock()
+
+	close(p.stopedChan)
+	for _, pc := range idle {
+		p.discard(pc)
+	}
+}
+
+func (p *ClientPool) dial() (*PooledClient, error) {
+	p.lock.Lock()
+	dialer := p.dialer
+	p.lock.Unlock()
+
+	client := NewClient(p.protocol)
+	client.SetDialer(dialer)
+
+	err := client.Conn(p.config.Network, p.config.Address, p.config.ReadBufferSize, p.config.WriteBufferSize)
+	if err != nil {
+		_ = client.Close()
+		return nil, err
+	}
+
+	return &PooledClient{Client: client, pool: p, createdAt: p.config.Clock.Now()}, nil
+}
+
+// discard 关闭连接并释放名额
+func (p *ClientPool) discard(pc *PooledClient) {
+	_ = pc.Client.Close()
+	p.release()
+}
+
+func (p *ClientPool) release() {
+	p.lock.Lock()
+	p.open--
+	p.notifyWaiter()
+	p.lock.Unlock()
+}
+
+// notifyWaiter 唤醒一个等待者, 调用方需持有锁
+func (p *ClientPool) notifyWaiter() {
+	if len(p.waiters) == 0 {
+		return
+	}
+
+	p.waiters[0] <- struct{}{}
+	p.waiters = p.waiters[1:]
+}
+
+func (p *ClientPool) removeWaiter(waiter chan struct{}) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for i, w := range p.waiters {
+		if w == waiter {
+			p.waiters = append(p.waiters[:i], p.waiters[i+1:]...)
+			return
+		}
+	}
+
+	// 已被唤醒但不再需要, 转给下一个等待者
+	p.notifyWaiter()
+}
+
+func (p *ClientPool) expired(pc *PooledClient) bool {
+	return p.config.MaxLifetime > 0 && p.config.Clock.Since(pc.createdAt) > p.config.MaxLifetime
+}
+
+// maintainLoop 定期检查空闲连接并补足最少空闲连接数
+func (p *ClientPool) maintainLoop() {
+	p.fillIdle()
+
+	ticker := p.config.Clock.NewTicker(p.config.HealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stopedChan:
+			return
+		case <-ticker.C():
+			p.checkIdle()
+			p.fillIdle()
+		}
+	}
+}
+
+// checkIdle 取出所有空闲连接逐个检查, 可用的连接重新放回
+func (p *ClientPool) checkIdle() {
+	p.lock.Lock()
+	idle := p.idle
+	p.idle = nil
+	for _, pc := range idle {
+		pc.returned = false
+	}
+	p.lock.Unlock()
+
+	for _, pc := range idle {
+		if !p.expired(pc) && p.ping(pc) == nil {
+			p.Put(pc)
+		} else {
+			p.discard(pc)
+		}
+	}
+}
+
+// ping 协议实现了 IPingProtocol 时发送心跳检查连接
+func (p *ClientPool) ping(pc *PooledClient) error {
+	pinger, ok := p.protocol.(IPingProtocol)
+	if !ok {
+		return nil
+	}
+
+	ctx, cancel := contextWithTimeout(p.config.Clock, context.Background(), p.config.PingTimeout)
+	defer cancel()
+
+	_, err := pc.Call(ctx, pinger.PingPacket(), pinger.IsPong)
+	return err
+}
+
+// fillIdle 补足最少空闲连接数
+func (p *ClientPool) fillIdle() {
+	for {
+		p.lock.Lock()
+		if p.closed || len(p.idle) >= p.config.MinIdle ||
+			(p.config.MaxOpen > 0 && p.open >= p.config.MaxOpen) {
+			p.lock.Unlock()
+			return
+		}
+		p.open++
+		p.lock.Unlock()
+
+		pc, err := p.dial()
+		if err != nil {
+			p.release()
+			return
+		}
+		p.Put(pc)
+	}
+}
+
+// Send 发送封包, 出错后连接被标记为不可用
+func (pc *PooledClient) Send(packet interface{}) error {
+	err := pc.Client.Send(packet)
+	pc.markBroken(err)
+	return err
+}
+
+// Recv 读取封包, 出错后连接被标记为不可用
+func (pc *PooledClient) Recv() (interface{}, error) {
+	packet, err := pc.Client.Recv()
+	pc.markBroken(err)
+	return packet, err
+}
+
+// Call 同步请求, 出错(包括超时)后连接被标记为不可用, 因为此时连接上可能残留未读完的应答
+func (pc *PooledClient) Call(ctx context.Context, req interface{}, match FnMatchPacket) (interface{}, error) {
+	resp, err := pc.Client.Call(ctx, req, match)
+	pc.markBroken(err)
+	return resp, err
+}
+
+// Release 将连接归还给所属的连接池
+func (pc *PooledClient) Release() {
+	pc.pool.Put(pc)
+}
+
+func (pc *PooledClient) markBroken(err error) {
+	if err != nil {
+		pc.broken = true
+	}
+}
@@ -0,0 +1,237 @@
+package socketgo_test
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	socket "github.com/datochan/socketgo"
+	"github.com/datochan/socketgo/socketgotest"
+)
+
+// newPoolProtocol 事件ID与长度各占4字节的测试协议
+func newPoolProtocol() *socket.LengthFieldProtocol {
+	return &socket.LengthFieldProtocol{HeaderSize: 8, LengthOffset: 4, LengthSize: 4, IDSize: 4, ByteOrder: binary.LittleEndian}
+}
+
+// poolFrame 组装事件ID为 id 的空包体封包, 长度字段在组包时回填
+func poolFrame(id uint32) []byte {
+	frame := make([]byte, 8)
+	binary.LittleEndian.PutUint32(frame, id)
+	return frame
+}
+
+// pingProtocol 以事件100为心跳请求、事件101为心跳应答的测试协议
+type pingProtocol struct {
+	*socket.LengthFieldProtocol
+}
+
+func (p pingProtocol) PingPacket() interface{} {
+	return poolFrame(100)
+}
+
+func (p pingProtocol) IsPong(packet interface{}) bool {
+	return p.PacketID(packet) == 101
+}
+
+// poolRemotes 连接池的拨号器, 每次拨号将对端连接交给 remotes
+func poolRemotes(t *testing.T, pool *socket.ClientPool) <-chan net.Conn {
+	t.Helper()
+
+	remotes := make(chan net.Conn, 8)
+	pool.SetDialer(func(string, string) (net.Conn, error) {
+		local, remote := net.Pipe()
+		t.Cleanup(func() { _ = remote.Close() })
+		remotes <- remote
+		return local, nil
+	})
+
+	return remotes
+}
+
+// waitStats 等待连接池的统计信息满足 cond
+func waitStats(t *testing.T, pool *socket.ClientPool, cond func(socket.PoolStats) bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond(pool.Stats()) {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("pool stats %+v never reached the expected state", pool.Stats())
+}
+
+func TestPoolDoublePut(t *testing.T) {
+	pool := socket.NewClientPool(newPoolProtocol(), socket.PoolConfig{MaxOpen: 1})
+	defer pool.Close()
+	poolRemotes(t, pool)
+
+	pc, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 重复归还同一连接只放回一次, 不能被两个调用方同时取出
+	pool.Put(pc)
+	pc.Release()
+	if stats := pool.Stats(); stats.Idle != 1 || stats.Open != 1 {
+		t.Fatalf("stats %+v after a double Put, want 1 idle of 1 open", stats)
+	}
+
+	first, err := pool.Get(context.Background())
+	if err != nil || first != pc {
+		t.Fatalf("Get = %p, %v, want the returned connection", first, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if second, err := pool.Get(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("second Get = %p, %v, want DeadlineExceeded", second, err)
+	}
+}
+
+func TestPoolMaxOpenWaiter(t *testing.T) {
+	pool := socket.NewClientPool(newPoolProtocol(), socket.PoolConfig{MaxOpen: 1})
+	defer pool.Close()
+	poolRemotes(t, pool)
+
+	pc, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 达到上限后取消的等待者不再占用等待队列
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelled := make(chan error, 1)
+	go func() {
+		_, err := pool.Get(ctx)
+		cancelled <- err
+	}()
+	waitStats(t, pool, func(s socket.PoolStats) bool { return s.Waiting == 1 })
+	cancel()
+	if err = <-cancelled; !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled Get = %v, want context.Canceled", err)
+	}
+
+	type result struct {
+		pc  *socket.PooledClient
+		err error
+	}
+	results := make(chan result, 1)
+	go func() {
+		pc, err := pool.Get(context.Background())
+		results <- result{pc, err}
+	}()
+	waitStats(t, pool, func(s socket.PoolStats) bool { return s.Waiting == 1 })
+
+	select {
+	case res := <-results:
+		t.Fatalf("Get returned %p, %v beyond MaxOpen", res.pc, res.err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// 归还后等待者取得同一连接, 不会新建连接
+	pc.Release()
+	res := <-results
+	if res.err != nil || res.pc != pc {
+		t.Fatalf("waiter got %p, %v, want the released connection", res.pc, res.err)
+	}
+	if stats := pool.Stats(); stats.Open != 1 || stats.Waiting != 0 {
+		t.Fatalf("stats %+v, want 1 open and no waiters", stats)
+	}
+
+	// 连接池关闭后等待者立即返回
+	go func() {
+		_, err := pool.Get(context.Background())
+		results <- result{err: err}
+	}()
+	waitStats(t, pool, func(s socket.PoolStats) bool { return s.Waiting == 1 })
+	pool.Close()
+	if res = <-results; !errors.Is(res.err, socket.ErrPoolClosed) {
+		t.Fatalf("Get after Close = %v, want socket.ErrPoolClosed", res.err)
+	}
+}
+
+func TestPoolMaxLifetime(t *testing.T) {
+	clock := socketgotest.NewVirtualClock(time.Now())
+	pool := socket.NewClientPool(newPoolProtocol(), socket.PoolConfig{MaxLifetime: time.Minute, HealthCheckInterval: time.Hour, Clock: clock})
+	defer pool.Close()
+	remotes := poolRemotes(t, pool)
+
+	pc, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := <-remotes
+	pc.Release()
+
+	// 超过存活时间的空闲连接在取出时关闭, 并新建连接
+	clock.Advance(time.Minute + time.Second)
+	next, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer next.Release()
+
+	if next == pc {
+		t.Fatal("Get returned a connection past MaxLifetime")
+	}
+	if _, err = first.Read(make([]byte, 1)); err == nil {
+		t.Fatal("expired connection was not closed")
+	}
+	if stats := pool.Stats(); stats.Open != 1 || stats.Idle != 0 {
+		t.Fatalf("stats %+v, want only the new connection open", stats)
+	}
+}
+
+func TestPoolHealthCheck(t *testing.T) {
+	protocol := pingProtocol{newPoolProtocol()}
+	clock := socketgotest.NewVirtualClock(time.Now())
+	pool := socket.NewClientPool(protocol, socket.PoolConfig{HealthCheckInterval: time.Second, Clock: clock})
+	defer pool.Close()
+	remotes := poolRemotes(t, pool)
+
+	healthy, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 第一个连接的对端应答心跳, 第二个连接的对端已断开
+	go func(remote net.Conn) {
+		for {
+			if _, err := protocol.ReadPacket(remote); err != nil {
+				return
+			}
+			if _, err := remote.Write(protocol.BuildPacket(poolFrame(101))); err != nil {
+				return
+			}
+		}
+	}(<-remotes)
+	_ = (<-remotes).Close()
+
+	healthy.Release()
+	dead.Release()
+	if stats := pool.Stats(); stats.Idle != 2 {
+		t.Fatalf("stats %+v, want 2 idle", stats)
+	}
+
+	// 健康检查的定时器注册后推进一个检查间隔
+	clock.BlockUntil(1)
+	clock.Advance(time.Second)
+	waitStats(t, pool, func(s socket.PoolStats) bool { return s.Open == 1 && s.Idle == 1 })
+
+	pc, err := pool.Get(context.Background())
+	if err != nil || pc != healthy {
+		t.Fatalf("Get = %p, %v, want the connection that answered the ping", pc, err)
+	}
+	pc.Release()
+}
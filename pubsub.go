@@ -0,0 +1,584 @@
+package socketgo
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// 主题按 "." 分级, 如 quote.SH.600000。订阅时可使用通配符:
+// "*" 匹配任意一级, "#" 只能位于末尾, 匹配之后的零级或多级, 如 quote.SH.* 与 quote.#
+const (
+	topicSeparator  = "."
+	topicSingleWild = "*"
+	topicMultiWild  = "#"
+)
+
+// ControlOp 订阅控制帧的操作
+type ControlOp byte
+
+const (
+	ControlSubscribe   ControlOp = 1 // 订阅, Topic 为订阅的主题模式, Filter 为过滤表达式
+	ControlUnsubscribe ControlOp = 2 // 退订
+	ControlAck         ControlOp = 3 // 订阅或退订成功
+	ControlNack        ControlOp = 4 // 订阅或退订失败, Error 为原因
+)
+
+// ControlFrame 订阅控制帧, 由协议通过 IControlCodec 包装后在连接上传输
+type ControlFrame struct {
+	Op     ControlOp
+	Topic  string
+	Filter string
+	Error  string
+}
+
+// MarshalBinary 编码为控制帧载荷: 操作(1字节) | 主题 | 过滤表达式 | 错误, 字符串均以 uvarint 长度开头
+func (f *ControlFrame) MarshalBinary() ([]byte, error) {
+	data := make([]byte, 0, 1+3*binary.MaxVarintLen32+len(f.Topic)+len(f.Filter)+len(f.Error))
+	data = append(data, byte(f.Op))
+	for _, s := range []string{f.Topic, f.Filter, f.Error} {
+		data = binary.AppendUvarint(data, uint64(len(s)))
+		data = append(data, s...)
+	}
+
+	return data, nil
+}
+
+// UnmarshalBinary 解析控制帧载荷
+func (f *ControlFrame) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 {
+		return ErrBadControlFrame
+	}
+
+	f.Op = ControlOp(data[0])
+	data = data[1:]
+
+	for _, s := range []*string{&f.Topic, &f.Filter, &f.Error} {
+		size, n := binary.Uvarint(data)
+		if n <= 0 || uint64(len(data)-n) < size {
+			return ErrBadControlFrame
+		}
+		*s = string(data[n : n+int(size)])
+		data = data[n+int(size):]
+	}
+
+	return nil
+}
+
+// IControlCodec 可选接口, 协议实现后可在连接上传输订阅控制帧
+type IControlCodec interface {
+	// ControlPayload 收到的封包为控制帧时返回其载荷, 载荷只在封包有效期间使用
+	ControlPayload(packet interface{}) ([]byte, bool)
+	// ControlPacket 将控制帧载荷包装为该协议的封包, 协议未启用控制帧时返回 nil
+	ControlPacket(payload []byte) interface{}
+}
+
+// TopicFilter 订阅的过滤条件, 返回 false 时该订阅不接收此消息
+type TopicFilter func(topic string, packet interface{}) bool
+
+// FnFilterParser 将控制帧中的过滤表达式解析为 TopicFilter
+type FnFilterParser func(expr string) (TopicFilter, error)
+
+// subscription 单个会话对单个主题模式的订阅
+type subscription struct {
+	session ISession
+	pattern string
+	filter  TopicFilter
+	order   *sendOrder
+}
+
+// sendOrder 会话的发送顺序: 在 ps.lock 内领取序号, 释放锁后按序号依次发送,
+// 使保留消息与发布的消息按收集时的顺序进入发送队列, 发送期间不持有 ps.lock
+type sendOrder struct {
+	lock    sync.Mutex
+	cond    sync.Cond
+	next    uint64 // 下一个领取的序号
+	serving uint64 // 当前可以发送的序号
+}
+
+func newSendOrder() *sendOrder {
+	o := &sendOrder{}
+	o.cond.L = &o.lock
+	return o
+}
+
+// take 领取序号, 调用方需持有 ps 的写锁, 保证各会话的序号与收集顺序一致
+func (o *sendOrder) take() uint64 {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	ticket := o.next
+	o.next++
+	return ticket
+}
+
+// send 等到序号轮到时执行 fn, 之后放行下一个序号
+func (o *sendOrder) send(ticket uint64, fn func()) {
+	o.lock.Lock()
+	for o.serving != ticket {
+		o.cond.Wait()
+	}
+	o.lock.Unlock()
+
+	defer func() {
+		o.lock.Lock()
+		o.serving++
+		o.lock.Unlock()
+		o.cond.Broadcast()
+	}()
+	fn()
+}
+
+// topicNode 订阅主题树的节点, 通配符作为普通的子节点名保存
+type topicNode struct {
+	children map[string]*topicNode
+	subs     map[*subscription]struct{}
+}
+
+// retainedMessage 主题保留的最后一条消息
+type retainedMessage struct {
+	packet  interface{}
+	encoded EncodedPacket
+}
+
+// PubSub 基于主题的发布订阅: 负责订阅关系、通配符匹配与消息分发
+type PubSub struct {
+	lock         sync.RWMutex
+	protocol     IPacketProtocol
+	root         *topicNode
+	sessions     map[ISession]map[string]*subscription // 会话 -> 主题模式 -> 订阅
+	orders       map[ISession]*sendOrder               // 会话的发送顺序
+	watched      map[ISession]struct{}                 // 已注册关闭钩子的会话
+	retained     map[string]*retainedMessage
+	filterParser FnFilterParser
+}
+
+// NewPubSub 新建发布订阅模块, protocol 用于组包
+func NewPubSub(protocol IPacketProtocol) *PubSub {
+	return &PubSub{
+		protocol: protocol,
+		root:     &topicNode{},
+		sessions: make(map[ISession]map[string]*subscription),
+		orders:   make(map[ISession]*sendOrder),
+		watched:  make(map[ISession]struct{}),
+		retained: make(map[string]*retainedMessage),
+	}
+}
+
+// SetFilterParser 设置控制帧中过滤表达式的解析方法, 未设置时带过滤表达式的订阅被拒绝
+func (ps *PubSub) SetFilterParser(parser FnFilterParser) {
+	ps.lock.Lock()
+	ps.filterParser = parser
+	ps.lock.Unlock()
+}
+
+// Subscribe 订阅主题模式, filter 为 nil 表示不过滤; 重复订阅同一模式时替换过滤条件。
+// 与模式匹配且通过过滤的保留消息会立即发送给该会话。会话关闭时自动退订。
+func (ps *PubSub) Subscribe(session ISession, pattern string, filter TopicFilter) error {
+	if err := validateTopic(pattern, true); err != nil {
+		return err
+	}
+
+	ps.lock.Lock()
+	subs, ok := ps.sessions[session]
+	if !ok {
+		subs = make(map[string]*subscription)
+		ps.sessions[session] = subs
+	}
+	order, ok := ps.orders[session]
+	if !ok {
+		order = newSendOrder()
+		ps.orders[session] = order
+	}
+
+	if old, ok := subs[pattern]; ok {
+		ps.root.remove(strings.Split(pattern, topicSeparator), old)
+	}
+
+	sub := &subscription{session: session, pattern: pattern, filter: filter, order: order}
+	subs[pattern] = sub
+	ps.root.insert(strings.Split(pattern, topicSeparator), sub)
+	watch := ps.markWatched(session)
+
+	// 锁内只收集保留消息并领取序号, 保证它们先于之后发布的消息进入发送队列
+	var retained []EncodedPacket
+	patternLevels := strings.Split(pattern, topicSeparator)
+	for topic, msg := range ps.retained {
+		if matchTopic(patternLevels, strings.Split(topic, topicSeparator)) && sub.accept(topic, msg.packet) {
+			retained = append(retained, msg.encoded)
+		}
+	}
+	var ticket uint64
+	if len(retained) > 0 {
+		ticket = order.take()
+	}
+	ps.lock.Unlock()
+
+	// 会话已关闭时钩子立即执行, 需在锁外注册
+	if watch {
+		session.(*Session).addCloseHook(func(*CloseReason) {
+			ps.lock.Lock()
+			defer ps.lock.Unlock()
+
+			ps.unsubscribeAll(session)
+			delete(ps.watched, session)
+		})
+	}
+
+	// 在锁外发送: 发送可能阻塞(Block), 也可能关闭会话并执行上面的钩子(CloseSlow)
+	if len(retained) > 0 {
+		order.send(ticket, func() {
+			for _, encoded := range retained {
+				_ = session.Send(encoded)
+			}
+		})
+	}
+
+	return nil
+}
+
+// Unsubscribe 退订主题模式
+func (ps *PubSub) Unsubscribe(session ISession, pattern string) {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	subs := ps.sessions[session]
+	if sub, ok := subs[pattern]; ok {
+		ps.root.remove(strings.Split(pattern, topicSeparator), sub)
+		delete(subs, pattern)
+	}
+	if len(subs) == 0 {
+		delete(ps.sessions, session)
+		delete(ps.orders, session)
+	}
+}
+
+// UnsubscribeAll 退订会话的所有主题
+func (ps *PubSub) UnsubscribeAll(session ISession) {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	ps.unsubscribeAll(session)
+}
+
+func (ps *PubSub) unsubscribeAll(session ISession) {
+	for pattern, sub := range ps.sessions[session] {
+		ps.root.remove(strings.Split(pattern, topicSeparator), sub)
+	}
+	delete(ps.sessions, session)
+	delete(ps.orders, session)
+}
+
+// markWatched 会话第一次订阅时返回 true, 需注册关闭时退订的钩子; 调用方需持有写锁。
+// 其它 ISession 实现没有关闭钩子, 需自行调用 UnsubscribeAll。
+func (ps *PubSub) markWatched(session ISession) bool {
+	if _, ok := session.(*Session); !ok {
+		return false
+	}
+
+	if _, ok := ps.watched[session]; ok {
+		return false
+	}
+	ps.watched[session] = struct{}{}
+
+	return true
+}
+
+// Publish 向订阅了匹配主题的会话发送封包: 只组包一次, 每个会话最多收到一次。
+// topic 不能包含通配符, 否则返回 ErrInvalidTopic。
+func (ps *PubSub) Publish(topic string, packet interface{}) (BroadcastReport, error) {
+	return ps.publish(topic, packet, false)
+}
+
+// PublishRetained 与 Publish 相同, 同时保留为该主题的最后一条消息, 之后的新订阅者会立即收到它。
+// 保留期间 packet 可能被过滤条件访问, 不能是会被归还的池化封包。
+func (ps *PubSub) PublishRetained(topic string, packet interface{}) (BroadcastReport, error) {
+	return ps.publish(topic, packet, true)
+}
+
+// ClearRetained 删除主题的保留消息
+func (ps *PubSub) ClearRetained(topic string) {
+	ps.lock.Lock()
+	delete(ps.retained, topic)
+	ps.lock.Unlock()
+}
+
+func (ps *PubSub) publish(topic string, packet interface{}, retain bool) (BroadcastReport, error) {
+	var report BroadcastReport
+	if err := validateTopic(topic, false); err != nil {
+		return report, err
+	}
+
+	encoded, ok := packet.(EncodedPacket)
+	if !ok {
+		encoded = EncodePacket(ps.protocol, packet)
+	}
+
+	type target struct {
+		sub    *subscription
+		ticket uint64
+	}
+
+	var targets []target
+	seen := make(map[ISession]struct{})
+	collect := func(sub *subscription) {
+		if _, ok := seen[sub.session]; ok || !sub.accept(topic, packet) {
+			return
+		}
+		seen[sub.session] = struct{}{}
+		targets = append(targets, target{sub: sub, ticket: sub.order.take()})
+	}
+
+	// 保留消息的更新与目标的收集在同一把锁内完成, 新订阅者不会漏掉也不会重复收到。
+	// 领取序号需要写锁, 否则并发的发布在不同会话上的先后顺序可能相反而互相等待。
+	ps.lock.Lock()
+	if retain {
+		ps.retained[topic] = &retainedMessage{packet: packet, encoded: encoded}
+	}
+	ps.root.match(strings.Split(topic, topicSeparator), collect)
+	ps.lock.Unlock()
+
+	for _, t := range targets {
+		var err error
+		t.sub.order.send(t.ticket, func() { err = t.sub.session.Send(encoded) })
+		if err != nil {
+			report.Failed = append(report.Failed, BroadcastFailure{Session: t.sub.session, Err: err})
+			continue
+		}
+		report.Sent++
+	}
+
+	return report, nil
+}
+
+// HandleControl 处理订阅控制帧并应答, packet 是控制帧时返回 true, 否则应交给事件分发器
+func (ps *PubSub) HandleControl(session ISession, packet interface{}) bool {
+	codec, ok := ps.protocol.(IControlCodec)
+	if !ok {
+		return false
+	}
+
+	payload, ok := codec.ControlPayload(packet)
+	if !ok {
+		return false
+	}
+
+	// 解析失败时 frame 中保留已解析出的主题, 原样回显, 使客户端能匹配到应答
+	var frame ControlFrame
+	if err := frame.UnmarshalBinary(payload); err != nil {
+		ps.reply(session, codec, &ControlFrame{Op: ControlNack, Topic: frame.Topic, Error: err.Error()})
+		return true
+	}
+
+	var err error
+	switch frame.Op {
+	case ControlSubscribe:
+		err = ps.subscribeFrame(session, codec, &frame)
+	case ControlUnsubscribe:
+		ps.Unsubscribe(session, frame.Topic)
+		ps.reply(session, codec, &ControlFrame{Op: ControlAck, Topic: frame.Topic})
+	default:
+		err = ErrBadControlFrame
+	}
+
+	if err != nil {
+		ps.reply(session, codec, &ControlFrame{Op: ControlNack, Topic: frame.Topic, Error: err.Error()})
+	}
+
+	return true
+}
+
+// subscribeFrame 校验通过后先应答, 再订阅, 使保留消息在应答之后到达
+func (ps *PubSub) subscribeFrame(session ISession, codec IControlCodec, frame *ControlFrame) error {
+	if err := validateTopic(frame.Topic, true); err != nil {
+		return err
+	}
+
+	var filter TopicFilter
+	if frame.Filter != "" {
+		ps.lock.RLock()
+		parser := ps.filterParser
+		ps.lock.RUnlock()
+
+		if parser == nil {
+			return ErrFilterUnsupported
+		}
+
+		var err error
+		if filter, err = parser(frame.Filter); err != nil {
+			return err
+		}
+	}
+
+	ps.reply(session, codec, &ControlFrame{Op: ControlAck, Topic: frame.Topic})
+	return ps.Subscribe(session, frame.Topic, filter)
+}
+
+func (ps *PubSub) reply(session ISession, codec IControlCodec, frame *ControlFrame) {
+	payload, _ := frame.MarshalBinary()
+	if packet := codec.ControlPacket(payload); packet != nil {
+		_ = session.SendPriority(packet, PriorityHigh)
+	}
+}
+
+func (sub *subscription) accept(topic string, packet interface{}) bool {
+	return sub.filter == nil || sub.filter(topic, packet)
+}
+
+func (n *topicNode) insert(levels []string, sub *subscription) {
+	for _, level := range levels {
+		if n.children == nil {
+			n.children = make(map[string]*topicNode)
+		}
+		child, ok := n.children[level]
+		if !ok {
+			child = &topicNode{}
+			n.children[level] = child
+		}
+		n = child
+	}
+
+	if n.subs == nil {
+		n.subs = make(map[*subscription]struct{})
+	}
+	n.subs[sub] = struct{}{}
+}
+
+// remove 删除订阅并清理空节点, 返回 n 是否已为空
+func (n *topicNode) remove(levels []string, sub *subscription) bool {
+	if len(levels) == 0 {
+		delete(n.subs, sub)
+	} else if child, ok := n.children[levels[0]]; ok && child.remove(levels[1:], sub) {
+		delete(n.children, levels[0])
+	}
+
+	return len(n.subs) == 0 && len(n.children) == 0
+}
+
+// match 对 topic 各级匹配的所有订阅调用 visit
+func (n *topicNode) match(levels []string, visit func(*subscription)) {
+	if multi, ok := n.children[topicMultiWild]; ok {
+		for sub := range multi.subs {
+			visit(sub)
+		}
+	}
+
+	if len(levels) == 0 {
+		for sub := range n.subs {
+			visit(sub)
+		}
+		return
+	}
+
+	if child, ok := n.children[levels[0]]; ok {
+		child.match(levels[1:], visit)
+	}
+	if single, ok := n.children[topicSingleWild]; ok {
+		single.match(levels[1:], visit)
+	}
+}
+
+// matchTopic 判断主题模式是否匹配 topic, 与 topicNode.match 的规则一致
+func matchTopic(pattern, topic []string) bool {
+	for i, level := range pattern {
+		if level == topicMultiWild {
+			return true
+		}
+		if i >= len(topic) || (level != topicSingleWild && level != topic[i]) {
+			return false
+		}
+	}
+
+	return len(pattern) == len(topic)
+}
+
+// validateTopic 校验主题, 各级不能为空; wildcard 为 false 时不允许通配符
+func validateTopic(topic string, wildcard bool) error {
+	levels := strings.Split(topic, topicSeparator)
+	for i, level := range levels {
+		switch {
+		case level == "":
+			return fmt.Errorf("%w: empty level in %q", ErrInvalidTopic, topic)
+		case level == topicSingleWild || level == topicMultiWild:
+			if !wildcard {
+				return fmt.Errorf("%w: wildcard in %q", ErrInvalidTopic, topic)
+			}
+			if level == topicMultiWild && i != len(levels)-1 {
+				return fmt.Errorf("%w: %q must be the last level", ErrInvalidTopic, topicMultiWild)
+			}
+		case strings.ContainsAny(level, topicSingleWild+topicMultiWild):
+			return fmt.Errorf("%w: %q", ErrInvalidTopic, topic)
+		}
+	}
+
+	return nil
+}
+
+// Subscribe 向服务端订阅主题模式并等待应答, 协议需实现 IControlCodec。
+// filter 为过滤表达式, 由服务端的 FnFilterParser 解析, "" 表示不过滤。订阅的消息照常交给事件分发器。
+func (c *AsyncClient) Subscribe(ctx context.Context, pattern, filter string) error {
+	return c.sendControl(ctx, &ControlFrame{Op: ControlSubscribe, Topic: pattern, Filter: filter})
+}
+
+// Unsubscribe 向服务端退订主题模式并等待应答
+func (c *AsyncClient) Unsubscribe(ctx context.Context, pattern string) error {
+	return c.sendControl(ctx, &ControlFrame{Op: ControlUnsubscribe, Topic: pattern})
+}
+
+// sendControl 发送控制帧并等待服务端应答
+func (c *AsyncClient) sendControl(ctx context.Context, frame *ControlFrame) error {
+	codec, ok := c.protocol.(IControlCodec)
+	if !ok {
+		return ErrControlUnsupported
+	}
+
+	payload, _ := frame.MarshalBinary()
+	request := codec.ControlPacket(payload)
+	if request == nil {
+		return ErrControlUnsupported
+	}
+
+	// 服务端无法解析控制帧时主题可能为空, 这样的 Nack 也作为应答
+	resp, err := c.Call(ctx, request, func(packet interface{}) bool {
+		reply, ok := decodeControl(codec, packet)
+		switch {
+		case !ok:
+			return false
+		case reply.Op == ControlAck:
+			return reply.Topic == frame.Topic
+		case reply.Op == ControlNack:
+			return reply.Topic == frame.Topic || reply.Topic == ""
+		}
+		return false
+	})
+	if err != nil {
+		return err
+	}
+
+	reply, _ := decodeControl(codec, resp)
+	if releaser, ok := resp.(IReleaser); ok {
+		releaser.Release()
+	}
+
+	if reply.Op == ControlNack {
+		return fmt.Errorf("%w: %s", ErrSubscribeRejected, reply.Error)
+	}
+
+	return nil
+}
+
+func decodeControl(codec IControlCodec, packet interface{}) (*ControlFrame, bool) {
+	payload, ok := codec.ControlPayload(packet)
+	if !ok {
+		return nil, false
+	}
+
+	var frame ControlFrame
+	if err := frame.UnmarshalBinary(payload); err != nil {
+		return nil, false
+	}
+
+	return &frame, true
+}
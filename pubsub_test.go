@@ -0,0 +1,120 @@
+package socketgo
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func newControlProtocol() *LengthFieldProtocol {
+	protocol := newTestProtocol()
+	protocol.ControlID = 0xFFFF
+	return protocol
+}
+
+// newPipeSession 在内存连接上启动会话, 返回会话与对端连接
+func newPipeSession(t *testing.T, protocol IPacketProtocol, sendChanSize int) (*Session, net.Conn) {
+	t.Helper()
+
+	local, remote := net.Pipe()
+	session := NewSession(local, protocol, func(ISession, interface{}) {}, sendChanSize)
+	session.Start()
+	t.Cleanup(func() {
+		_ = remote.Close()
+		_ = session.Close()
+	})
+
+	return session, remote
+}
+
+func TestPubSubRetainedSlowConsumer(t *testing.T) {
+	protocol := newControlProtocol()
+	ps := NewPubSub(protocol)
+	for i := 0; i < 8; i++ {
+		if _, err := ps.PublishRetained(fmt.Sprintf("quote.%d", i), newTestFrame(1, []byte("r"))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 对端不读取, 保留消息填满发送队列后会话被关闭, 关闭钩子需要 ps.lock
+	session, _ := newPipeSession(t, protocol, 1)
+	session.SetSendPolicy(SendPolicyCloseSlow)
+
+	done := make(chan error, 1)
+	go func() { done <- ps.Subscribe(session, "quote.*", nil) }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Subscribe deadlocked")
+	}
+
+	var reason *CloseReason
+	if !errors.As(session.Err(), &reason) || reason.Kind != CloseSlowConsumer {
+		t.Fatalf("session err = %v, want slow consumer", session.Err())
+	}
+
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+	if len(ps.sessions) != 0 || len(ps.orders) != 0 {
+		t.Fatalf("closed session still subscribed: %d sessions, %d orders", len(ps.sessions), len(ps.orders))
+	}
+}
+
+func TestPubSubMalformedControlNack(t *testing.T) {
+	protocol := newControlProtocol()
+	ps := NewPubSub(protocol)
+	session, remote := newPipeSession(t, protocol, 4)
+
+	// 主题完整, 过滤表达式的长度超出载荷
+	payload, _ := (&ControlFrame{Op: ControlSubscribe, Topic: "quote.*"}).MarshalBinary()
+	payload = append(payload[:len(payload)-2], 9)
+	if !ps.HandleControl(session, protocol.ControlPacket(payload)) {
+		t.Fatal("control frame not handled")
+	}
+
+	packet, err := protocol.ReadPacket(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := packet.(*Buffer)
+	defer buf.Release()
+
+	reply, ok := decodeControl(protocol, buf)
+	if !ok || reply.Op != ControlNack || reply.Topic != "quote.*" {
+		t.Fatalf("reply = %+v, want Nack for quote.*", reply)
+	}
+}
+
+func TestPubSubControlOrder(t *testing.T) {
+	protocol := newControlProtocol()
+	ps := NewPubSub(protocol)
+	if _, err := ps.PublishRetained("quote.a", newTestFrame(1, []byte("retained"))); err != nil {
+		t.Fatal(err)
+	}
+
+	session, remote := newPipeSession(t, protocol, 16)
+	if err := ps.Subscribe(session, "quote.*", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ps.Publish("quote.a", newTestFrame(1, []byte("live"))); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"retained", "live"} {
+		packet, err := protocol.ReadPacket(remote)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := packet.(*Buffer)
+		if got := string(protocol.Body(buf.B)); got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+		buf.Release()
+	}
+}
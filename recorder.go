@@ -0,0 +1,232 @@
+package socketgo
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// RecordDirection 录制数据的方向
+type RecordDirection byte
+
+const (
+	RecordInbound  RecordDirection = 1 // 从连接读取的数据
+	RecordOutbound RecordDirection = 2 // 写入连接的数据
+)
+
+const (
+	recordFlushInterval = time.Second // 录制数据写入磁盘的间隔
+	maxRecordSize       = 256 << 20   // 单条记录的长度上限, 超过时视为文件损坏, 不按其分配内存
+)
+
+// recordMagic 录制文件头
+var recordMagic = []byte("SGRC\x01")
+
+// RecordEntry 一条录制记录, 入站记录为单次 ReadPacket 消耗的字节, 出站记录为单次写入连接的字节
+type RecordEntry struct {
+	SessionID uint64
+	Direction RecordDirection
+	Time      time.Time
+	Data      []byte
+}
+
+// Recorder 将会话收发的原始数据写入紧凑的二进制日志, 多个会话可共用同一个 Recorder。
+// 每条记录的格式为: uvarint(会话ID) | 方向(1字节) | varint(与上一条记录的时间差, 纳秒) | uvarint(长度) | 数据
+type Recorder struct {
+	lock     sync.Mutex
+	w        *bufio.Writer
+	closer   io.Closer
+	lastTime int64
+	err      error
+	header   [3*binary.MaxVarintLen64 + 1]byte
+
+	once       sync.Once
+	stopedChan chan struct{}
+}
+
+// NewRecorder 新建写入 w 的录制器, 数据每秒写出一次, Close 时全部写出
+func NewRecorder(w io.Writer) *Recorder {
+	r := &Recorder{w: bufio.NewWriter(w), stopedChan: make(chan struct{})}
+	if closer, ok := w.(io.Closer); ok {
+		r.closer = closer
+	}
+
+	_, r.err = r.w.Write(recordMagic)
+	go r.flushLoop()
+
+	return r
+}
+
+// CreateRecorder 新建录制到文件的录制器, 文件已存在时覆盖
+func CreateRecorder(path string) (*Recorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewRecorder(f), nil
+}
+
+// Record 写入一条记录, 写入失败后之后的记录都被忽略, 错误由 Close 返回
+func (r *Recorder) Record(sessionID uint64, direction RecordDirection, data []byte) {
+	now := time.Now().UnixNano()
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.err != nil {
+		return
+	}
+
+	n := binary.PutUvarint(r.header[:], sessionID)
+	r.header[n] = byte(direction)
+	n++
+	n += binary.PutVarint(r.header[n:], now-r.lastTime)
+	n += binary.PutUvarint(r.header[n:], uint64(len(data)))
+	r.lastTime = now
+
+	if _, r.err = r.w.Write(r.header[:n]); r.err == nil {
+		_, r.err = r.w.Write(data)
+	}
+}
+
+// Flush 将缓存的记录写出
+func (r *Recorder) Flush() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.err == nil {
+		r.err = r.w.Flush()
+	}
+
+	return r.err
+}
+
+// Close 写出所有记录并关闭底层的 Writer
+func (r *Recorder) Close() error {
+	r.once.Do(func() {
+		close(r.stopedChan)
+
+		err := r.Flush()
+		if r.closer != nil {
+			if closeErr := r.closer.Close(); err == nil {
+				err = closeErr
+			}
+		}
+
+		r.lock.Lock()
+		r.err = err
+		r.lock.Unlock()
+	})
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.err
+}
+
+func (r *Recorder) flushLoop() {
+	ticker := time.NewTicker(recordFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.stopedChan:
+			return
+		case <-ticker.C:
+			_ = r.Flush()
+		}
+	}
+}
+
+// RecordReader 顺序读取录制文件
+type RecordReader struct {
+	r        *bufio.Reader
+	lastTime int64
+}
+
+// NewRecordReader 读取并校验录制文件头
+func NewRecordReader(r io.Reader) (*RecordReader, error) {
+	br := bufio.NewReader(r)
+
+	magic := make([]byte, len(recordMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != string(recordMagic) {
+		return nil, ErrBadRecording
+	}
+
+	return &RecordReader{r: br}, nil
+}
+
+// Next 读取下一条记录, 读完时返回 io.EOF
+func (rr *RecordReader) Next() (*RecordEntry, error) {
+	sessionID, err := binary.ReadUvarint(rr.r)
+	if err != nil {
+		return nil, err // 记录之间结束返回 io.EOF
+	}
+
+	direction, err := rr.r.ReadByte()
+	if err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	delta, err := binary.ReadVarint(rr.r)
+	if err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	size, err := binary.ReadUvarint(rr.r)
+	if err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if size > maxRecordSize {
+		return nil, fmt.Errorf("%w: record of %d bytes", ErrBadRecording, size)
+	}
+
+	data := make([]byte, size)
+	if _, err = io.ReadFull(rr.r, data); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	rr.lastTime += delta
+
+	return &RecordEntry{
+		SessionID: sessionID,
+		Direction: RecordDirection(direction),
+		Time:      time.Unix(0, rr.lastTime),
+		Data:      data,
+	}, nil
+}
+
+// recordConn 录制收发数据的连接
+type recordConn struct {
+	net.Conn
+	recorder  *Recorder
+	sessionID uint64
+	inbound   []byte // 当前封包已读取的字节, 由 recvLoop 在 ReadPacket 返回后记录
+}
+
+func (c *recordConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.inbound = append(c.inbound, p[:n]...)
+	return n, err
+}
+
+func (c *recordConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.recorder.Record(c.sessionID, RecordOutbound, p[:n])
+	}
+	return n, err
+}
+
+// flushInbound 将一次 ReadPacket 读取的字节作为一条入站记录
+func (c *recordConn) flushInbound() {
+	if len(c.inbound) > 0 {
+		c.recorder.Record(c.sessionID, RecordInbound, c.inbound)
+		c.inbound = c.inbound[:0]
+	}
+}
@@ -0,0 +1,88 @@
+package socketgo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestRecordRoundTrip(t *testing.T) {
+	var file bytes.Buffer
+	recorder := NewRecorder(&file)
+	recorder.Record(1, RecordInbound, []byte("ping"))
+	recorder.Record(2, RecordOutbound, []byte("pong"))
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := NewRecordReader(&file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []RecordEntry{{SessionID: 1, Direction: RecordInbound, Data: []byte("ping")}, {SessionID: 2, Direction: RecordOutbound, Data: []byte("pong")}} {
+		entry, err := reader.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry.SessionID != want.SessionID || entry.Direction != want.Direction || !bytes.Equal(entry.Data, want.Data) {
+			t.Fatalf("got %+v, want %+v", entry, want)
+		}
+	}
+
+	if _, err = reader.Next(); err != io.EOF {
+		t.Fatalf("got %v, want io.EOF", err)
+	}
+}
+
+func TestRecordReaderHugeRecord(t *testing.T) {
+	// 长度字段声明 1TB, 不应按其分配内存
+	file := append([]byte(nil), recordMagic...)
+	file = binary.AppendUvarint(file, 1)
+	file = append(file, byte(RecordInbound))
+	file = binary.AppendVarint(file, 0)
+	file = binary.AppendUvarint(file, 1<<40)
+
+	reader, err := NewRecordReader(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = reader.Next(); !errors.Is(err, ErrBadRecording) {
+		t.Fatalf("got %v, want ErrBadRecording", err)
+	}
+}
+
+// countDispatcher 统计收到的封包数
+type countDispatcher struct {
+	*Dispatcher
+	count int
+}
+
+func (d *countDispatcher) HandleProc(ISession, interface{}) { d.count++ }
+
+func TestReplayToDispatcherDecodeError(t *testing.T) {
+	oversized := make([]byte, 8)
+	binary.LittleEndian.PutUint32(oversized[4:], DefaultMaxFrameSize+1)
+
+	var file bytes.Buffer
+	recorder := NewRecorder(&file)
+	recorder.Record(1, RecordInbound, newTestProtocol().BuildPacket(newTestFrame(1, []byte("quote"))))
+	recorder.Record(1, RecordInbound, oversized)
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	dispatcher := &countDispatcher{Dispatcher: NewDispatcher()}
+	err := ReplayToDispatcher(&file, newTestProtocol(), dispatcher, ReplayOptions{})
+
+	var reason *CloseReason
+	if !errors.As(err, &reason) || reason.Kind != CloseDecodeError || !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("ReplayToDispatcher = %v, want decode error with ErrFrameTooLarge", err)
+	}
+	if dispatcher.count != 1 {
+		t.Fatalf("dispatched %d packets, want 1", dispatcher.count)
+	}
+}
@@ -0,0 +1,115 @@
+package socketgo
+
+import (
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+// ReplayOptions 回放录制数据的选项
+type ReplayOptions struct {
+	Direction RecordDirection // 回放的方向, 0 表示 RecordInbound
+	SessionID uint64          // 只回放指定会话, 0 表示全部会话
+	Speed     float64         // 回放速度倍数, 1 为原始节奏, 0 表示尽快回放
+}
+
+// ReplayToConn 将录制数据回放到服务端: 录制中的每个会话通过 dial 建立一个连接, 按顺序写入该会话的数据,
+// 服务端的应答被丢弃
+func ReplayToConn(src io.Reader, dial func() (net.Conn, error), opts ReplayOptions) error {
+	return replay(src, opts, func(uint64) (io.WriteCloser, error) {
+		conn, err := dial()
+		if err != nil {
+			return nil, err
+		}
+
+		go func() { _, _ = io.Copy(io.Discard, conn) }()
+		return conn, nil
+	})
+}
+
+// ReplayToDispatcher 将录制数据回放到事件分发器: 录制中的每个会话对应一个内存中的 Session,
+// 数据经 protocol 解析为封包后交给 dispatcher 处理, handler 发送的应答被丢弃。
+// 所有封包处理完成后返回, 有会话因解析失败等原因提前关闭时返回其 *CloseReason。
+func ReplayToDispatcher(src io.Reader, protocol IPacketProtocol, dispatcher IDispatcher, opts ReplayOptions) error {
+	var sessions []*Session
+
+	err := replay(src, opts, func(uint64) (io.WriteCloser, error) {
+		local, remote := net.Pipe()
+		session := NewSession(local, protocol, dispatcher.HandleProc, DefaultSendChanSize)
+		session.Start()
+		sessions = append(sessions, session)
+
+		go func() { _, _ = io.Copy(io.Discard, remote) }()
+		return remote, nil
+	})
+
+	// 写入端关闭后 recvLoop 读到 EOF, 会话在处理完最后一个封包后关闭
+	for _, session := range sessions {
+		<-session.Done()
+
+		// 会话提前关闭时写入端只得到 io.ErrClosedPipe, 关闭原因更能说明问题
+		reason, _ := session.Err().(*CloseReason)
+		if reason != nil && reason.Kind != ClosePeer && (err == nil || errors.Is(err, io.ErrClosedPipe)) {
+			err = reason
+		}
+	}
+
+	return err
+}
+
+// replay 按录制顺序把选中方向的数据写入各会话对应的 Writer, 结束后全部关闭
+func replay(src io.Reader, opts ReplayOptions, open func(sessionID uint64) (io.WriteCloser, error)) error {
+	if opts.Direction == 0 {
+		opts.Direction = RecordInbound
+	}
+
+	reader, err := NewRecordReader(src)
+	if err != nil {
+		return err
+	}
+
+	writers := make(map[uint64]io.WriteCloser)
+	defer func() {
+		for _, w := range writers {
+			_ = w.Close()
+		}
+	}()
+
+	var first, start time.Time
+	for {
+		entry, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if entry.Direction != opts.Direction || (opts.SessionID != 0 && entry.SessionID != opts.SessionID) {
+			continue
+		}
+
+		if opts.Speed > 0 {
+			if first.IsZero() {
+				first, start = entry.Time, time.Now()
+			}
+			offset := time.Duration(float64(entry.Time.Sub(first)) / opts.Speed)
+			if wait := offset - time.Since(start); wait > 0 {
+				time.Sleep(wait)
+			}
+		}
+
+		w, ok := writers[entry.SessionID]
+		if !ok {
+			if w, err = open(entry.SessionID); err != nil {
+				return err
+			}
+			writers[entry.SessionID] = w
+		}
+
+		if _, err = w.Write(entry.Data); err != nil {
+			return err
+		}
+	}
+}
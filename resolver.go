@@ -0,0 +1,254 @@
+package socketgo
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultResolveInterval = 5 * time.Second  // 默认的重新解析间隔
+	DefaultDrainTimeout    = 30 * time.Second // 节点移除时等待在途 Call 完成的最长时间
+)
+
+// Resolver 服务发现接口, 为 BalancedClient 提供节点地址
+type Resolver interface {
+	// Resolve 解析当前的节点地址列表
+	Resolve(ctx context.Context) ([]string, error)
+	// Watch 持续监视节点变化, 每次地址列表变化(包括首次解析)时以完整列表调用 update, 直到 ctx 结束
+	Watch(ctx context.Context, update func(addrs []string))
+}
+
+// watchResolve 按 clock 的间隔重新解析, 结果变化时调用 update
+func watchResolve(ctx context.Context, clock Clock, interval time.Duration, resolve func(ctx context.Context) ([]string, error), update func([]string)) {
+	if interval <= 0 {
+		interval = DefaultResolveInterval
+	}
+
+	ticker := clockOrDefault(clock).NewTicker(interval)
+	defer ticker.Stop()
+
+	var last []string
+	for {
+		addrs, err := resolve(ctx)
+		switch {
+		case err == errUnchanged:
+		case err != nil:
+			fmt.Printf("解析节点失败, 保留原有节点: %v\n", err)
+		case !equalAddrs(addrs, last):
+			last = addrs
+			update(addrs)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+	}
+}
+
+func equalAddrs(a, b []string) bool {
+	if len(a) != len(b) || a == nil != (b == nil) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// FileResolver 从本地文件读取节点列表, 文件修改后自动重新加载。
+// 扩展名为 .yaml/.yml 时按 YAML 解析, 否则按 JSON(["host:port", ...] 或 {"endpoints": [...]}) 解析。
+//
+// YAML 只支持节点列表这一种写法, 不是完整的 YAML 解析器:
+//
+//	---                  # 可选的文档起始标记
+//	endpoints:           # 可选, 只能是这个键, 且不能有其它键
+//	  - 10.0.0.1:7190    # 每行一个 "- host:port", 可以用单引号或双引号括起来
+//	  - "10.0.0.2:7190"
+//
+// "#" 之后的内容作为注释忽略(地址中不能包含 "#"), 缩进不作区分。
+// 流式写法([a, b])、多行字符串、锚点、多个文档等其它语法均返回 ErrInvalidEndpoints。
+//
+// 解析出空列表(如 {}、[]、null 或编辑器尚未写完的文件)同样返回 ErrInvalidEndpoints,
+// Watch 据此保留原有节点, 不会因文件被非原子地改写而移除全部节点。
+type FileResolver struct {
+	Path     string
+	Interval time.Duration // 检查文件修改的间隔, 0 表示 DefaultResolveInterval
+	Clock    Clock         // 检查间隔使用的时钟, nil 表示 RealClock
+}
+
+// NewFileResolver 新建文件解析器
+func NewFileResolver(path string) *FileResolver {
+	return &FileResolver{Path: path}
+}
+
+func (r *FileResolver) Resolve(ctx context.Context) ([]string, error) {
+	data, err := os.ReadFile(r.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(filepath.Ext(r.Path)) {
+	case ".yaml", ".yml":
+		return parseYAMLEndpoints(data)
+	default:
+		return parseJSONEndpoints(data)
+	}
+}
+
+// Watch 文件的修改时间或大小变化时重新加载
+func (r *FileResolver) Watch(ctx context.Context, update func(addrs []string)) {
+	var modTime time.Time
+	var size int64 = -1
+
+	watchResolve(ctx, r.Clock, r.Interval, func(ctx context.Context) ([]string, error) {
+		info, err := os.Stat(r.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		if info.ModTime().Equal(modTime) && info.Size() == size {
+			return nil, errUnchanged
+		}
+
+		addrs, err := r.Resolve(ctx)
+		if err == nil {
+			modTime, size = info.ModTime(), info.Size()
+		}
+		return addrs, err
+	}, update)
+}
+
+// errUnchanged 文件未修改, 不需要重新解析
+var errUnchanged = errors.New("unchanged")
+
+func parseJSONEndpoints(data []byte) ([]string, error) {
+	var addrs []string
+	if err := json.Unmarshal(data, &addrs); err == nil {
+		return normalizeAddrs(addrs)
+	}
+
+	var doc struct {
+		Endpoints []string `json:"endpoints"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	return normalizeAddrs(doc.Endpoints)
+}
+
+// parseYAMLEndpoints 只解析由 "- host:port" 组成的列表, 可以位于 endpoints: 之下, 语法见 FileResolver
+func parseYAMLEndpoints(data []byte) ([]string, error) {
+	var addrs []string
+	started := false // 已出现 endpoints: 或列表项, 之后不能再有文档标记或键
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if i := strings.Index(text, "#"); i >= 0 {
+			text = strings.TrimSpace(text[:i])
+		}
+
+		switch {
+		case text == "":
+		case (text == "---" || text == "endpoints:") && !started:
+			started = text == "endpoints:"
+		case strings.HasPrefix(text, "- ") || text == "-":
+			started = true
+			item := strings.Trim(strings.TrimSpace(text[1:]), `"'`)
+			if item == "" || strings.ContainsAny(item, " \t") || strings.ContainsAny(item[:1], "[{&*!|>") {
+				return nil, fmt.Errorf("%w: line %d: unsupported item %q", ErrInvalidEndpoints, line, text)
+			}
+			addrs = append(addrs, item)
+		default:
+			return nil, fmt.Errorf("%w: line %d: %q", ErrInvalidEndpoints, line, text)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return normalizeAddrs(addrs)
+}
+
+// normalizeAddrs 校验地址格式并去重, 保留原有顺序; 空列表视为无效, 避免移除全部节点
+func normalizeAddrs(addrs []string) ([]string, error) {
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("%w: empty list", ErrInvalidEndpoints)
+	}
+
+	seen := make(map[string]bool, len(addrs))
+	result := make([]string, 0, len(addrs))
+
+	for _, addr := range addrs {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidEndpoints, err)
+		}
+		if !seen[addr] {
+			seen[addr] = true
+			result = append(result, addr)
+		}
+	}
+
+	return result, nil
+}
+
+// DNSSRVResolver 通过 DNS SRV 记录(_service._proto.name)解析节点。
+// Resolver 为 nil 时使用 net.DefaultResolver; 测试时可传入 PreferGo 且 Dial 指向进程内 DNS 服务的 net.Resolver。
+type DNSSRVResolver struct {
+	Service  string
+	Proto    string
+	Name     string
+	Resolver *net.Resolver
+	Interval time.Duration // 重新解析的间隔, 0 表示 DefaultResolveInterval
+	Clock    Clock         // 重新解析间隔使用的时钟, nil 表示 RealClock
+}
+
+// NewDNSSRVResolver 新建 SRV 解析器
+func NewDNSSRVResolver(service, proto, name string) *DNSSRVResolver {
+	return &DNSSRVResolver{Service: service, Proto: proto, Name: name}
+}
+
+// Resolve 返回所有 SRV 记录的 "target:port", 按地址排序以免按权重随机的顺序被当作节点变化
+func (r *DNSSRVResolver) Resolve(ctx context.Context) ([]string, error) {
+	resolver := r.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+
+	_, records, err := resolver.LookupSRV(ctx, r.Service, r.Proto, r.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := make([]string, 0, len(records))
+	for _, record := range records {
+		target := strings.TrimSuffix(record.Target, ".")
+		addrs = append(addrs, net.JoinHostPort(target, strconv.Itoa(int(record.Port))))
+	}
+	sort.Strings(addrs)
+
+	return normalizeAddrs(addrs)
+}
+
+func (r *DNSSRVResolver) Watch(ctx context.Context, update func(addrs []string)) {
+	watchResolve(ctx, r.Clock, r.Interval, r.Resolve, update)
+}
@@ -0,0 +1,385 @@
+package socketgo_test
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	socket "github.com/datochan/socketgo"
+	"github.com/datochan/socketgo/socketgotest"
+)
+
+// srvRecord 进程内 DNS 服务返回的 SRV 记录
+type srvRecord struct {
+	target string
+	port   uint16
+}
+
+// dnsStandIn 只应答 SRV 查询的进程内 DNS 服务, 记录可在测试中替换
+type dnsStandIn struct {
+	conn net.PacketConn
+
+	lock    sync.Mutex
+	records map[string][]srvRecord // 小写的完整域名(以 "." 结尾) -> 记录
+}
+
+func newDNSStandIn(t *testing.T) *dnsStandIn {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dns := &dnsStandIn{conn: conn, records: make(map[string][]srvRecord)}
+	t.Cleanup(func() { _ = conn.Close() })
+	go dns.serve()
+
+	return dns
+}
+
+func (d *dnsStandIn) set(name string, records ...srvRecord) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.records[strings.ToLower(name)] = records
+}
+
+// resolver 所有查询都发往该服务的 net.Resolver
+func (d *dnsStandIn) resolver() *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "udp", d.conn.LocalAddr().String())
+		},
+	}
+}
+
+func (d *dnsStandIn) serve() {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := d.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if resp := d.answer(buf[:n]); resp != nil {
+			_, _ = d.conn.WriteTo(resp, addr)
+		}
+	}
+}
+
+// answer 按 RFC 1035 组装应答: 原样带回问题, 答案的名字用指向问题的压缩指针
+func (d *dnsStandIn) answer(query []byte) []byte {
+	if len(query) < 12 || binary.BigEndian.Uint16(query[4:]) != 1 {
+		return nil
+	}
+
+	// 问题: 域名各段 + 类型(2) + 类(2)
+	var labels []string
+	off := 12
+	for off < len(query) && query[off] != 0 {
+		size := int(query[off])
+		if off+1+size > len(query) {
+			return nil
+		}
+		labels = append(labels, string(query[off+1:off+1+size]))
+		off += 1 + size
+	}
+	off++
+	if off+4 > len(query) {
+		return nil
+	}
+	qtype := binary.BigEndian.Uint16(query[off:])
+	question := query[12 : off+4]
+
+	d.lock.Lock()
+	records, ok := d.records[strings.ToLower(strings.Join(labels, ".")+".")]
+	d.lock.Unlock()
+
+	resp := make([]byte, 12, 512)
+	copy(resp, query[:2])
+	flags := uint16(0x8180) // QR | RD | RA
+	switch {
+	case !ok:
+		flags |= 3 // NXDOMAIN
+		records = nil
+	case qtype != 33:
+		records = nil
+	}
+	binary.BigEndian.PutUint16(resp[2:], flags)
+	binary.BigEndian.PutUint16(resp[4:], 1)
+	binary.BigEndian.PutUint16(resp[6:], uint16(len(records)))
+	resp = append(resp, question...)
+
+	for _, record := range records {
+		var target []byte
+		for _, label := range strings.Split(strings.TrimSuffix(record.target, "."), ".") {
+			target = append(append(target, byte(len(label))), label...)
+		}
+		target = append(target, 0)
+
+		resp = append(resp, 0xC0, 12)                  // 名字指向问题
+		resp = binary.BigEndian.AppendUint16(resp, 33) // SRV
+		resp = binary.BigEndian.AppendUint16(resp, 1)  // IN
+		resp = binary.BigEndian.AppendUint32(resp, 60)
+		resp = binary.BigEndian.AppendUint16(resp, uint16(6+len(target)))
+		resp = binary.BigEndian.AppendUint16(resp, 10) // priority
+		resp = binary.BigEndian.AppendUint16(resp, 10) // weight
+		resp = binary.BigEndian.AppendUint16(resp, record.port)
+		resp = append(resp, target...)
+	}
+
+	return resp
+}
+
+const srvName = "_socketgo._tcp.example.test."
+
+func TestDNSSRVResolverResolve(t *testing.T) {
+	dns := newDNSStandIn(t)
+	dns.set(srvName, srvRecord{"b.example.test.", 7191}, srvRecord{"a.example.test.", 7190})
+
+	resolver := socket.NewDNSSRVResolver("socketgo", "tcp", "example.test.")
+	resolver.Resolver = dns.resolver()
+
+	addrs, err := resolver.Resolve(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"a.example.test:7190", "b.example.test:7191"}
+	if !reflect.DeepEqual(addrs, want) {
+		t.Fatalf("got %v, want %v", addrs, want)
+	}
+
+	resolver.Name = "missing.test."
+	if _, err = resolver.Resolve(context.Background()); err == nil {
+		t.Fatal("expected an error for a missing name")
+	}
+}
+
+// watchUpdates 在后台运行 Watch, 返回收到更新的通道
+func watchUpdates(t *testing.T, resolver socket.Resolver) <-chan []string {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	updates := make(chan []string, 4)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		resolver.Watch(ctx, func(addrs []string) { updates <- addrs })
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return updates
+}
+
+func expectUpdate(t *testing.T, updates <-chan []string, want []string) {
+	t.Helper()
+
+	select {
+	case addrs := <-updates:
+		if !reflect.DeepEqual(addrs, want) {
+			t.Fatalf("got %v, want %v", addrs, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no update, want %v", want)
+	}
+}
+
+func TestDNSSRVResolverWatch(t *testing.T) {
+	dns := newDNSStandIn(t)
+	dns.set(srvName, srvRecord{"a.example.test.", 7190})
+
+	clock := socketgotest.NewVirtualClock(time.Now())
+	resolver := socket.NewDNSSRVResolver("socketgo", "tcp", "example.test.")
+	resolver.Resolver = dns.resolver()
+	resolver.Interval = time.Minute
+	resolver.Clock = clock
+
+	updates := watchUpdates(t, resolver)
+	expectUpdate(t, updates, []string{"a.example.test:7190"})
+
+	// 记录变化后, 虚拟时钟推进一个间隔即重新解析
+	dns.set(srvName, srvRecord{"a.example.test.", 7190}, srvRecord{"c.example.test.", 7192})
+	clock.BlockUntil(1)
+	clock.Advance(time.Minute)
+	expectUpdate(t, updates, []string{"a.example.test:7190", "c.example.test:7192"})
+
+	// 记录不变时不回调
+	clock.Advance(time.Minute)
+	select {
+	case addrs := <-updates:
+		t.Fatalf("unexpected update %v", addrs)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestFileResolverFormats(t *testing.T) {
+	dir := t.TempDir()
+	want := []string{"10.0.0.1:7190", "10.0.0.2:7190"}
+
+	valid := map[string]string{
+		"list.json":    `["10.0.0.1:7190", "10.0.0.2:7190", "10.0.0.1:7190"]`,
+		"object.json":  `{"endpoints": ["10.0.0.1:7190", "10.0.0.2:7190"]}`,
+		"list.yaml":    "---\nendpoints:  # 节点\n  - 10.0.0.1:7190\n  - \"10.0.0.2:7190\"\n",
+		"bare.yml":     "- '10.0.0.1:7190'\n- 10.0.0.2:7190\n",
+		"comments.yml": "# 注释\n\n- 10.0.0.1:7190 # a\n- 10.0.0.2:7190\n",
+	}
+	for name, content := range valid {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		addrs, err := socket.NewFileResolver(path).Resolve(context.Background())
+		if err != nil || !reflect.DeepEqual(addrs, want) {
+			t.Errorf("%s: got %v, %v; want %v", name, addrs, err, want)
+		}
+	}
+
+	invalid := map[string]string{
+		"flow.yaml":   "endpoints: [10.0.0.1:7190]\n",
+		"other.yaml":  "servers:\n  - 10.0.0.1:7190\n",
+		"nested.yaml": "endpoints:\n  - host: 10.0.0.1\n",
+		"anchor.yaml": "- &a 10.0.0.1:7190\n",
+		"docs.yaml":   "- 10.0.0.1:7190\n---\n- 10.0.0.2:7190\n",
+		"port.yaml":   "- 10.0.0.1\n",
+		"empty.yaml":  "",
+		"key.yaml":    "---\nendpoints:\n",
+		"empty.json":  `{}`,
+		"null.json":   `null`,
+		"nodes.json":  `[]`,
+	}
+	for name, content := range invalid {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if addrs, err := socket.NewFileResolver(path).Resolve(context.Background()); !errors.Is(err, socket.ErrInvalidEndpoints) {
+			t.Errorf("%s: got %v, %v; want ErrInvalidEndpoints", name, addrs, err)
+		}
+	}
+}
+
+func TestFileResolverWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "endpoints.yaml")
+	if err := os.WriteFile(path, []byte("- 10.0.0.1:7190\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	clock := socketgotest.NewVirtualClock(time.Now())
+	resolver := socket.NewFileResolver(path)
+	resolver.Interval = time.Second
+	resolver.Clock = clock
+
+	updates := watchUpdates(t, resolver)
+	expectUpdate(t, updates, []string{"10.0.0.1:7190"})
+
+	if err := os.WriteFile(path, []byte("- 10.0.0.1:7190\n- 10.0.0.2:7190\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	clock.BlockUntil(1)
+	clock.Advance(time.Second)
+	expectUpdate(t, updates, []string{"10.0.0.1:7190", "10.0.0.2:7190"})
+
+	// 文件被非原子地改写, 中途读到空内容时保留原有节点
+	for _, content := range []string{"", "endpoints:\n"} {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		clock.Advance(time.Second)
+		select {
+		case addrs := <-updates:
+			t.Fatalf("update %v for content %q, want the previous list kept", addrs, content)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	if err := os.WriteFile(path, []byte("- 10.0.0.2:7190\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Second)
+	expectUpdate(t, updates, []string{"10.0.0.2:7190"})
+}
+
+func TestBalancerRemovedEndpointDrainsCalls(t *testing.T) {
+	clock := socketgotest.NewVirtualClock(time.Now())
+	remotes := make(map[string]chan net.Conn)
+	for _, addr := range []string{"a:1", "b:1"} {
+		remotes[addr] = make(chan net.Conn, 1)
+	}
+	dialer := func(_, addr string) (net.Conn, error) {
+		local, remote := net.Pipe()
+		remotes[addr] <- remote
+		return local, nil
+	}
+
+	protocol := &socket.LengthFieldProtocol{HeaderSize: 8, LengthOffset: 4, LengthSize: 4, IDSize: 4, ByteOrder: binary.LittleEndian}
+	b := socket.NewBalancedClient([]string{"a:1"}, protocol, socket.NewDispatcher(), socket.BalancedConfig{
+		HeartbeatInterval: time.Hour,
+		DrainTimeout:      time.Minute,
+		Dialer:            dialer,
+		Clock:             clock,
+	})
+	defer b.Close()
+
+	remote := <-remotes["a:1"]
+	defer remote.Close()
+	waitUp(t, b, true)
+
+	type result struct {
+		resp interface{}
+		err  error
+	}
+	results := make(chan result, 1)
+	go func() {
+		req := protocol.BuildPacket(append(binary.LittleEndian.AppendUint32(nil, 1), 0, 0, 0, 0))
+		resp, err := b.Call(context.Background(), "", req, func(p interface{}) bool { return protocol.PacketID(p) == 2 })
+		results <- result{resp, err}
+	}()
+
+	if _, err := protocol.ReadPacket(remote); err != nil {
+		t.Fatal(err)
+	}
+
+	// 请求已发出后节点被移除, 在 DrainTimeout 内等待应答而不关闭连接
+	b.UpdateEndpoints([]string{"b:1"})
+	<-remotes["b:1"]
+	clock.Advance(30 * time.Second)
+
+	resp := protocol.BuildPacket(append(binary.LittleEndian.AppendUint32(nil, 2), 0, 0, 0, 0))
+	if _, err := remote.Write(resp); err != nil {
+		t.Fatalf("connection to the removed endpoint closed before the call finished: %v", err)
+	}
+
+	select {
+	case res := <-results:
+		if res.err != nil {
+			t.Fatalf("in-flight Call on a removed endpoint failed: %v", res.err)
+		}
+		if releaser, ok := res.resp.(socket.IReleaser); ok {
+			releaser.Release()
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("in-flight Call on a removed endpoint never returned")
+	}
+
+	// 在途 Call 完成后连接随之关闭
+	clock.Advance(time.Second)
+	_ = remote.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := remote.Read(make([]byte, 1)); err == nil || errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Read = %v, want the drained connection closed", err)
+	}
+}
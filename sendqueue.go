@@ -0,0 +1,280 @@
+package socketgo
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// Priority 发送优先级, 数值越小优先级越高
+type Priority int
+
+const (
+	PriorityHigh   Priority = iota // 心跳、踢人、鉴权应答等控制封包
+	PriorityNormal                 // 默认优先级
+	PriorityLow                    // 大块数据等可延后发送的封包
+
+	NumPriorities = 3
+)
+
+// SendResult 单个封包的发送结果, Err 为 nil 时表示封包已完整写入内核
+type SendResult struct {
+	N   int   // 写入的字节数
+	Err error // 写入失败的原因
+}
+
+// QueueStats 单个优先级队列的统计信息
+type QueueStats struct {
+	Capacity int    // 队列容量
+	Depth    int    // 当前排队的封包数
+	Enqueued uint64 // 成功入队的封包数
+	Sent     uint64 // 成功写出的封包数
+	Failed   uint64 // 写出失败或因会话关闭未能写出的封包数
+	Dropped  uint64 // 因 SendPolicyDropOldest 被丢弃的封包数
+	Rejected uint64 // 因队列已满被拒绝入队的封包数
+}
+
+// sendQueue 单个优先级的发送队列
+type sendQueue struct {
+	ch     chan *sendItem
+	weight int // 加权调度时每轮可发送的封包数
+
+	enqueued uint64
+	sent     uint64
+	failed   uint64
+	dropped  uint64
+	rejected uint64
+}
+
+func newSendQueue(capacity int) *sendQueue {
+	return &sendQueue{ch: make(chan *sendItem, capacity), weight: 1}
+}
+
+func (q *sendQueue) stats() QueueStats {
+	return QueueStats{
+		Capacity: cap(q.ch),
+		Depth:    len(q.ch),
+		Enqueued: atomic.LoadUint64(&q.enqueued),
+		Sent:     atomic.LoadUint64(&q.sent),
+		Failed:   atomic.LoadUint64(&q.failed),
+		Dropped:  atomic.LoadUint64(&q.dropped),
+		Rejected: atomic.LoadUint64(&q.rejected),
+	}
+}
+
+// sendItem 发送队列中的元素
+type sendItem struct {
+	packet   interface{}
+	priority Priority
+	queue    *sendQueue      // 入队后所在的队列, 用于统计
+	result   chan SendResult // 仅 SendAsync 时存在, 用于回传发送结果
+	finished int32           // 结果已回传, sendLoop 与入队方都可能结束同一个封包
+}
+
+// done 回传发送结果并更新统计, 只有第一次调用生效, 返回本次调用是否生效
+func (item *sendItem) done(n int, err error) bool {
+	if !atomic.CompareAndSwapInt32(&item.finished, 0, 1) {
+		return false
+	}
+
+	if item.queue != nil {
+		switch err {
+		case nil:
+			atomic.AddUint64(&item.queue.sent, 1)
+		case ErrPacketDropped:
+			atomic.AddUint64(&item.queue.dropped, 1)
+		default:
+			atomic.AddUint64(&item.queue.failed, 1)
+		}
+	}
+
+	if item.result != nil {
+		item.result <- SendResult{N: n, Err: err}
+	}
+
+	return true
+}
+
+// pushed 封包已写入队列。写入与会话关闭同时发生时, 封包可能在 drainSendChan 之后才进入队列,
+// 此时由入队方以关闭原因结束它, 以免 SendAsync 的结果永远不返回。
+func (s *Session) pushed(q *sendQueue, item *sendItem) error {
+	atomic.AddUint64(&q.enqueued, 1)
+
+	select {
+	case <-s.stopedChan:
+		if err := s.closedError(); item.done(0, err) {
+			return err
+		}
+	default:
+	}
+
+	return nil
+}
+
+// SetQueueCapacity 设置指定优先级队列的容量, 需在 Start 之前调用
+func (s *Session) SetQueueCapacity(priority Priority, capacity int) {
+	s.queues[priority].ch = make(chan *sendItem, capacity)
+}
+
+// SetPriorityWeights 开启加权公平调度, weights 依次对应各优先级每轮可发送的封包数。
+// 不调用时按严格优先级调度, 即高优先级队列为空时才发送低优先级封包。需在 Start 之前调用。
+func (s *Session) SetPriorityWeights(weights ...int) {
+	for p := range s.queues {
+		s.queues[p].weight = 1
+		if p < len(weights) && weights[p] > 0 {
+			s.queues[p].weight = weights[p]
+		}
+	}
+
+	s.weighted = true
+	s.refillCredits()
+}
+
+// QueueStats 获取指定优先级队列的统计信息
+func (s *Session) QueueStats(priority Priority) QueueStats {
+	return s.queues[priority].stats()
+}
+
+func (s *Session) enqueue(item *sendItem) error {
+	if atomic.LoadInt32(&s.closed) == 1 {
+		return s.closedError()
+	}
+
+	q := s.queues[item.priority]
+	item.queue = q
+
+	select {
+	case q.ch <- item:
+		return s.pushed(q, item)
+	case <-s.stopedChan:
+		return s.closedError()
+	default:
+	}
+
+	switch SendPolicy(atomic.LoadInt32(&s.sendPolicy)) {
+	case SendPolicyBlock:
+		return s.enqueueContext(context.Background(), item)
+	case SendPolicyDropOldest:
+		return s.enqueueDropOldest(item)
+	case SendPolicyCloseSlow:
+		atomic.AddUint64(&q.rejected, 1)
+		_ = s.CloseWithReason(CloseSlowConsumer, ErrSlowConsumer)
+		return ErrSlowConsumer
+	default:
+		atomic.AddUint64(&q.rejected, 1)
+		return ErrSendChanBlocking
+	}
+}
+
+func (s *Session) enqueueContext(ctx context.Context, item *sendItem) error {
+	if atomic.LoadInt32(&s.closed) == 1 {
+		return s.closedError()
+	}
+
+	q := s.queues[item.priority]
+	item.queue = q
+
+	select {
+	case q.ch <- item:
+		return s.pushed(q, item)
+	case <-s.stopedChan:
+		return s.closedError()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// enqueueDropOldest 丢弃队列头部最早的封包, 直到新封包能够写入
+func (s *Session) enqueueDropOldest(item *sendItem) error {
+	q := s.queues[item.priority]
+	if cap(q.ch) == 0 {
+		// 无缓冲队列没有可丢弃的封包, 只能等待 sendLoop 取走
+		return s.enqueueContext(context.Background(), item)
+	}
+
+	for {
+		select {
+		case q.ch <- item:
+			return s.pushed(q, item)
+		case <-s.stopedChan:
+			return s.closedError()
+		default:
+		}
+
+		select {
+		case dropped := <-q.ch:
+			dropped.done(0, ErrPacketDropped)
+		default:
+		}
+	}
+}
+
+// pollItem 不阻塞地按调度策略取出下一个待发送的封包, 队列均为空时返回 nil
+func (s *Session) pollItem() *sendItem {
+	if !s.weighted {
+		for _, q := range s.queues {
+			select {
+			case item := <-q.ch:
+				return item
+			default:
+			}
+		}
+		return nil
+	}
+
+	// 加权调度: 按优先级轮询仍有额度的队列, 所有非空队列额度用尽后重新分配
+	for round := 0; round < 2; round++ {
+		for p, q := range s.queues {
+			if s.credits[p] <= 0 {
+				continue
+			}
+
+			select {
+			case item := <-q.ch:
+				s.credits[p]--
+				return item
+			default:
+			}
+		}
+		s.refillCredits()
+	}
+
+	return nil
+}
+
+// waitItem 取出下一个待发送的封包, 队列均为空时阻塞, 直到有新封包、会话关闭或 timeout 触发(返回 nil)
+func (s *Session) waitItem(timeout <-chan time.Time) *sendItem {
+	if item := s.pollItem(); item != nil {
+		return item
+	}
+
+	select {
+	case item := <-s.queues[PriorityHigh].ch:
+		return item
+	case item := <-s.queues[PriorityNormal].ch:
+		return item
+	case item := <-s.queues[PriorityLow].ch:
+		return item
+	case <-s.stopedChan:
+		return nil
+	case <-timeout:
+		return nil
+	}
+}
+
+func (s *Session) refillCredits() {
+	for p, q := range s.queues {
+		s.credits[p] = q.weight
+	}
+}
+
+// drainSendChan 会话关闭后, 队列中尚未发送的封包统一以关闭原因结束, 它与 ErrSessionClosed 兼容
+func (s *Session) drainSendChan() {
+	for {
+		item := s.pollItem()
+		if item == nil {
+			return
+		}
+		item.done(0, s.closedError())
+	}
+}
@@ -4,15 +4,25 @@ import (
 	"fmt"
 	"net"
 	"sync"
+	"sync/atomic"
 )
 
+// DefaultSendChanSize 服务端会话默认的发送队列长度
+const DefaultSendChanSize = 64
+
 type Server struct {
+	sessionConfig // 新接入会话的配置
+
 	once       *sync.Once
 	listener   net.Listener
 	dispatcher IDispatcher
 	stopedChan chan struct{}
 	protocol   IPacketProtocol
-	SessionMng []ISession
+
+	sessionLock sync.Mutex // 保护 SessionMng 与 groups
+	SessionMng  []ISession
+	groups      map[string]map[ISession]struct{} // 分组名 -> 成员
+	pubsub      atomic.Pointer[PubSub]           // 发布订阅模块, 第一次调用 PubSub 时创建
 }
 
 // NewServer 新建服务器
@@ -23,13 +33,19 @@ func NewServer(network, address string, protocol IPacketProtocol, dispatcher IDi
 		return nil, ErrListenFailed
 	}
 
+	return NewServerWithListener(listener, protocol, dispatcher), nil
+}
+
+// NewServerWithListener 使用已有的 listener 新建服务器, 如 Mux 或内存中的 listener
+func NewServerWithListener(listener net.Listener, protocol IPacketProtocol, dispatcher IDispatcher) *Server {
 	return &Server{
-		dispatcher: dispatcher,
-		listener:   listener,
-		once:       &sync.Once{},
-		protocol:   protocol,
-		stopedChan: make(chan struct{}),
-	}, nil
+		sessionConfig: sessionConfig{sendChanSize: DefaultSendChanSize},
+		dispatcher:    dispatcher,
+		listener:      listener,
+		once:          &sync.Once{},
+		protocol:      protocol,
+		stopedChan:    make(chan struct{}),
+	}
 }
 
 // GetDispatcher 获取事件分发器
@@ -37,6 +53,7 @@ func (s *Server) GetDispatcher() IDispatcher {
 	return s.dispatcher
 }
 
+// Close 停止接入新连接, 并以 CloseServerShutdown 关闭所有会话
 func (s *Server) Close() {
 	s.once.Do(func() {
 		if s.listener != nil {
@@ -44,9 +61,21 @@ func (s *Server) Close() {
 		}
 
 		close(s.stopedChan)
+
+		for _, session := range s.Sessions() {
+			_ = session.CloseWithReason(CloseServerShutdown, nil)
+		}
 	})
 }
 
+// Sessions 获取所有会话的快照, goroutine safe
+func (s *Server) Sessions() []ISession {
+	s.sessionLock.Lock()
+	defer s.sessionLock.Unlock()
+
+	return append([]ISession(nil), s.SessionMng...)
+}
+
 func (s *Server) acceptLoop() error {
 	tcpConn, err := s.listener.Accept()
 	if err != nil {
@@ -54,15 +83,56 @@ func (s *Server) acceptLoop() error {
 		return ErrAcceptFailed
 	}
 
-	session := NewSession(tcpConn, s.protocol, s.dispatcher.HandleProc, 0)
+	session := s.newSession(tcpConn, s.protocol, s.handlePacket)
 
 	fmt.Println("A client connected :" + tcpConn.RemoteAddr().String())
+	s.sessionLock.Lock()
 	s.SessionMng = append(s.SessionMng, session)
+	s.sessionLock.Unlock()
+	session.addCloseHook(func(*CloseReason) { s.removeSession(session) })
+
 	session.Start()
 
+	// Close 期间接入的会话可能不在其快照中, 需自行关闭
+	select {
+	case <-s.stopedChan:
+		_ = session.CloseWithReason(CloseServerShutdown, nil)
+	default:
+	}
+
 	return nil
 }
 
+// handlePacket 订阅控制帧交给发布订阅模块, 其余封包交给事件分发器
+func (s *Server) handlePacket(session ISession, packet interface{}) {
+	if pubsub := s.pubsub.Load(); pubsub != nil && pubsub.HandleControl(session, packet) {
+		return
+	}
+
+	s.dispatcher.HandleProc(session, packet)
+}
+
+// PubSub 获取服务器的发布订阅模块, 第一次调用时创建; 创建后协议实现 IControlCodec 时客户端可通过控制帧订阅
+func (s *Server) PubSub() *PubSub {
+	if pubsub := s.pubsub.Load(); pubsub != nil {
+		return pubsub
+	}
+
+	s.sessionLock.Lock()
+	defer s.sessionLock.Unlock()
+
+	if s.pubsub.Load() == nil {
+		s.pubsub.Store(NewPubSub(s.protocol))
+	}
+
+	return s.pubsub.Load()
+}
+
+// Publish 向订阅了匹配主题的会话发送封包, 见 PubSub.Publish
+func (s *Server) Publish(topic string, packet interface{}) (BroadcastReport, error) {
+	return s.PubSub().Publish(topic, packet)
+}
+
 func (s *Server) AcceptLoop() {
 	for {
 		if err := s.acceptLoop(); err != nil {
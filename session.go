@@ -1,9 +1,9 @@
 package socketgo
 
 import (
+	"context"
 	"fmt"
 	"net"
-	"runtime"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -20,66 +20,216 @@ type IPacketProtocol interface {
 	SendPacket(net.Conn, []byte) error
 }
 
-// FnCallbackClosed 链接本身的处理句柄
-type FnCallbackClosed func(net.Conn)
+// FnCallbackClosed 会话关闭时的处理句柄, reason 为会话关闭的原因
+type FnCallbackClosed func(conn net.Conn, reason *CloseReason)
 
 type FnCallbackSended func(net.Conn, interface{})
 
+// SendPolicy 发送队列已满时的处理策略
+type SendPolicy int32
+
+const (
+	SendPolicyReject     SendPolicy = iota // 立即返回 ErrSendChanBlocking(默认)
+	SendPolicyBlock                        // 阻塞直到封包写入队列或会话关闭
+	SendPolicyDropOldest                   // 丢弃队列中最早的封包, 为新封包腾出空间
+	SendPolicyCloseSlow                    // 视为慢消费者, 直接关闭会话
+)
+
 type ISession interface {
+	ID() uint64
 	RawConn() net.Conn
 	Start()
 	Send(packet interface{}) error
+	SendContext(ctx context.Context, packet interface{}) error
+	SendAsync(packet interface{}) <-chan SendResult
+	SendPriority(packet interface{}, priority Priority) error
+	SetSendPolicy(policy SendPolicy)
+	Context() context.Context
+	Principal() interface{}
+	SetPrincipal(principal interface{})
+	Set(key string, value interface{})
+	Get(key string) (interface{}, bool)
+	Delete(key string)
+	Info() SessionInfo
 	Close() error
+	CloseWithReason(kind CloseKind, cause error) error
+	Err() error
+	Done() <-chan struct{}
 	SetCloseCallback(callback FnCallbackClosed)
 	SetSendCallback(callback FnCallbackSended)
 }
 
+// sessionSeq 会话ID计数
+var sessionSeq uint64
+
 // Session 异步会话管理
 type Session struct {
 	lock sync.Mutex
+	id   uint64 // 进程内唯一的会话ID
 
 	conn          net.Conn
 	protocol      IPacketProtocol
 	packetHandler PacketHandler
-	closeCallback func(net.Conn)
+	closeCallback FnCallbackClosed
+	closeHooks    []func(*CloseReason) // 库内部的关闭钩子, 如 Server 移除会话, 由 lock 保护
 	sendCallback  func(net.Conn, interface{})
+	panicHook     FnHandlerPanic // handler 发生 panic 时的钩子, nil 表示打印后继续
+
+	closed     int32                       // session是否关闭，-1未开启，0未关闭，1关闭
+	reason     atomic.Pointer[CloseReason] // 会话关闭的原因, 关闭前为 nil
+	ctx        context.Context             // 会话的 context, 关闭时以 CloseReason 为原因取消
+	cancel     context.CancelCauseFunc
+	principal  interface{}            // 认证主体, 由 lock 保护
+	attrs      map[string]interface{} // 会话属性, 由 lock 保护
+	counters   sessionCounters        // 收发统计
+	sendPolicy int32                  // 发送队列已满时的处理策略, 见 SendPolicy
+
+	queues     [NumPriorities]*sendQueue // 各优先级的发送管道
+	weighted   bool                      // 是否按权重调度, 否则为严格优先级
+	credits    [NumPriorities]int        // 加权调度时各队列本轮剩余的额度
+	stopedChan chan struct{}
 
-	closed int32 // session是否关闭，-1未开启，0未关闭，1关闭
+	coalesce   *CoalesceConfig // 合并写入配置, nil 表示逐个封包调用 SendPacket
+	batch      []*sendItem     // 合并写入时正在处理的封包
+	batchBufs  [][]byte
+	batchPool  []*Buffer // 合并写入时使用的池化缓冲区, 写出后归还
+	batchWrite net.Buffers
 
-	sendChan   chan interface{} // 发送管道
-	stopedChan chan interface{}
+	fragment     *FragmentConfig           // 分片配置, nil 表示不拆分封包
+	fragJobs     []*fragmentJob            // 正在分片发送的封包
+	nextMsgID    uint32                    // 分片封包编号
+	partials     map[uint32]*partialPacket // 正在重组的封包
+	reassembling int                       // 重组中的封包总字节数
+
+	recording *recordConn // 录制收发数据, nil 表示不录制
+	clock     Clock       // 超时与定时器使用的时钟
 }
 
+// NewSession 新建会话, 各优先级发送队列的容量均为 sendChanSize, 可通过 SetQueueCapacity 单独调整
 func NewSession(conn net.Conn, protocol IPacketProtocol, handler PacketHandler, sendChanSize int) *Session {
-	return &Session{
+	session := &Session{
+		id:            atomic.AddUint64(&sessionSeq, 1),
 		conn:          conn,
 		protocol:      protocol,
 		packetHandler: handler,
 		closed:        -1,
-		stopedChan:    make(chan interface{}),
-		sendChan:      make(chan interface{}, sendChanSize),
+		stopedChan:    make(chan struct{}),
+		clock:         RealClock,
+	}
+	session.ctx, session.cancel = context.WithCancelCause(context.WithValue(context.Background(), sessionKey{}, session))
+
+	for p := range session.queues {
+		session.queues[p] = newSendQueue(sendChanSize)
 	}
+
+	return session
+}
+
+// ID 会话ID, 从 1 开始递增
+func (s *Session) ID() uint64 {
+	return s.id
+}
+
+// SetRecorder 录制会话收发的原始数据, 需在 Start 之前调用
+func (s *Session) SetRecorder(recorder *Recorder) {
+	s.recording = &recordConn{Conn: s.conn, recorder: recorder, sessionID: s.id}
+	s.conn = s.recording
+}
+
+// SetClock 替换会话使用的时钟, 需在 Start 之前调用
+func (s *Session) SetClock(clock Clock) {
+	s.clock = clockOrDefault(clock)
 }
 
 // RawConn return net.Conn
 func (s *Session) RawConn() net.Conn {
-	return s.conn.(*net.TCPConn)
+	return s.conn
 }
 
-// Close 关闭连接并释放相关资源.
+// Close 关闭连接并释放相关资源, 关闭原因为 CloseLocal
 func (s *Session) Close() error {
+	return s.CloseWithReason(CloseLocal, nil)
+}
+
+// CloseWithReason 以指定原因关闭会话, 如服务端踢人时使用 CloseKicked。
+// 只有第一次关闭生效, 之后的调用不会改变 Err 返回的原因。
+func (s *Session) CloseWithReason(kind CloseKind, cause error) error {
 	if atomic.CompareAndSwapInt32(&s.closed, 0, 1) {
+		reason := &CloseReason{Kind: kind, Err: cause}
+		s.reason.Store(reason)
 		_ = s.conn.Close()
 		close(s.stopedChan)
+		s.cancel(reason)
 
 		if s.closeCallback != nil {
-			s.closeCallback(s.conn)
+			s.closeCallback(s.conn, reason)
 		}
+
+		s.lock.Lock()
+		hooks := s.closeHooks
+		s.closeHooks = nil
+		s.lock.Unlock()
+		for _, hook := range hooks {
+			hook(reason)
+		}
+	}
+
+	return nil
+}
+
+// addCloseHook 添加会话关闭时执行的钩子, 会话已关闭时立即执行
+func (s *Session) addCloseHook(hook func(*CloseReason)) {
+	s.lock.Lock()
+	if s.reason.Load() == nil {
+		s.closeHooks = append(s.closeHooks, hook)
+		s.lock.Unlock()
+		return
 	}
+	s.lock.Unlock()
+
+	hook(s.reason.Load())
+}
 
+// Err 会话未关闭时返回 nil, 否则返回 *CloseReason
+func (s *Session) Err() error {
+	if reason := s.reason.Load(); reason != nil {
+		return reason
+	}
 	return nil
 }
 
+// closedError 会话关闭后返回给发送方的错误, 带有关闭原因
+func (s *Session) closedError() error {
+	if reason := s.reason.Load(); reason != nil {
+		return reason
+	}
+	return ErrSessionClosed
+}
+
+// Context 会话的 context, 携带会话本身, 会话关闭时取消, context.Cause 返回 *CloseReason
+func (s *Session) Context() context.Context {
+	return s.ctx
+}
+
+// Principal 获取通过 SetPrincipal 设置的认证主体, goroutine safe
+func (s *Session) Principal() interface{} {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.principal
+}
+
+// SetPrincipal 设置认证主体, 如鉴权通过后的用户信息, 之后可通过 PrincipalFromContext 取得, goroutine safe
+func (s *Session) SetPrincipal(principal interface{}) {
+	s.lock.Lock()
+	s.principal = principal
+	s.lock.Unlock()
+}
+
+// Done 会话关闭后返回的管道被关闭
+func (s *Session) Done() <-chan struct{} {
+	return s.stopedChan
+}
+
 func (s *Session) SetCloseCallback(callback FnCallbackClosed) {
 	s.closeCallback = callback
 }
@@ -88,82 +238,165 @@ func (s *Session) SetSendCallback(callback FnCallbackSended) {
 	s.sendCallback = callback
 }
 
+// SetSendPolicy 设置发送队列已满时的处理策略, goroutine safe
+func (s *Session) SetSendPolicy(policy SendPolicy) {
+	atomic.StoreInt32(&s.sendPolicy, int32(policy))
+}
+
 // SetReadDeadline 设置读取的超时时间
 // goroutine safe, 如果不需要设置，则不要调用
 func (s *Session) SetReadDeadline(delt time.Duration) {
 	s.lock.Lock()
-	_ = s.conn.SetReadDeadline(time.Now().Add(delt)) // timeout
+	_ = s.conn.SetReadDeadline(s.clock.Now().Add(delt)) // timeout
 	defer s.lock.Unlock()
 }
 
 func (s *Session) sendLoop() {
+	var item *sendItem // 正在处理的封包, 组包时发生panic也需要回传结果
+
 	defer func() {
 		if p := recover(); p != nil {
+			panicErr := newPanicError(p)
+			if item != nil {
+				item.done(0, fmt.Errorf("%w: %w", ErrWritePacketFailed, panicErr))
+			}
+			for _, pending := range s.batch {
+				pending.done(0, fmt.Errorf("%w: %w", ErrWritePacketFailed, panicErr))
+			}
 			fmt.Printf("panic recover! p: %+v", p)
-			buf := make([]byte, 1<<16)
-			runtime.Stack(buf, true)
-			fmt.Printf("%s\n", string(buf))
+			fmt.Printf("%s\n", string(panicErr.Stack))
+			_ = s.CloseWithReason(ClosePanic, panicErr)
 		}
 
 		_ = s.Close()
+		s.abortFragments(s.closedError())
+		s.drainSendChan()
 	}()
 
 	var err error
 
 	for {
-		select {
-		case <-s.stopedChan:
-			{
+		if len(s.fragJobs) == 0 {
+			if item = s.waitItem(nil); item == nil {
 				fmt.Println("exit,exit,exit")
 				return
 			}
-		case packet, ok := <-s.sendChan:
-			{
-				if !ok {
-					fmt.Printf("发送循环已退出...\n")
-					return
-				}
-
-				pkgcnt := s.protocol.BuildPacket(packet)
-				err = s.protocol.SendPacket(s.conn, pkgcnt)
+		} else {
+			// 有未发完的分片时不阻塞, 普通封包与分片交替发送
+			select {
+			case <-s.stopedChan:
+				return
+			default:
+				item = s.pollItem()
+			}
+		}
 
-				if err != nil {
-					fmt.Printf("发送循环已退出, 错误信息为:%v...\n", err)
-					return
-				}
+		if item != nil {
+			err = s.writeItem(item)
+			item = nil
+			if err != nil {
+				fmt.Printf("发送循环已退出, 错误信息为:%v...\n", err)
+				_ = s.CloseWithReason(CloseWriteError, err)
+				return
+			}
+		}
 
-				if s.sendCallback != nil {
-					s.sendCallback(s.conn, packet)
-				}
+		if len(s.fragJobs) > 0 {
+			if err = s.sendFragment(); err != nil {
+				fmt.Printf("发送循环已退出, 错误信息为:%v...\n", err)
+				_ = s.CloseWithReason(CloseWriteError, err)
+				return
 			}
 		}
 	}
 }
 
+// writeItem 组包并写出单个封包, 开启合并写入时顺带写出队列中的其它封包
+func (s *Session) writeItem(item *sendItem) error {
+	if s.coalesce != nil {
+		return s.sendBatch(item)
+	}
+
+	// 不合法的封包只让本次发送失败, 会话继续运行
+	pkgcnt, pooled, err := buildPacket(s.protocol, item.packet)
+	if err != nil {
+		item.done(0, err)
+		return nil
+	}
+	if s.startFragments(item, pkgcnt, pooled) {
+		return nil
+	}
+
+	err = s.protocol.SendPacket(s.conn, pkgcnt)
+	if pooled != nil {
+		pooled.Release()
+	}
+
+	if err != nil {
+		item.done(0, err)
+		return err
+	}
+
+	s.countWrite(len(pkgcnt))
+	item.done(len(pkgcnt), nil)
+	if s.sendCallback != nil {
+		s.sendCallback(s.conn, item.packet)
+	}
+
+	return nil
+}
+
 func (s *Session) recvLoop() {
 	defer func() {
 		if p := recover(); p != nil {
+			panicErr := newPanicError(p)
 			fmt.Printf("panic recover! p: %+v", p)
-			buf := make([]byte, 1<<16)
-			runtime.Stack(buf, true)
-			fmt.Printf("%s\n", string(buf))
+			fmt.Printf("%s\n", string(panicErr.Stack))
+			_ = s.CloseWithReason(ClosePanic, panicErr)
 		}
 		_ = s.Close()
 	}()
 
+	reader := &countingReader{Conn: s.conn, n: &s.counters.bytesRead}
+
 	for {
 		select {
 		case <-s.stopedChan:
 			return
 		default:
 			{
-				recvBuff, err := s.protocol.ReadPacket(s.conn)
-				if recvBuff == nil || nil != err {
+				recvBuff, err := s.protocol.ReadPacket(reader)
+				if s.recording != nil {
+					s.recording.flushInbound()
+				}
+				if nil != err {
 					fmt.Printf("Read packet error %+v", err)
+					_ = s.CloseWithReason(readCloseKind(err), err)
 					return
 				}
+				if recvBuff == nil {
+					_ = s.CloseWithReason(CloseDecodeError, ErrReadPacketFailed)
+					return
+				}
+				s.countRead()
 
-				s.packetHandler(s, recvBuff) // 任务封包分发
+				if frag, ok := recvBuff.(*Fragment); ok {
+					if recvBuff, err = s.reassemble(frag); nil != err {
+						fmt.Printf("Reassemble packet error %+v", err)
+						_ = s.CloseWithReason(CloseDecodeError, err)
+						return
+					}
+					if recvBuff == nil {
+						continue // 分片尚未收齐
+					}
+				}
+
+				s.dispatch(recvBuff) // 任务封包分发, handler 的 panic 在其中恢复
+
+				// 池化的封包在 handler 返回后归还
+				if releaser, ok := recvBuff.(IReleaser); ok {
+					releaser.Release()
+				}
 			}
 		}
 	}
@@ -172,21 +405,38 @@ func (s *Session) recvLoop() {
 // Start 开始会话，循环监听发送与接收
 func (s *Session) Start() {
 	if atomic.CompareAndSwapInt32(&s.closed, -1, 0) {
+		atomic.StoreInt64(&s.counters.connectedAt, s.clock.Now().UnixNano())
 		go s.sendLoop()
 		go s.recvLoop()
 	}
 }
 
-// Send 异步发送方法, 仅将 packet 写入 sendChan 中等待sendLoop处理,
-// 如果 sendChan 满了, 则return ErrSendChanBlocking。
+// Send 异步发送方法, 仅将 packet 写入普通优先级的 sendChan 中等待sendLoop处理,
 // 如果 sendChan 已关闭, 则return ErrSessionClosed。
+// 如果 sendChan 满了, 则按 SendPolicy 处理, 默认return ErrSendChanBlocking。
 func (s *Session) Send(packet interface{}) error {
-	select {
-	case s.sendChan <- packet:
-	case <-s.stopedChan:
-		return ErrSessionClosed
-	default:
-		return ErrSendChanBlocking
+	return s.enqueue(&sendItem{packet: packet, priority: PriorityNormal})
+}
+
+// SendPriority 以指定优先级发送, 其余行为与 Send 相同
+func (s *Session) SendPriority(packet interface{}, priority Priority) error {
+	return s.enqueue(&sendItem{packet: packet, priority: priority})
+}
+
+// SendContext 阻塞式发送, 直到 packet 写入 sendChan、会话关闭或 ctx 结束。
+// ctx 携带跟踪ID且协议实现 ITraceCarrier 时, 跟踪ID会写入封包。
+func (s *Session) SendContext(ctx context.Context, packet interface{}) error {
+	packet = injectTrace(ctx, s.protocol, packet)
+	return s.enqueueContext(ctx, &sendItem{packet: packet, priority: PriorityNormal})
+}
+
+// SendAsync 与 Send 相同, 但返回的管道会在封包真正写入连接(或失败)后收到结果。
+// 入队失败时结果立即可读; 会话关闭时仍在队列中的封包以 ErrSessionClosed 结束。
+func (s *Session) SendAsync(packet interface{}) <-chan SendResult {
+	item := &sendItem{packet: packet, priority: PriorityNormal, result: make(chan SendResult, 1)}
+	if err := s.enqueue(item); err != nil {
+		item.done(0, err)
 	}
-	return nil
+
+	return item.result
 }
@@ -0,0 +1,93 @@
+package socketgo
+
+import "net"
+
+// sessionConfig Server 与 AsyncClient 新建会话时共用的配置, 修改后对之后创建的会话生效
+type sessionConfig struct {
+	sendChanSize int        // 发送队列长度
+	sendPolicy   SendPolicy // 发送队列满载策略
+	coalesce     *CoalesceConfig
+	fragment     *FragmentConfig
+	queueCaps    map[Priority]int // 单独设置过容量的优先级队列
+	weights      []int            // 非空时按权重调度各优先级队列
+	recorder     *Recorder
+	clock        Clock // nil 表示 RealClock
+	panicHook    FnHandlerPanic
+}
+
+// SetSendChanSize 设置发送队列长度
+func (c *sessionConfig) SetSendChanSize(size int) {
+	c.sendChanSize = size
+}
+
+// SetSendPolicy 设置发送队列已满时的处理策略
+func (c *sessionConfig) SetSendPolicy(policy SendPolicy) {
+	c.sendPolicy = policy
+}
+
+// SetWriteCoalescing 开启合并写入
+func (c *sessionConfig) SetWriteCoalescing(cfg CoalesceConfig) {
+	c.coalesce = &cfg
+}
+
+// SetFragmentation 开启大封包分片发送, 见 Session.SetFragmentation
+func (c *sessionConfig) SetFragmentation(cfg FragmentConfig) {
+	c.fragment = &cfg
+}
+
+// SetQueueCapacity 单独设置指定优先级队列的容量
+func (c *sessionConfig) SetQueueCapacity(priority Priority, capacity int) {
+	if c.queueCaps == nil {
+		c.queueCaps = make(map[Priority]int)
+	}
+	c.queueCaps[priority] = capacity
+}
+
+// SetPriorityWeights 按权重调度各优先级队列, 见 Session.SetPriorityWeights
+func (c *sessionConfig) SetPriorityWeights(weights ...int) {
+	c.weights = weights
+}
+
+// SetRecorder 录制之后创建的所有会话收发的数据, 见 Session.SetRecorder
+func (c *sessionConfig) SetRecorder(recorder *Recorder) {
+	c.recorder = recorder
+}
+
+// SetClock 替换之后创建的会话使用的时钟, 见 Session.SetClock
+func (c *sessionConfig) SetClock(clock Clock) {
+	c.clock = clock
+}
+
+// SetHandlerPanicHook 设置之后创建的会话在 handler 发生 panic 时的钩子, 见 Session.SetHandlerPanicHook
+func (c *sessionConfig) SetHandlerPanicHook(hook FnHandlerPanic) {
+	c.panicHook = hook
+}
+
+func (c *sessionConfig) newSession(conn net.Conn, protocol IPacketProtocol, handler PacketHandler) *Session {
+	session := NewSession(conn, protocol, handler, c.sendChanSize)
+	session.SetClock(c.clock)
+	session.SetSendPolicy(c.sendPolicy)
+	session.SetHandlerPanicHook(c.panicHook)
+
+	if c.coalesce != nil {
+		session.SetWriteCoalescing(*c.coalesce)
+	}
+
+	if c.fragment != nil {
+		session.SetFragmentation(*c.fragment)
+	}
+
+	for priority, capacity := range c.queueCaps {
+		session.SetQueueCapacity(priority, capacity)
+	}
+
+	if len(c.weights) > 0 {
+		session.SetPriorityWeights(c.weights...)
+	}
+
+	if c.recorder != nil {
+		session.SetRecorder(c.recorder)
+	}
+
+	return session
+}
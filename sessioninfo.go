@@ -0,0 +1,111 @@
+package socketgo
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// SessionInfo 会话元数据的快照
+type SessionInfo struct {
+	ID            uint64
+	LocalAddr     net.Addr
+	RemoteAddr    net.Addr
+	ConnectedAt   time.Time // Start 的时间
+	LastActivity  time.Time // 最近一次读取或写出封包的时间
+	BytesRead     uint64    // 读取的字节数, 包括封包头
+	BytesWritten  uint64    // 写出的字节数
+	FramesRead    uint64    // 读取的帧数, 分片按片计数
+	FramesWritten uint64    // 写出的帧数, 分片按片计数
+	QueueDepth    int       // 各优先级发送队列中等待发送的封包总数
+}
+
+// sessionCounters 会话的收发统计, 均以原子操作访问
+type sessionCounters struct {
+	connectedAt   int64 // UnixNano
+	lastActivity  int64 // UnixNano
+	bytesRead     uint64
+	bytesWritten  uint64
+	framesRead    uint64
+	framesWritten uint64
+}
+
+// Info 获取会话元数据, goroutine safe
+func (s *Session) Info() SessionInfo {
+	info := SessionInfo{
+		ID:            s.id,
+		LocalAddr:     s.conn.LocalAddr(),
+		RemoteAddr:    s.conn.RemoteAddr(),
+		BytesRead:     atomic.LoadUint64(&s.counters.bytesRead),
+		BytesWritten:  atomic.LoadUint64(&s.counters.bytesWritten),
+		FramesRead:    atomic.LoadUint64(&s.counters.framesRead),
+		FramesWritten: atomic.LoadUint64(&s.counters.framesWritten),
+	}
+
+	if at := atomic.LoadInt64(&s.counters.connectedAt); at != 0 {
+		info.ConnectedAt = time.Unix(0, at)
+	}
+	if at := atomic.LoadInt64(&s.counters.lastActivity); at != 0 {
+		info.LastActivity = time.Unix(0, at)
+	}
+
+	for _, q := range s.queues {
+		info.QueueDepth += len(q.ch)
+	}
+
+	return info
+}
+
+// Set 设置会话属性, 如连接所属的用户, goroutine safe
+func (s *Session) Set(key string, value interface{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.attrs == nil {
+		s.attrs = make(map[string]interface{})
+	}
+	s.attrs[key] = value
+}
+
+// Get 获取会话属性, goroutine safe
+func (s *Session) Get(key string) (interface{}, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	value, ok := s.attrs[key]
+	return value, ok
+}
+
+// Delete 删除会话属性, goroutine safe
+func (s *Session) Delete(key string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.attrs, key)
+}
+
+// countRead 记录读取的一帧
+func (s *Session) countRead() {
+	atomic.AddUint64(&s.counters.framesRead, 1)
+	atomic.StoreInt64(&s.counters.lastActivity, s.clock.Now().UnixNano())
+}
+
+// countWrite 记录写出的一帧
+func (s *Session) countWrite(n int) {
+	atomic.AddUint64(&s.counters.bytesWritten, uint64(n))
+	atomic.AddUint64(&s.counters.framesWritten, 1)
+	atomic.StoreInt64(&s.counters.lastActivity, s.clock.Now().UnixNano())
+}
+
+// countingReader 统计 ReadPacket 读取的字节数。
+// 只用于读取, 写入仍直接使用原连接, 以免合并写入时失去 writev。
+type countingReader struct {
+	net.Conn
+	n *uint64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Conn.Read(p)
+	atomic.AddUint64(r.n, uint64(n))
+	return n, err
+}
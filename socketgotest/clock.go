@@ -0,0 +1,213 @@
+package socketgotest
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	socket "github.com/datochan/socketgo"
+)
+
+// VirtualClock 只在调用 Advance 时前进的虚拟时钟, 实现了 socket.Clock。
+// 到期的定时器按到期时间(相同时按创建顺序)依次触发, AfterFunc 的回调在 Advance 中同步执行;
+// 等待定时器管道的 goroutine 在 Advance 返回后异步运行, 可用 BlockUntil 等待它们重新注册定时器。
+type VirtualClock struct {
+	lock    sync.Mutex
+	now     time.Time
+	timers  []*virtualTimer // 未触发的定时器, 按 (when, seq) 排序
+	seq     uint64
+	changed chan struct{} // 定时器增减时关闭并替换
+}
+
+// NewVirtualClock 新建从 start 开始的虚拟时钟
+func NewVirtualClock(start time.Time) *VirtualClock {
+	return &VirtualClock{now: start, changed: make(chan struct{})}
+}
+
+func (c *VirtualClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+func (c *VirtualClock) Since(t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+func (c *VirtualClock) NewTimer(d time.Duration) socket.Timer {
+	t := &virtualTimer{clock: c, ch: make(chan time.Time, 1)}
+	t.Reset(d)
+	return t
+}
+
+func (c *VirtualClock) NewTicker(d time.Duration) socket.Ticker {
+	if d <= 0 {
+		panic("socketgotest: non-positive interval for NewTicker")
+	}
+
+	t := &virtualTimer{clock: c, ch: make(chan time.Time, 1), period: d}
+	t.Reset(d)
+	return virtualTicker{t}
+}
+
+func (c *VirtualClock) AfterFunc(d time.Duration, f func()) socket.Timer {
+	t := &virtualTimer{clock: c, fn: f}
+	t.Reset(d)
+	return t
+}
+
+// Advance 时钟前进 d, 期间到期的定时器依次触发
+func (c *VirtualClock) Advance(d time.Duration) {
+	c.AdvanceTo(c.Now().Add(d))
+}
+
+// AdvanceTo 时钟前进到 target, target 早于当前时间时不变
+func (c *VirtualClock) AdvanceTo(target time.Time) {
+	for {
+		c.lock.Lock()
+		if len(c.timers) == 0 || c.timers[0].when.After(target) {
+			if target.After(c.now) {
+				c.now = target
+			}
+			c.lock.Unlock()
+			return
+		}
+
+		t := c.timers[0]
+		c.now = t.when
+		c.removeLocked(t)
+		if t.period > 0 {
+			t.when = t.when.Add(t.period)
+			c.insertLocked(t)
+		}
+		now := c.now
+		c.lock.Unlock()
+
+		t.fire(now)
+	}
+}
+
+// Timers 未触发的定时器数量
+func (c *VirtualClock) Timers() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.timers)
+}
+
+// BlockUntil 等待(真实时间)直到至少有 n 个未触发的定时器, 用于确认被测代码已进入等待状态
+func (c *VirtualClock) BlockUntil(n int) {
+	for {
+		c.lock.Lock()
+		if len(c.timers) >= n {
+			c.lock.Unlock()
+			return
+		}
+		changed := c.changed
+		c.lock.Unlock()
+
+		<-changed
+	}
+}
+
+func (c *VirtualClock) insertLocked(t *virtualTimer) {
+	c.seq++
+	t.seq = c.seq
+	t.active = true
+
+	i := sort.Search(len(c.timers), func(i int) bool {
+		other := c.timers[i]
+		return other.when.After(t.when) || (other.when.Equal(t.when) && other.seq > t.seq)
+	})
+	c.timers = append(c.timers, nil)
+	copy(c.timers[i+1:], c.timers[i:])
+	c.timers[i] = t
+
+	c.notifyLocked()
+}
+
+func (c *VirtualClock) removeLocked(t *virtualTimer) bool {
+	if !t.active {
+		return false
+	}
+
+	for i, other := range c.timers {
+		if other == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			break
+		}
+	}
+	t.active = false
+
+	c.notifyLocked()
+	return true
+}
+
+func (c *VirtualClock) notifyLocked() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// virtualTimer 虚拟时钟的 Timer 与 Ticker
+type virtualTimer struct {
+	clock  *VirtualClock
+	when   time.Time
+	period time.Duration // 大于 0 时为 Ticker
+	seq    uint64
+	active bool
+
+	ch chan time.Time
+	fn func()
+}
+
+func (t *virtualTimer) C() <-chan time.Time {
+	return t.ch
+}
+
+func (t *virtualTimer) Stop() bool {
+	t.clock.lock.Lock()
+	defer t.clock.lock.Unlock()
+	return t.clock.removeLocked(t)
+}
+
+// Reset d 不大于 0 时与 time.Timer 一样立即触发
+func (t *virtualTimer) Reset(d time.Duration) bool {
+	t.clock.lock.Lock()
+	active := t.clock.removeLocked(t)
+	now := t.clock.now
+	if d > 0 || t.period > 0 {
+		t.when = now.Add(d)
+		t.clock.insertLocked(t)
+		t.clock.lock.Unlock()
+		return active
+	}
+	t.clock.lock.Unlock()
+
+	if t.fn != nil {
+		go t.fn()
+	} else {
+		t.fire(now)
+	}
+	return active
+}
+
+// virtualTicker Ticker 的 Stop 没有返回值
+type virtualTicker struct {
+	*virtualTimer
+}
+
+func (t virtualTicker) Stop() {
+	t.virtualTimer.Stop()
+}
+
+func (t *virtualTimer) fire(now time.Time) {
+	if t.fn != nil {
+		t.fn()
+		return
+	}
+
+	// 与 time.Timer 一致, 未被取走的触发不会累积
+	select {
+	case t.ch <- now:
+	default:
+	}
+}
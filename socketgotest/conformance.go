@@ -0,0 +1,244 @@
+package socketgotest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	socket "github.com/datochan/socketgo"
+)
+
+// DefaultReadTimeout 一致性测试中单次 ReadPacket 的最长等待时间, 超时视为实现阻塞
+const DefaultReadTimeout = 2 * time.Second
+
+// errReadBlocked ReadPacket 在超时时间内没有返回
+var errReadBlocked = errors.New("socketgotest: ReadPacket blocked")
+
+// ProtocolSuite IPacketProtocol 实现的一致性测试, 在 _test.go 中调用:
+//
+//	func TestProtocol(t *testing.T) {
+//		socketgotest.ProtocolSuite{
+//			NewProtocol: func() socket.IPacketProtocol { return NewMyProtocol() },
+//			Packets:     []interface{}{req1, req2},
+//			Equal:       func(sent, received interface{}) bool { ... },
+//			Oversized:   oversizedFrame,
+//		}.Run(t)
+//	}
+type ProtocolSuite struct {
+	// NewProtocol 每个子测试新建一个协议实例, 有状态的实现不会相互影响
+	NewProtocol func() socket.IPacketProtocol
+	// Packets 组包用的样例封包, 至少一个
+	Packets []interface{}
+	// Equal 判断 ReadPacket 解析出的封包与发送的封包是否一致
+	Equal func(sent, received interface{}) bool
+	// Oversized 超过协议上限的原始数据(如长度字段超长的包头), ReadPacket 应返回错误; nil 时跳过
+	Oversized []byte
+	// ReadTimeout 单次 ReadPacket 的最长等待时间, 0 表示 DefaultReadTimeout
+	ReadTimeout time.Duration
+}
+
+// Run 依次运行所有子测试:
+//   - RoundTrip: BuildPacket 的结果经 ReadPacket 解析后与原封包一致
+//   - OneByteAtATime: 每次只写入、只读到一个字节时仍能正确分帧
+//   - BackToBack: 多个封包在同一次写入中到达时逐个解析, 不丢弃也不多读
+//   - Oversized: 超长封包被拒绝
+//   - TruncatedFrame: 封包在任意位置被截断后遇到 EOF, 返回错误而不是不完整的封包
+//   - EOFBeforeFrame: 没有数据时遇到 EOF 返回错误
+func (suite ProtocolSuite) Run(t *testing.T) {
+	t.Helper()
+
+	if suite.NewProtocol == nil || suite.Equal == nil || len(suite.Packets) == 0 {
+		t.Fatal("socketgotest: ProtocolSuite needs NewProtocol, Equal and at least one packet")
+	}
+
+	t.Run("RoundTrip", suite.testRoundTrip)
+	t.Run("OneByteAtATime", suite.testOneByteAtATime)
+	t.Run("BackToBack", suite.testBackToBack)
+	t.Run("Oversized", suite.testOversized)
+	t.Run("TruncatedFrame", suite.testTruncatedFrame)
+	t.Run("EOFBeforeFrame", suite.testEOFBeforeFrame)
+}
+
+func (suite ProtocolSuite) testRoundTrip(t *testing.T) {
+	for i, packet := range suite.Packets {
+		protocol := suite.NewProtocol()
+		reader, writer := pipe(t)
+
+		writeAsync(writer, protocol.BuildPacket(packet), false)
+		suite.expectPacket(t, protocol, reader, i)
+	}
+}
+
+func (suite ProtocolSuite) testOneByteAtATime(t *testing.T) {
+	protocol := suite.NewProtocol()
+	local, remote := pipe(t)
+	reader := WrapConn(local, FaultConfig{MaxReadSize: 1})
+	writer := WrapConn(remote, FaultConfig{MaxSegment: 1})
+
+	frames := suite.buildAll(protocol)
+	writeAsync(writer, bytes.Join(frames, nil), false)
+
+	for i := range suite.Packets {
+		suite.expectPacket(t, protocol, reader, i)
+	}
+}
+
+func (suite ProtocolSuite) testBackToBack(t *testing.T) {
+	protocol := suite.NewProtocol()
+	reader, writer := pipe(t)
+
+	// 每个样例发送两遍, 保证至少有两个封包在同一次读取中到达
+	frames := suite.buildAll(protocol)
+	all := bytes.Join(append(frames, frames...), nil)
+	writeAsync(writer, all, false)
+
+	for round := 0; round < 2; round++ {
+		for i := range suite.Packets {
+			suite.expectPacket(t, protocol, reader, i)
+		}
+	}
+}
+
+func (suite ProtocolSuite) testOversized(t *testing.T) {
+	if suite.Oversized == nil {
+		t.Skip("no Oversized frame given")
+	}
+
+	protocol := suite.NewProtocol()
+	reader, writer := pipe(t)
+
+	// 写完后不关闭, 实现必须根据包头直接拒绝, 而不是等待不存在的包体
+	writeAsync(writer, suite.Oversized, false)
+
+	packet, err := suite.read(protocol, reader)
+	if err == errReadBlocked {
+		t.Fatal("ReadPacket blocked on an oversized frame instead of rejecting it")
+	}
+	if err == nil {
+		release(packet)
+		t.Fatalf("ReadPacket accepted an oversized frame: %v", packet)
+	}
+}
+
+func (suite ProtocolSuite) testTruncatedFrame(t *testing.T) {
+	frame := suite.NewProtocol().BuildPacket(suite.Packets[0])
+
+	for cut := 1; cut < len(frame); cut++ {
+		protocol := suite.NewProtocol()
+		reader, writer := pipe(t)
+
+		writeAsync(writer, frame[:cut], true)
+
+		packet, err := suite.read(protocol, reader)
+		switch {
+		case err == errReadBlocked:
+			t.Fatalf("ReadPacket blocked after EOF at byte %d of %d", cut, len(frame))
+		case err == nil:
+			release(packet)
+			t.Fatalf("ReadPacket returned a packet for a frame truncated at byte %d of %d", cut, len(frame))
+		}
+	}
+}
+
+func (suite ProtocolSuite) testEOFBeforeFrame(t *testing.T) {
+	protocol := suite.NewProtocol()
+	reader, writer := pipe(t)
+	_ = writer.Close()
+
+	packet, err := suite.read(protocol, reader)
+	switch {
+	case err == errReadBlocked:
+		t.Fatal("ReadPacket blocked after EOF")
+	case err == nil:
+		release(packet)
+		t.Fatalf("ReadPacket returned %v without error at EOF", packet)
+	}
+}
+
+func (suite ProtocolSuite) buildAll(protocol socket.IPacketProtocol) [][]byte {
+	frames := make([][]byte, 0, len(suite.Packets))
+	for _, packet := range suite.Packets {
+		frames = append(frames, protocol.BuildPacket(packet))
+	}
+	return frames
+}
+
+// expectPacket 读取一个封包并与第 i 个样例比较
+func (suite ProtocolSuite) expectPacket(t *testing.T, protocol socket.IPacketProtocol, conn net.Conn, i int) {
+	t.Helper()
+
+	packet, err := suite.read(protocol, conn)
+	switch {
+	case err == errReadBlocked:
+		t.Fatalf("ReadPacket blocked while reading packet %d", i)
+	case err != nil:
+		t.Fatalf("ReadPacket failed on packet %d: %v", i, err)
+	case !suite.Equal(suite.Packets[i], packet):
+		t.Fatalf("packet %d mismatch: sent %v, received %v", i, suite.Packets[i], packet)
+	}
+
+	release(packet)
+}
+
+// read 带超时地调用 ReadPacket, 超时时关闭连接让阻塞的实现返回, 并返回 errReadBlocked
+func (suite ProtocolSuite) read(protocol socket.IPacketProtocol, conn net.Conn) (interface{}, error) {
+	timeout := suite.ReadTimeout
+	if timeout <= 0 {
+		timeout = DefaultReadTimeout
+	}
+
+	type result struct {
+		packet interface{}
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		// 实现中的 panic 作为错误报告, 不中断整个测试进程
+		defer func() {
+			if p := recover(); p != nil {
+				done <- result{nil, fmt.Errorf("socketgotest: ReadPacket panicked: %v", p)}
+			}
+		}()
+
+		packet, err := protocol.ReadPacket(conn)
+		done <- result{packet, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.packet, r.err
+	case <-time.After(timeout):
+		_ = conn.Close()
+		return nil, errReadBlocked
+	}
+}
+
+// pipe 新建内存连接, 子测试结束时关闭两端, 让阻塞在写入上的 writeAsync 退出
+func pipe(t *testing.T) (net.Conn, net.Conn) {
+	reader, writer := Pipe()
+	t.Cleanup(func() {
+		_ = reader.Close()
+		_ = writer.Close()
+	})
+
+	return reader, writer
+}
+
+// writeAsync 在后台写入数据, 以免超过管道缓存的数据阻塞测试; closeAfter 为 true 时写完后关闭连接
+func writeAsync(conn net.Conn, data []byte, closeAfter bool) {
+	go func() {
+		_, _ = conn.Write(data)
+		if closeAfter {
+			_ = conn.Close()
+		}
+	}()
+}
+
+func release(packet interface{}) {
+	if releaser, ok := packet.(socket.IReleaser); ok {
+		releaser.Release()
+	}
+}
@@ -0,0 +1,44 @@
+package socketgotest_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	socket "github.com/datochan/socketgo"
+	"github.com/datochan/socketgo/socketgotest"
+)
+
+func newLengthFieldProtocol() *socket.LengthFieldProtocol {
+	return &socket.LengthFieldProtocol{
+		HeaderSize:   8,
+		LengthOffset: 4,
+		LengthSize:   4,
+		IDOffset:     0,
+		IDSize:       4,
+		ByteOrder:    binary.LittleEndian,
+	}
+}
+
+// frame 组装事件ID为 id、包体为 body 的封包, 长度字段在组包时回填
+func frame(id uint32, body string) []byte {
+	packet := make([]byte, 8, 8+len(body))
+	binary.LittleEndian.PutUint32(packet, id)
+	return append(packet, body...)
+}
+
+func TestLengthFieldProtocolConformance(t *testing.T) {
+	protocol := newLengthFieldProtocol()
+
+	oversized := make([]byte, 8)
+	binary.LittleEndian.PutUint32(oversized[4:], socket.DefaultMaxFrameSize+1)
+
+	socketgotest.ProtocolSuite{
+		NewProtocol: func() socket.IPacketProtocol { return newLengthFieldProtocol() },
+		Packets:     []interface{}{frame(1, "hello"), frame(2, ""), frame(3, string(make([]byte, 70000)))},
+		Equal: func(sent, received interface{}) bool {
+			return bytes.Equal(protocol.BuildPacket(sent), received.(*socket.Buffer).B)
+		},
+		Oversized: oversized,
+	}.Run(t)
+}
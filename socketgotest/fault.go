@@ -0,0 +1,352 @@
+package socketgotest
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	socket "github.com/datochan/socketgo"
+)
+
+// ErrInjectedReset 连接被故障注入重置
+var ErrInjectedReset = errors.New("socketgotest: injected connection reset")
+
+// FaultConfig 故障注入配置, 零值表示不注入任何故障。
+// 同一 Seed 下每个连接的读、写两个方向各自使用固定的随机序列, 失败的用例可按 Seed 复现。
+type FaultConfig struct {
+	Seed int64
+
+	Latency     time.Duration // 每次写入前的延迟
+	Jitter      time.Duration // 延迟的随机抖动, 取 [0, Jitter)
+	Bandwidth   int           // 每秒最多写出的字节数, 0 表示不限制
+	MaxSegment  int           // 写入被拆分为 1..MaxSegment 字节的随机片段, 0 表示不拆分
+	CorruptRate float64       // 每个写出的字节被篡改的概率
+
+	ReadDelay     time.Duration // 每次读取前的延迟
+	MaxReadSize   int           // 每次读取最多返回 1..MaxReadSize 字节, 模拟短读, 0 表示不限制
+	StallAfter    int64         // 累计读取该字节数后停顿一次, 0 表示不停顿
+	StallDuration time.Duration // 停顿时长, 0 表示直到连接关闭
+
+	ResetAfter int64 // 读或写任一方向累计达到该字节数后重置连接, 两个方向分别计数, 0 表示不重置
+
+	Clock socket.Clock // 延迟、限速、停顿与读写截止时间的计时时钟, nil 表示 socket.RealClock
+}
+
+// deadline 读或写方向的截止时间, 修改时唤醒正在等待的 sleep
+type deadline struct {
+	lock    sync.Mutex
+	t       time.Time
+	changed chan struct{}
+}
+
+func (d *deadline) set(t time.Time) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.t = t
+	if d.changed != nil {
+		close(d.changed)
+	}
+	d.changed = make(chan struct{})
+}
+
+func (d *deadline) get() (time.Time, <-chan struct{}) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.changed == nil {
+		d.changed = make(chan struct{})
+	}
+	return d.t, d.changed
+}
+
+// faultConn 注入故障的连接
+type faultConn struct {
+	net.Conn
+	cfg FaultConfig
+
+	wlock     sync.Mutex
+	wrand     *rand.Rand
+	written   int64
+	wdeadline deadline
+
+	rlock     sync.Mutex
+	rrand     *rand.Rand
+	read      int64
+	stalled   bool
+	stallEnd  time.Time
+	rdeadline deadline
+
+	reset int32
+
+	once       sync.Once
+	stopedChan chan struct{}
+}
+
+// WrapConn 为连接注入故障
+func WrapConn(conn net.Conn, cfg FaultConfig) net.Conn {
+	if cfg.Clock == nil {
+		cfg.Clock = socket.RealClock
+	}
+
+	return &faultConn{
+		Conn:       conn,
+		cfg:        cfg,
+		wrand:      rand.New(rand.NewSource(cfg.Seed)),
+		rrand:      rand.New(rand.NewSource(cfg.Seed ^ 0x5deece66d)),
+		stopedChan: make(chan struct{}),
+	}
+}
+
+// faultListener 为接受的每个连接注入故障, 第 i 个连接使用 Seed+i
+type faultListener struct {
+	net.Listener
+	cfg   FaultConfig
+	count int64
+}
+
+// WrapListener 为 listener 接受的连接注入故障, 可直接传给 socket.NewServerWithListener
+func WrapListener(listener net.Listener, cfg FaultConfig) net.Listener {
+	return &faultListener{Listener: listener, cfg: cfg}
+}
+
+func (l *faultListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := l.cfg
+	cfg.Seed += atomic.AddInt64(&l.count, 1) - 1
+	return WrapConn(conn, cfg), nil
+}
+
+// WrapDialer 为 dial 建立的连接注入故障, 第 i 个连接使用 Seed+i; dial 为 nil 时使用 net.Dial
+func WrapDialer(dial socket.Dialer, cfg FaultConfig) socket.Dialer {
+	if dial == nil {
+		dial = net.Dial
+	}
+
+	var count int64
+	return func(network, address string) (net.Conn, error) {
+		conn, err := dial(network, address)
+		if err != nil {
+			return nil, err
+		}
+
+		connCfg := cfg
+		connCfg.Seed += atomic.AddInt64(&count, 1) - 1
+		return WrapConn(conn, connCfg), nil
+	}
+}
+
+func (c *faultConn) Write(p []byte) (int, error) {
+	c.wlock.Lock()
+	defer c.wlock.Unlock()
+
+	if err := c.sleep(c.cfg.Latency+c.jitter(c.wrand), &c.wdeadline); err != nil {
+		return 0, err
+	}
+
+	written := 0
+	for written < len(p) {
+		size := len(p) - written
+		if c.cfg.MaxSegment > 0 {
+			size = min(size, 1+c.wrand.Intn(c.cfg.MaxSegment))
+		}
+
+		size, err := c.allow(c.written, size)
+		if err != nil {
+			return written, err
+		}
+
+		segment := p[written : written+size]
+		if c.cfg.CorruptRate > 0 {
+			segment = c.corrupt(segment)
+		}
+
+		n, err := c.Conn.Write(segment)
+		written += n
+		c.written += int64(n)
+		if err != nil {
+			return written, err
+		}
+
+		if c.cfg.Bandwidth > 0 {
+			if err = c.sleep(time.Duration(n)*time.Second/time.Duration(c.cfg.Bandwidth), &c.wdeadline); err != nil {
+				return written, err
+			}
+		}
+	}
+
+	return written, nil
+}
+
+func (c *faultConn) Read(p []byte) (int, error) {
+	c.rlock.Lock()
+	defer c.rlock.Unlock()
+
+	if c.cfg.StallAfter > 0 && !c.stalled && c.read >= c.cfg.StallAfter {
+		if err := c.stall(); err != nil {
+			return 0, err
+		}
+		c.stalled = true
+	}
+
+	if err := c.sleep(c.cfg.ReadDelay, &c.rdeadline); err != nil {
+		return 0, err
+	}
+
+	if c.cfg.MaxReadSize > 0 && len(p) > 1 {
+		p = p[:min(len(p), 1+c.rrand.Intn(c.cfg.MaxReadSize))]
+	}
+	if c.cfg.StallAfter > 0 && !c.stalled {
+		// 停顿恰好发生在第 StallAfter 个字节处
+		p = p[:min(int64(len(p)), c.cfg.StallAfter-c.read)]
+	}
+
+	size, err := c.allow(c.read, len(p))
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := c.Conn.Read(p[:size])
+	c.read += int64(n)
+
+	return n, err
+}
+
+// SetDeadline 截止时间按 Clock 计算, 同时作用于注入的延迟与停顿和底层连接
+func (c *faultConn) SetDeadline(t time.Time) error {
+	c.rdeadline.set(t)
+	c.wdeadline.set(t)
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *faultConn) SetReadDeadline(t time.Time) error {
+	c.rdeadline.set(t)
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *faultConn) SetWriteDeadline(t time.Time) error {
+	c.wdeadline.set(t)
+	return c.Conn.SetWriteDeadline(t)
+}
+
+func (c *faultConn) Close() error {
+	c.once.Do(func() { close(c.stopedChan) })
+	return c.Conn.Close()
+}
+
+// allow 按 ResetAfter 截断本次读写的字节数, transferred 为该方向已传输的字节数, 已达上限时重置连接。
+// 读写分别计数, 触发重置的方向与字节位置只取决于 Seed 和该方向的数据, 不受两个方向 goroutine 调度的影响。
+func (c *faultConn) allow(transferred int64, size int) (int, error) {
+	if atomic.LoadInt32(&c.reset) == 1 {
+		return 0, ErrInjectedReset
+	}
+
+	if c.cfg.ResetAfter <= 0 {
+		return size, nil
+	}
+
+	remaining := c.cfg.ResetAfter - transferred
+	if remaining <= 0 {
+		c.resetConn()
+		return 0, ErrInjectedReset
+	}
+
+	return int(min(int64(size), remaining)), nil
+}
+
+// resetConn 关闭连接, TCP 连接通过 SO_LINGER=0 发送 RST
+func (c *faultConn) resetConn() {
+	if !atomic.CompareAndSwapInt32(&c.reset, 0, 1) {
+		return
+	}
+
+	if tcpConn, ok := c.Conn.(*net.TCPConn); ok {
+		_ = tcpConn.SetLinger(0)
+	}
+	_ = c.Close()
+}
+
+func (c *faultConn) corrupt(segment []byte) []byte {
+	out := append([]byte(nil), segment...)
+	for i := range out {
+		if c.wrand.Float64() < c.cfg.CorruptRate {
+			out[i] ^= byte(1 + c.wrand.Intn(255))
+		}
+	}
+	return out
+}
+
+func (c *faultConn) jitter(r *rand.Rand) time.Duration {
+	if c.cfg.Jitter <= 0 {
+		return 0
+	}
+	return time.Duration(r.Int63n(int64(c.cfg.Jitter)))
+}
+
+// stall 停顿到 StallDuration 结束, 读超时返回后再次读取仍停顿到原定的结束时刻
+func (c *faultConn) stall() error {
+	if c.cfg.StallDuration <= 0 {
+		return c.wait(nil, &c.rdeadline)
+	}
+
+	if c.stallEnd.IsZero() {
+		c.stallEnd = c.cfg.Clock.Now().Add(c.cfg.StallDuration)
+	}
+	return c.sleep(c.stallEnd.Sub(c.cfg.Clock.Now()), &c.rdeadline)
+}
+
+// sleep 等待 d, 期间连接关闭返回 net.ErrClosed, 超过该方向的截止时间返回 os.ErrDeadlineExceeded
+func (c *faultConn) sleep(d time.Duration, dl *deadline) error {
+	if d <= 0 {
+		return nil
+	}
+
+	timer := c.cfg.Clock.NewTimer(d)
+	defer timer.Stop()
+
+	return c.wait(timer.C(), dl)
+}
+
+// wait 等待 done, done 为 nil 时一直等到连接关闭或超时; 等待期间修改截止时间按新值重新计时
+func (c *faultConn) wait(done <-chan time.Time, dl *deadline) error {
+	for {
+		t, changed := dl.get()
+		if t.IsZero() {
+			select {
+			case <-done:
+				return nil
+			case <-c.stopedChan:
+				return net.ErrClosed
+			case <-changed:
+				continue
+			}
+		}
+
+		remaining := t.Sub(c.cfg.Clock.Now())
+		if remaining <= 0 {
+			return os.ErrDeadlineExceeded
+		}
+
+		expired := c.cfg.Clock.NewTimer(remaining)
+		select {
+		case <-done:
+			expired.Stop()
+			return nil
+		case <-c.stopedChan:
+			expired.Stop()
+			return net.ErrClosed
+		case <-expired.C():
+			return os.ErrDeadlineExceeded
+		case <-changed:
+			expired.Stop()
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package socketgotest_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/datochan/socketgo/socketgotest"
+)
+
+func TestFaultResetAfterPerDirection(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+
+	conn := socketgotest.WrapConn(local, socketgotest.FaultConfig{ResetAfter: 8})
+	defer conn.Close()
+
+	// 先读入 6 字节, 读方向的计数不影响写方向的重置位置
+	go func() { _, _ = remote.Write(make([]byte, 6)) }()
+	if _, err := io.ReadFull(conn, make([]byte, 6)); err != nil {
+		t.Fatal(err)
+	}
+
+	go func() { _, _ = io.Copy(io.Discard, remote) }()
+	n, err := conn.Write(make([]byte, 16))
+	if n != 8 || !errors.Is(err, socketgotest.ErrInjectedReset) {
+		t.Fatalf("Write = %d, %v, want 8, ErrInjectedReset", n, err)
+	}
+}
+
+// readSegments 从 remote 读取 n 字节, net.Pipe 每次读取最多返回对端一次写入的数据, 即注入后的一个片段
+func readSegments(t *testing.T, remote net.Conn, n int) [][]byte {
+	t.Helper()
+
+	var segments [][]byte
+	for received := 0; received < n; {
+		buf := make([]byte, n)
+		size, err := remote.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		segments = append(segments, buf[:size])
+		received += size
+	}
+
+	return segments
+}
+
+// writeThrough 经注入故障的连接写出 data, 返回对端收到的片段
+func writeThrough(t *testing.T, cfg socketgotest.FaultConfig, data []byte) [][]byte {
+	t.Helper()
+
+	local, remote := net.Pipe()
+	defer remote.Close()
+
+	conn := socketgotest.WrapConn(local, cfg)
+	defer conn.Close()
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := conn.Write(data)
+		errs <- err
+	}()
+
+	segments := readSegments(t, remote, len(data))
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+
+	return segments
+}
+
+func TestFaultMaxSegment(t *testing.T) {
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	segments := writeThrough(t, socketgotest.FaultConfig{MaxSegment: 16}, data)
+	if len(segments) < len(data)/16 {
+		t.Fatalf("%d segments, want at least %d", len(segments), len(data)/16)
+	}
+	for _, segment := range segments {
+		if len(segment) < 1 || len(segment) > 16 {
+			t.Fatalf("segment of %d bytes, want 1..16", len(segment))
+		}
+	}
+	if got := bytes.Join(segments, nil); !bytes.Equal(got, data) {
+		t.Fatal("segments differ from the written data")
+	}
+}
+
+func TestFaultSeedReproducible(t *testing.T) {
+	data := make([]byte, 1000)
+
+	run := func(seed int64) [][]byte {
+		return writeThrough(t, socketgotest.FaultConfig{Seed: seed, MaxSegment: 16, CorruptRate: 0.05}, data)
+	}
+
+	// 同一 Seed 的拆分位置与篡改内容完全一致, 不同 Seed 则不同
+	first, second := run(7), run(7)
+	if len(first) != len(second) {
+		t.Fatalf("same seed gave %d and %d segments", len(first), len(second))
+	}
+	for i := range first {
+		if !bytes.Equal(first[i], second[i]) {
+			t.Fatalf("same seed differs at segment %d", i)
+		}
+	}
+
+	if other := run(8); bytes.Equal(bytes.Join(other, nil), bytes.Join(first, nil)) && len(other) == len(first) {
+		t.Fatal("different seeds gave identical output")
+	}
+}
+
+func TestFaultCorrupt(t *testing.T) {
+	data := make([]byte, 1000)
+
+	for _, tc := range []struct {
+		rate     float64
+		min, max int
+	}{{1, 1000, 1000}, {0.1, 1, 999}} {
+		got := bytes.Join(writeThrough(t, socketgotest.FaultConfig{Seed: 1, CorruptRate: tc.rate}, data), nil)
+
+		changed := 0
+		for i := range got {
+			if got[i] != data[i] {
+				changed++
+			}
+		}
+		if changed < tc.min || changed > tc.max {
+			t.Fatalf("CorruptRate %v changed %d bytes, want %d..%d", tc.rate, changed, tc.min, tc.max)
+		}
+	}
+}
+
+// readResult 一次读取的结果
+type readResult struct {
+	data []byte
+	err  error
+}
+
+func readAsync(conn net.Conn, size int) <-chan readResult {
+	results := make(chan readResult, 1)
+	go func() {
+		buf := make([]byte, size)
+		n, err := conn.Read(buf)
+		results <- readResult{buf[:n], err}
+	}()
+	return results
+}
+
+func TestFaultStallAfter(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+
+	clock := socketgotest.NewVirtualClock(time.Now())
+	conn := socketgotest.WrapConn(local, socketgotest.FaultConfig{StallAfter: 4, StallDuration: time.Second, Clock: clock})
+	defer conn.Close()
+
+	go func() { _, _ = remote.Write([]byte("12345678")) }()
+
+	// 第一次读取恰好停在第 StallAfter 个字节
+	if res := <-readAsync(conn, 8); res.err != nil || string(res.data) != "1234" {
+		t.Fatalf("Read = %q, %v, want 1234", res.data, res.err)
+	}
+
+	results := readAsync(conn, 8)
+	clock.BlockUntil(1)
+	clock.Advance(time.Second - time.Millisecond)
+	select {
+	case res := <-results:
+		t.Fatalf("Read returned %q, %v during the stall", res.data, res.err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	clock.Advance(time.Millisecond)
+	if res := <-results; res.err != nil || string(res.data) != "5678" {
+		t.Fatalf("Read after stall = %q, %v, want 5678", res.data, res.err)
+	}
+}
+
+func TestFaultStallHonorsReadDeadline(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+
+	clock := socketgotest.NewVirtualClock(time.Now())
+	conn := socketgotest.WrapConn(local, socketgotest.FaultConfig{StallAfter: 4, Clock: clock})
+	defer conn.Close()
+
+	go func() { _, _ = remote.Write([]byte("1234")) }()
+	if res := <-readAsync(conn, 4); res.err != nil {
+		t.Fatal(res.err)
+	}
+
+	// 无限停顿期间读超时照常生效, 会话的心跳超时可以据此触发
+	_ = conn.SetReadDeadline(clock.Now().Add(time.Second))
+	results := readAsync(conn, 4)
+	clock.BlockUntil(1)
+	clock.Advance(time.Second)
+	if res := <-results; !errors.Is(res.err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Read = %v, want ErrDeadlineExceeded", res.err)
+	}
+
+	// 清除截止时间后仍处于停顿中, 直到连接关闭
+	_ = conn.SetReadDeadline(time.Time{})
+	results = readAsync(conn, 4)
+	select {
+	case res := <-results:
+		t.Fatalf("Read returned %v while still stalled", res.err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	_ = conn.Close()
+	if res := <-results; !errors.Is(res.err, net.ErrClosed) {
+		t.Fatalf("Read after Close = %v, want net.ErrClosed", res.err)
+	}
+}
+
+func TestFaultLatencyHonorsWriteDeadline(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+
+	clock := socketgotest.NewVirtualClock(time.Now())
+	conn := socketgotest.WrapConn(local, socketgotest.FaultConfig{Latency: time.Hour, Clock: clock})
+	defer conn.Close()
+
+	_ = conn.SetWriteDeadline(clock.Now().Add(time.Second))
+	errs := make(chan error, 1)
+	go func() {
+		_, err := conn.Write([]byte("x"))
+		errs <- err
+	}()
+
+	// 延迟与截止时间各一个定时器
+	clock.BlockUntil(2)
+	clock.Advance(time.Second)
+	if err := <-errs; !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Write = %v, want ErrDeadlineExceeded", err)
+	}
+}
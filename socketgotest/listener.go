@@ -0,0 +1,68 @@
+package socketgotest
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	socket "github.com/datochan/socketgo"
+)
+
+// Listener 内存中的 listener, 可直接传给 socket.NewServerWithListener,
+// 客户端通过 SetDialer(listener.Dial) 连接, 不占用端口
+type Listener struct {
+	addr     Addr
+	capacity int
+	conns    chan net.Conn
+	nextPort uint32
+
+	once       sync.Once
+	stopedChan chan struct{}
+}
+
+// NewListener 新建内存 listener, name 作为服务端地址
+func NewListener(name string) *Listener {
+	return &Listener{
+		addr:       Addr(name),
+		capacity:   DefaultPipeCapacity,
+		conns:      make(chan net.Conn),
+		stopedChan: make(chan struct{}),
+	}
+}
+
+// SetPipeCapacity 设置之后建立的连接每个方向缓存的字节数
+func (l *Listener) SetPipeCapacity(capacity int) {
+	l.capacity = capacity
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.stopedChan:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *Listener) Close() error {
+	l.once.Do(func() { close(l.stopedChan) })
+	return nil
+}
+
+func (l *Listener) Addr() net.Addr {
+	return l.addr
+}
+
+// Dial 建立到该 listener 的内存连接, 签名与 socket.Dialer 相同, network 与 address 被忽略
+func (l *Listener) Dial(network, address string) (net.Conn, error) {
+	client := Addr(fmt.Sprintf("%s-client-%d", l.addr, atomic.AddUint32(&l.nextPort, 1)))
+	local, remote := newPipe(client, l.addr, l.capacity, socket.RealClock)
+
+	select {
+	case l.conns <- remote:
+		return local, nil
+	case <-l.stopedChan:
+		return nil, net.ErrClosed
+	}
+}
@@ -0,0 +1,259 @@
+package socketgotest
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	socket "github.com/datochan/socketgo"
+)
+
+// DefaultPipeCapacity 内存管道每个方向默认缓存的字节数, 缓存满时 Write 阻塞, 与 TCP 的发送窗口类似
+const DefaultPipeCapacity = 64 * 1024
+
+// Addr 内存连接的地址
+type Addr string
+
+func (a Addr) Network() string { return "mem" }
+func (a Addr) String() string  { return string(a) }
+
+// pipeBuffer 单向的有界缓存
+type pipeBuffer struct {
+	lock         sync.Mutex
+	data         []byte
+	capacity     int
+	writerClosed bool          // 写端关闭, 读完缓存后返回 io.EOF
+	readerClosed bool          // 读端关闭, 写入返回 io.ErrClosedPipe
+	notify       chan struct{} // 缓存状态变化时关闭并替换
+}
+
+func newPipeBuffer(capacity int) *pipeBuffer {
+	return &pipeBuffer{capacity: capacity, notify: make(chan struct{})}
+}
+
+// broadcast 唤醒等待中的读写, 调用方需持有锁
+func (b *pipeBuffer) broadcast() {
+	close(b.notify)
+	b.notify = make(chan struct{})
+}
+
+// pipeDeadline 读写截止时间, 按 clock 计时, 到期后 wait 返回的管道被关闭
+type pipeDeadline struct {
+	lock   sync.Mutex
+	clock  socket.Clock
+	timer  socket.Timer
+	cancel chan struct{}
+}
+
+func newPipeDeadline(clock socket.Clock) *pipeDeadline {
+	return &pipeDeadline{clock: clock, cancel: make(chan struct{})}
+}
+
+func (d *pipeDeadline) set(t time.Time) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.timer != nil && !d.timer.Stop() {
+		<-d.cancel // 等待计时器关闭 cancel
+	}
+	d.timer = nil
+
+	expired := isClosed(d.cancel)
+	if t.IsZero() {
+		if expired {
+			d.cancel = make(chan struct{})
+		}
+		return
+	}
+
+	if dur := t.Sub(d.clock.Now()); dur > 0 {
+		if expired {
+			d.cancel = make(chan struct{})
+		}
+		cancel := d.cancel
+		d.timer = d.clock.AfterFunc(dur, func() { close(cancel) })
+		return
+	}
+
+	if !expired {
+		close(d.cancel)
+	}
+}
+
+func (d *pipeDeadline) wait() chan struct{} {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.cancel
+}
+
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// pipeConn 内存连接的一端
+type pipeConn struct {
+	rd, wr        *pipeBuffer
+	local, remote net.Addr
+	path          *simPath // 模拟网络中写入的数据经 path 按虚拟时钟送达, nil 表示直接写入对端缓存
+	recvPath      *simPath // 模拟网络中对端写入本端的方向, 本端关闭后从链路中移除
+
+	readDeadline  *pipeDeadline
+	writeDeadline *pipeDeadline
+
+	once       sync.Once
+	stopedChan chan struct{}
+}
+
+// Pipe 新建一对带缓存的内存连接, 与 net.Pipe 不同, 写入在对端读取之前即可返回
+func Pipe() (net.Conn, net.Conn) {
+	return newPipe(Addr("pipe"), Addr("pipe"), DefaultPipeCapacity, socket.RealClock)
+}
+
+func newPipe(addr1, addr2 net.Addr, capacity int, clock socket.Clock) (*pipeConn, *pipeConn) {
+	b1, b2 := newPipeBuffer(capacity), newPipeBuffer(capacity)
+
+	c1 := &pipeConn{rd: b1, wr: b2, local: addr1, remote: addr2,
+		readDeadline: newPipeDeadline(clock), writeDeadline: newPipeDeadline(clock), stopedChan: make(chan struct{})}
+	c2 := &pipeConn{rd: b2, wr: b1, local: addr2, remote: addr1,
+		readDeadline: newPipeDeadline(clock), writeDeadline: newPipeDeadline(clock), stopedChan: make(chan struct{})}
+
+	return c1, c2
+}
+
+func (c *pipeConn) Read(p []byte) (int, error) {
+	for {
+		c.rd.lock.Lock()
+		switch {
+		case isClosed(c.stopedChan):
+			c.rd.lock.Unlock()
+			return 0, net.ErrClosed
+		case len(c.rd.data) > 0:
+			n := copy(p, c.rd.data)
+			c.rd.data = c.rd.data[n:]
+			c.rd.broadcast()
+			c.rd.lock.Unlock()
+			return n, nil
+		case c.rd.writerClosed:
+			c.rd.lock.Unlock()
+			return 0, io.EOF
+		}
+		notify := c.rd.notify
+		c.rd.lock.Unlock()
+
+		select {
+		case <-notify:
+		case <-c.stopedChan:
+		case <-c.readDeadline.wait():
+			return 0, os.ErrDeadlineExceeded
+		}
+	}
+}
+
+func (c *pipeConn) Write(p []byte) (int, error) {
+	if c.path != nil {
+		return c.path.write(c, p)
+	}
+
+	written := 0
+
+	for {
+		c.wr.lock.Lock()
+		switch {
+		case isClosed(c.stopedChan):
+			c.wr.lock.Unlock()
+			return written, net.ErrClosed
+		case c.wr.readerClosed:
+			c.wr.lock.Unlock()
+			return written, io.ErrClosedPipe
+		}
+
+		if free := c.wr.capacity - len(c.wr.data); free > 0 {
+			n := len(p) - written
+			if n > free {
+				n = free
+			}
+			c.wr.data = append(c.wr.data, p[written:written+n]...)
+			written += n
+			c.wr.broadcast()
+		}
+
+		if written == len(p) {
+			c.wr.lock.Unlock()
+			return written, nil
+		}
+		notify := c.wr.notify
+		c.wr.lock.Unlock()
+
+		select {
+		case <-notify:
+		case <-c.stopedChan:
+		case <-c.writeDeadline.wait():
+			return written, os.ErrDeadlineExceeded
+		}
+	}
+}
+
+// Close 关闭本端, 对端读完缓存后收到 io.EOF, 写入返回 io.ErrClosedPipe
+func (c *pipeConn) Close() error {
+	c.once.Do(func() {
+		close(c.stopedChan)
+		c.closeWrite()
+
+		c.rd.lock.Lock()
+		c.rd.readerClosed = true
+		c.rd.data = nil
+		c.rd.broadcast()
+		c.rd.lock.Unlock()
+
+		if c.recvPath != nil {
+			c.recvPath.remove()
+		}
+	})
+
+	return nil
+}
+
+// CloseWrite 关闭写方向, 对端读完缓存后收到 io.EOF
+func (c *pipeConn) CloseWrite() error {
+	c.closeWrite()
+	return nil
+}
+
+// closeWrite 模拟网络中 EOF 排在已写入的数据之后送达
+func (c *pipeConn) closeWrite() {
+	if c.path != nil {
+		c.path.transmit(nil)
+		return
+	}
+
+	c.wr.lock.Lock()
+	c.wr.writerClosed = true
+	c.wr.broadcast()
+	c.wr.lock.Unlock()
+}
+
+func (c *pipeConn) LocalAddr() net.Addr  { return c.local }
+func (c *pipeConn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *pipeConn) SetDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	c.writeDeadline.set(t)
+	return nil
+}
+
+func (c *pipeConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	return nil
+}
+
+func (c *pipeConn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.set(t)
+	return nil
+}
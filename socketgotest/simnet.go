@@ -0,0 +1,327 @@
+package socketgotest
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	socket "github.com/datochan/socketgo"
+)
+
+// ErrUnreachable 模拟网络中目标地址没有监听或两个节点之间已分区
+var ErrUnreachable = errors.New("socketgotest: destination unreachable")
+
+// Network 进程内的模拟网络。地址格式为 "节点:端口", 节点之间的数据按虚拟时钟经过设定的延迟送达;
+// 分区期间写入的数据被滞留, 恢复后按原顺序送达, 与 TCP 重传的表现一致, 因此分区只能由心跳超时发现。
+//
+//	clock := socketgotest.NewVirtualClock(time.Unix(0, 0))
+//	network := socketgotest.NewNetwork(clock)
+//	listener, _ := network.Listen("server:7190")
+//	client.SetDialer(network.Dialer("client1"))
+//	network.Partition("client1", "server")
+//	clock.Advance(time.Minute)
+type Network struct {
+	clock socket.Clock
+
+	lock      sync.Mutex
+	listeners map[string]*simListener
+	links     map[nodePair]*linkState
+	nextPort  int
+}
+
+type nodePair [2]string
+
+func pairOf(a, b string) nodePair {
+	if a > b {
+		a, b = b, a
+	}
+	return nodePair{a, b}
+}
+
+// linkState 两个节点之间的链路状态
+type linkState struct {
+	latency     time.Duration
+	partitioned bool
+	paths       []*simPath // 链路上未关闭的连接的两个方向, 送达 EOF 或对端关闭后移除
+}
+
+// NewNetwork 新建模拟网络, 传递数据与连接的读写截止时间都按 clock 计时
+func NewNetwork(clock socket.Clock) *Network {
+	return &Network{
+		clock:     clock,
+		listeners: make(map[string]*simListener),
+		links:     make(map[nodePair]*linkState),
+		nextPort:  40000,
+	}
+}
+
+// Listen 在 address("节点:端口")上监听
+func (n *Network) Listen(address string) (net.Listener, error) {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return nil, err
+	}
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if _, ok := n.listeners[address]; ok {
+		return nil, fmt.Errorf("socketgotest: address %s already in use", address)
+	}
+
+	l := &simListener{network: n, addr: Addr(address), conns: make(chan net.Conn, 16), stopedChan: make(chan struct{})}
+	n.listeners[address] = l
+	return l, nil
+}
+
+// Dialer 返回从节点 node 发起连接的 socket.Dialer
+func (n *Network) Dialer(node string) socket.Dialer {
+	return func(network, address string) (net.Conn, error) {
+		return n.dial(node, address)
+	}
+}
+
+// SetLatency 设置两个节点之间的单向延迟
+func (n *Network) SetLatency(a, b string, latency time.Duration) {
+	n.lock.Lock()
+	n.link(a, b).latency = latency
+	n.lock.Unlock()
+}
+
+// Partition 断开两个节点之间的链路: 新连接失败, 已有连接上的数据滞留到 Heal
+func (n *Network) Partition(a, b string) {
+	n.lock.Lock()
+	n.link(a, b).partitioned = true
+	n.lock.Unlock()
+}
+
+// Heal 恢复两个节点之间的链路, 滞留的数据按原顺序送达
+func (n *Network) Heal(a, b string) {
+	n.lock.Lock()
+	link := n.link(a, b)
+	link.partitioned = false
+	paths := append([]*simPath(nil), link.paths...)
+	n.lock.Unlock()
+
+	for _, path := range paths {
+		path.flush()
+	}
+}
+
+// link 获取两个节点之间的链路, 调用方需持有锁
+func (n *Network) link(a, b string) *linkState {
+	pair := pairOf(a, b)
+	link, ok := n.links[pair]
+	if !ok {
+		link = &linkState{}
+		n.links[pair] = link
+	}
+	return link
+}
+
+func (n *Network) dial(node, address string) (net.Conn, error) {
+	target, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	n.lock.Lock()
+	listener, ok := n.listeners[address]
+	link := n.link(node, target)
+	if !ok || link.partitioned {
+		n.lock.Unlock()
+		return nil, &net.OpError{Op: "dial", Net: "sim", Addr: Addr(address), Err: ErrUnreachable}
+	}
+
+	n.nextPort++
+	local := Addr(fmt.Sprintf("%s:%d", node, n.nextPort))
+	client, server := newPipe(local, listener.addr, DefaultPipeCapacity, n.clock)
+	client.path = &simPath{network: n, link: link, dst: server.rd}
+	server.path = &simPath{network: n, link: link, dst: client.rd}
+	client.recvPath, server.recvPath = server.path, client.path
+	link.paths = append(link.paths, client.path, server.path)
+	n.lock.Unlock()
+
+	select {
+	case listener.conns <- server:
+		return client, nil
+	case <-listener.stopedChan:
+		_ = client.Close()
+		return nil, &net.OpError{Op: "dial", Net: "sim", Addr: Addr(address), Err: ErrUnreachable}
+	}
+}
+
+// simPath 连接的一个方向, 数据按链路的延迟送达对端的读缓存
+type simPath struct {
+	network *Network
+	link    *linkState
+	dst     *pipeBuffer
+
+	wlock    sync.Mutex // 保证并发写入的数据不会交错
+	inflight int        // 已写入但尚未送达 dst 的字节数(包括分区期间滞留的), 由 dst.lock 保护
+
+	lock sync.Mutex
+	held [][]byte  // 分区期间滞留的数据, nil 元素表示 EOF
+	last time.Time // 上一段数据的送达时间, 保证按写入顺序送达
+}
+
+// write 在途与对端未读的数据共同占用对端读缓存的容量, 缓存满时阻塞, 与 TCP 的发送窗口类似;
+// 分区期间写满后同样阻塞, 直到恢复后对端读取
+func (p *simPath) write(c *pipeConn, data []byte) (int, error) {
+	p.wlock.Lock()
+	defer p.wlock.Unlock()
+
+	written := 0
+	for {
+		if isClosed(c.stopedChan) {
+			return written, net.ErrClosed
+		}
+
+		p.dst.lock.Lock()
+		if p.dst.readerClosed {
+			p.dst.lock.Unlock()
+			return written, io.ErrClosedPipe
+		}
+		if written == len(data) {
+			p.dst.lock.Unlock()
+			return written, nil
+		}
+
+		n := min(len(data)-written, p.dst.capacity-len(p.dst.data)-p.inflight)
+		if n > 0 {
+			p.inflight += n
+		}
+		notify := p.dst.notify
+		p.dst.lock.Unlock()
+
+		if n > 0 {
+			p.transmit(append([]byte(nil), data[written:written+n]...))
+			written += n
+			continue
+		}
+
+		select {
+		case <-notify:
+		case <-c.stopedChan:
+		case <-c.writeDeadline.wait():
+			return written, os.ErrDeadlineExceeded
+		}
+	}
+}
+
+// transmit 发送一段数据, data 为 nil 表示 EOF
+func (p *simPath) transmit(data []byte) {
+	p.network.lock.Lock()
+	partitioned, latency := p.link.partitioned, p.link.latency
+	p.network.lock.Unlock()
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if partitioned || len(p.held) > 0 {
+		p.held = append(p.held, data)
+		return
+	}
+
+	p.scheduleLocked(data, latency)
+}
+
+// flush 链路恢复后送达滞留的数据
+func (p *simPath) flush() {
+	p.network.lock.Lock()
+	latency := p.link.latency
+	p.network.lock.Unlock()
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for _, data := range p.held {
+		p.scheduleLocked(data, latency)
+	}
+	p.held = nil
+}
+
+func (p *simPath) scheduleLocked(data []byte, latency time.Duration) {
+	now := p.network.clock.Now()
+	at := now.Add(latency)
+	if at.Before(p.last) {
+		at = p.last
+	}
+	p.last = at
+
+	if delay := at.Sub(now); delay > 0 {
+		p.network.clock.AfterFunc(delay, func() { p.deliver(data) })
+	} else {
+		p.deliver(data)
+	}
+}
+
+func (p *simPath) deliver(data []byte) {
+	p.dst.lock.Lock()
+	p.inflight -= len(data)
+	finished := data == nil || p.dst.readerClosed
+
+	if !p.dst.readerClosed {
+		if data == nil {
+			p.dst.writerClosed = true
+		} else {
+			p.dst.data = append(p.dst.data, data...)
+		}
+		p.dst.broadcast()
+	}
+	p.dst.lock.Unlock()
+
+	if finished {
+		p.remove()
+	}
+}
+
+// remove 连接的这个方向不再传递数据, 从链路中移除
+func (p *simPath) remove() {
+	p.network.lock.Lock()
+	defer p.network.lock.Unlock()
+
+	for i, path := range p.link.paths {
+		if path == p {
+			p.link.paths = append(p.link.paths[:i], p.link.paths[i+1:]...)
+			return
+		}
+	}
+}
+
+// simListener 模拟网络中的 listener
+type simListener struct {
+	network *Network
+	addr    Addr
+	conns   chan net.Conn
+
+	once       sync.Once
+	stopedChan chan struct{}
+}
+
+func (l *simListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.stopedChan:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *simListener) Close() error {
+	l.once.Do(func() {
+		close(l.stopedChan)
+
+		l.network.lock.Lock()
+		delete(l.network.listeners, string(l.addr))
+		l.network.lock.Unlock()
+	})
+	return nil
+}
+
+func (l *simListener) Addr() net.Addr {
+	return l.addr
+}
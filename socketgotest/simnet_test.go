@@ -0,0 +1,367 @@
+package socketgotest
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	socket "github.com/datochan/socketgo"
+)
+
+func TestVirtualClockTimers(t *testing.T) {
+	start := time.Unix(0, 0)
+	clock := NewVirtualClock(start)
+
+	var fired []string
+	clock.AfterFunc(2*time.Second, func() { fired = append(fired, "b") })
+	clock.AfterFunc(time.Second, func() { fired = append(fired, "a1") })
+	clock.AfterFunc(time.Second, func() { fired = append(fired, "a2") })
+	stopped := clock.AfterFunc(time.Second, func() { fired = append(fired, "stopped") })
+	timer := clock.NewTimer(3 * time.Second)
+	ticker := clock.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	if !stopped.Stop() {
+		t.Fatal("Stop of a pending timer returned false")
+	}
+	if n := clock.Timers(); n != 5 {
+		t.Fatalf("Timers() = %d, want 5", n)
+	}
+
+	// 到期的定时器按到期时间、相同时按创建顺序触发
+	clock.Advance(2 * time.Second)
+	if got := len(fired); got != 3 || fired[0] != "a1" || fired[1] != "a2" || fired[2] != "b" {
+		t.Fatalf("fired %v, want [a1 a2 b]", fired)
+	}
+	if now := clock.Now(); !now.Equal(start.Add(2 * time.Second)) {
+		t.Fatalf("Now() = %v, want start+2s", now)
+	}
+
+	select {
+	case <-timer.C():
+		t.Fatal("timer fired before its deadline")
+	default:
+	}
+	clock.Advance(time.Second)
+	select {
+	case at := <-timer.C():
+		if !at.Equal(start.Add(3 * time.Second)) {
+			t.Fatalf("timer fired at %v, want start+3s", at)
+		}
+	default:
+		t.Fatal("timer did not fire at its deadline")
+	}
+
+	// Ticker 未被取走的触发不会累积
+	select {
+	case <-ticker.C():
+	default:
+		t.Fatal("ticker did not fire")
+	}
+	select {
+	case <-ticker.C():
+		t.Fatal("ticker accumulated ticks")
+	default:
+	}
+
+	// BlockUntil 等待其它 goroutine 注册定时器
+	done := make(chan struct{})
+	go func() {
+		<-clock.NewTimer(time.Minute).C()
+		close(done)
+	}()
+	clock.BlockUntil(2)
+	clock.Advance(time.Minute)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("goroutine timer did not fire after Advance")
+	}
+}
+
+// newSimPair 在模拟网络中由节点 client 连接 address, 返回两端的连接
+func newSimPair(t *testing.T, network *Network, address string) (net.Conn, net.Conn) {
+	t.Helper()
+
+	listener, err := network.Listen(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	client, err := network.Dialer("client")("sim", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = server.Close()
+	})
+
+	return client, server
+}
+
+// readAsync 在后台读取, 返回读到的数据
+func readAsync(conn net.Conn, size int) <-chan []byte {
+	results := make(chan []byte, 1)
+	go func() {
+		buf := make([]byte, size)
+		n, _ := io.ReadFull(conn, buf)
+		results <- buf[:n]
+	}()
+	return results
+}
+
+func expectNothing(t *testing.T, results <-chan []byte) {
+	t.Helper()
+
+	select {
+	case data := <-results:
+		t.Fatalf("read %q before it should be delivered", data)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestNetworkLatency(t *testing.T) {
+	clock := NewVirtualClock(time.Unix(0, 0))
+	network := NewNetwork(clock)
+	network.SetLatency("client", "server", 100*time.Millisecond)
+	client, server := newSimPair(t, network, "server:7190")
+
+	if _, err := client.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+
+	results := readAsync(server, 4)
+	clock.Advance(99 * time.Millisecond)
+	expectNothing(t, results)
+
+	clock.Advance(time.Millisecond)
+	if data := <-results; string(data) != "ping" {
+		t.Fatalf("read %q, want ping", data)
+	}
+}
+
+func TestNetworkBackpressure(t *testing.T) {
+	clock := NewVirtualClock(time.Unix(0, 0))
+	network := NewNetwork(clock)
+	network.SetLatency("client", "server", time.Second)
+	client, server := newSimPair(t, network, "server:7190")
+
+	// 在途的数据同样占用对端缓存, 对端不读取时写满容量后阻塞
+	_ = client.SetWriteDeadline(clock.Now().Add(10 * time.Second))
+	type result struct {
+		n   int
+		err error
+	}
+	results := make(chan result, 1)
+	go func() {
+		n, err := client.Write(make([]byte, 2*DefaultPipeCapacity))
+		results <- result{n, err}
+	}()
+
+	// 截止时间与送达的定时器
+	clock.BlockUntil(2)
+	clock.Advance(10 * time.Second)
+	if res := <-results; res.n != DefaultPipeCapacity || !errors.Is(res.err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Write = %d, %v, want %d, ErrDeadlineExceeded", res.n, res.err, DefaultPipeCapacity)
+	}
+
+	// 对端读取后腾出空间, 继续写入
+	if _, err := io.ReadFull(server, make([]byte, DefaultPipeCapacity)); err != nil {
+		t.Fatal(err)
+	}
+	_ = client.SetWriteDeadline(time.Time{})
+	if n, err := client.Write(make([]byte, DefaultPipeCapacity)); n != DefaultPipeCapacity || err != nil {
+		t.Fatalf("Write after the peer read = %d, %v", n, err)
+	}
+}
+
+func TestNetworkPartitionHeal(t *testing.T) {
+	clock := NewVirtualClock(time.Unix(0, 0))
+	network := NewNetwork(clock)
+	client, server := newSimPair(t, network, "server:7190")
+
+	network.Partition("client", "server")
+	if _, err := network.Dialer("client")("sim", "server:7190"); !errors.Is(err, ErrUnreachable) {
+		t.Fatalf("dial during partition = %v, want ErrUnreachable", err)
+	}
+
+	// 分区期间写入成功, 数据滞留到恢复后按原顺序送达
+	for _, data := range []string{"one", "two"} {
+		if _, err := client.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	results := readAsync(server, 6)
+	clock.Advance(time.Hour)
+	expectNothing(t, results)
+
+	network.Heal("client", "server")
+	if data := <-results; string(data) != "onetwo" {
+		t.Fatalf("read %q after heal, want onetwo", data)
+	}
+}
+
+func TestNetworkClosePrunesPaths(t *testing.T) {
+	clock := NewVirtualClock(time.Unix(0, 0))
+	network := NewNetwork(clock)
+	client, server := newSimPair(t, network, "server:7190")
+
+	paths := func() int {
+		network.lock.Lock()
+		defer network.lock.Unlock()
+		return len(network.link("client", "server").paths)
+	}
+	if n := paths(); n != 2 {
+		t.Fatalf("%d paths for an open connection, want 2", n)
+	}
+
+	_ = client.Close()
+	_ = server.Close()
+	if n := paths(); n != 0 {
+		t.Fatalf("%d paths left after both ends closed, want 0", n)
+	}
+
+	// 分区期间关闭的连接, 滞留的 EOF 在恢复后送达并移除
+	client, server = newSimPair(t, network, "server:7191")
+	network.Partition("client", "server")
+	_ = client.Close()
+	if n := paths(); n != 1 {
+		t.Fatalf("%d paths while the EOF is held, want 1", n)
+	}
+	network.Heal("client", "server")
+	if n := paths(); n != 0 {
+		t.Fatalf("%d paths after the held EOF was delivered, want 0", n)
+	}
+	if _, err := server.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("Read = %v, want io.EOF", err)
+	}
+}
+
+// pingProtocol 以事件100为心跳请求、事件101为心跳应答的测试协议
+type pingProtocol struct {
+	*socket.LengthFieldProtocol
+}
+
+func newPingProtocol() pingProtocol {
+	return pingProtocol{&socket.LengthFieldProtocol{HeaderSize: 8, LengthOffset: 4, LengthSize: 4, IDSize: 4, ByteOrder: binary.LittleEndian}}
+}
+
+func pingFrame(id uint32) []byte {
+	frame := make([]byte, 8)
+	binary.LittleEndian.PutUint32(frame, id)
+	return frame
+}
+
+func (p pingProtocol) PingPacket() interface{} {
+	return pingFrame(100)
+}
+
+func (p pingProtocol) IsPong(packet interface{}) bool {
+	return p.PacketID(packet) == 101
+}
+
+// servePongs 对 listener 接受的每个连接应答心跳
+func servePongs(listener net.Listener, protocol pingProtocol) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			defer conn.Close()
+			for {
+				packet, err := protocol.ReadPacket(conn)
+				if err != nil {
+					return
+				}
+				if protocol.PacketID(packet) == 100 {
+					if _, err = conn.Write(protocol.BuildPacket(pingFrame(101))); err != nil {
+						return
+					}
+				}
+			}
+		}()
+	}
+}
+
+// advanceUntil 逐秒推进虚拟时钟直到 cond 成立, 每步留出真实时间让被测 goroutine 运行
+func advanceUntil(t *testing.T, clock *VirtualClock, limit time.Duration, cond func() bool) time.Duration {
+	t.Helper()
+
+	var elapsed time.Duration
+	for elapsed <= limit {
+		if cond() {
+			return elapsed
+		}
+		clock.Advance(time.Second)
+		elapsed += time.Second
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("condition not met within %v of virtual time", limit)
+	return elapsed
+}
+
+func TestNetworkPartitionHeartbeatTimeout(t *testing.T) {
+	clock := NewVirtualClock(time.Unix(0, 0))
+	network := NewNetwork(clock)
+	protocol := newPingProtocol()
+
+	listener, err := network.Listen("server:7190")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go servePongs(listener, protocol)
+
+	const heartbeat, timeout = 10 * time.Second, 3 * time.Second
+	b := socket.NewBalancedClient([]string{"server:7190"}, protocol, socket.NewDispatcher(), socket.BalancedConfig{
+		HeartbeatInterval: heartbeat,
+		HeartbeatTimeout:  timeout,
+		MinBackoff:        time.Second,
+		MaxBackoff:        time.Second,
+		Dialer:            network.Dialer("client"),
+		Clock:             clock,
+	})
+	defer b.Close()
+
+	up := func() bool {
+		endpoints := b.Endpoints()
+		return len(endpoints) == 1 && endpoints[0].Up
+	}
+	down := func() bool { return !up() }
+
+	advanceUntil(t, clock, 5*time.Second, up)
+
+	// 链路正常时心跳按时应答, 节点保持可用
+	for elapsed := time.Duration(0); elapsed < 3*heartbeat; elapsed += time.Second {
+		clock.Advance(time.Second)
+		time.Sleep(time.Millisecond)
+	}
+	if !up() {
+		t.Fatal("endpoint went down while the link was healthy")
+	}
+
+	// 分区后连接本身不报错, 只能由心跳超时发现; 之后的重连因分区失败
+	network.Partition("client", "server")
+	if elapsed := advanceUntil(t, clock, heartbeat+timeout+5*time.Second, down); elapsed < timeout {
+		t.Fatalf("endpoint went down %v after the partition, before the heartbeat timeout", elapsed)
+	}
+	advanceUntil(t, clock, 5*time.Second, func() bool {
+		endpoints := b.Endpoints()
+		return len(endpoints) == 1 && errors.Is(endpoints[0].LastErr, ErrUnreachable)
+	})
+
+	network.Heal("client", "server")
+	advanceUntil(t, clock, 5*time.Second, up)
+}
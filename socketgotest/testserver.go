@@ -0,0 +1,178 @@
+package socketgotest
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	socket "github.com/datochan/socketgo"
+)
+
+// RecordedPacket TestServer 收到的封包
+type RecordedPacket struct {
+	Session socket.ISession
+	ID      uint32 // 协议实现了 socket.IPacketIdentifier 时为封包的事件ID, 否则为 0
+	Packet  interface{}
+	Time    time.Time
+}
+
+// TestServer 基于内存 listener 的测试服务端, 记录分发的每个封包, 再交给 AddHandler 注册的处理句柄。
+//
+//	ts := socketgotest.NewTestServer(t, protocol)
+//	client := ts.Dial(dispatcher)
+//	_ = client.Send(request)
+//	packet := ts.ExpectPacket(0x01, time.Second)
+type TestServer struct {
+	*socket.Server
+	Listener *Listener
+
+	t          testing.TB
+	protocol   socket.IPacketProtocol
+	dispatcher *recordDispatcher
+}
+
+// recordDispatcher 记录封包后按事件ID分发
+type recordDispatcher struct {
+	*socket.Dispatcher
+	identifier socket.IPacketIdentifier
+
+	lock     sync.Mutex
+	packets  []RecordedPacket
+	consumed []bool // 已被 ExpectPacket 取走的封包
+	notify   chan struct{}
+}
+
+func (d *recordDispatcher) HandleProc(session socket.ISession, packet interface{}) {
+	var id uint32
+	if d.identifier != nil {
+		id = d.identifier.PacketID(packet)
+	}
+
+	// 池化的封包在 handler 返回后会被归还, 记录期间需保留
+	if buf, ok := packet.(*socket.Buffer); ok {
+		buf.Retain()
+	}
+
+	d.lock.Lock()
+	d.packets = append(d.packets, RecordedPacket{Session: session, ID: id, Packet: packet, Time: time.Now()})
+	d.consumed = append(d.consumed, false)
+	close(d.notify)
+	d.notify = make(chan struct{})
+	d.lock.Unlock()
+
+	if handler := d.GetHandler(id); handler != nil {
+		handler(session, packet)
+	}
+}
+
+// NewTestServer 启动测试服务端, 测试结束时自动关闭
+func NewTestServer(t testing.TB, protocol socket.IPacketProtocol) *TestServer {
+	t.Helper()
+
+	dispatcher := &recordDispatcher{Dispatcher: socket.NewDispatcher(), notify: make(chan struct{})}
+	dispatcher.identifier, _ = protocol.(socket.IPacketIdentifier)
+
+	listener := NewListener(t.Name())
+	ts := &TestServer{
+		Server:     socket.NewServerWithListener(listener, protocol, dispatcher),
+		Listener:   listener,
+		t:          t,
+		protocol:   protocol,
+		dispatcher: dispatcher,
+	}
+
+	go ts.AcceptLoop()
+	t.Cleanup(ts.Close)
+
+	return ts
+}
+
+// Close 关闭服务端与所有会话, 并归还记录的池化封包
+func (ts *TestServer) Close() {
+	ts.Server.Close()
+
+	ts.dispatcher.lock.Lock()
+	defer ts.dispatcher.lock.Unlock()
+	for _, recorded := range ts.dispatcher.packets {
+		if buf, ok := recorded.Packet.(*socket.Buffer); ok {
+			buf.Release()
+		}
+	}
+	ts.dispatcher.packets = nil
+	ts.dispatcher.consumed = nil
+}
+
+// Dial 新建连接到测试服务端的异步客户端, 测试结束时自动关闭
+func (ts *TestServer) Dial(dispatcher socket.IDispatcher) *socket.AsyncClient {
+	ts.t.Helper()
+
+	client := socket.NewAsyncClient(ts.protocol, dispatcher, socket.DefaultSendChanSize)
+	client.SetDialer(ts.Listener.Dial)
+	if err := client.Conn("mem", ts.Listener.Addr().String(), 0, 0, nil, nil); err != nil {
+		ts.t.Fatalf("socketgotest: dial failed: %v", err)
+	}
+	ts.t.Cleanup(client.Close)
+
+	return client
+}
+
+// Packets 已收到的所有封包
+func (ts *TestServer) Packets() []RecordedPacket {
+	ts.dispatcher.lock.Lock()
+	defer ts.dispatcher.lock.Unlock()
+
+	return append([]RecordedPacket(nil), ts.dispatcher.packets...)
+}
+
+// ExpectPacket 等待一个事件ID为 id 且未被之前的 ExpectPacket 取走的封包, 超时则测试失败
+func (ts *TestServer) ExpectPacket(id uint32, timeout time.Duration) RecordedPacket {
+	ts.t.Helper()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		ts.dispatcher.lock.Lock()
+		for i, recorded := range ts.dispatcher.packets {
+			if recorded.ID == id && !ts.dispatcher.consumed[i] {
+				ts.dispatcher.consumed[i] = true
+				ts.dispatcher.lock.Unlock()
+				return recorded
+			}
+		}
+		notify := ts.dispatcher.notify
+		ts.dispatcher.lock.Unlock()
+
+		select {
+		case <-notify:
+		case <-timer.C:
+			ts.t.Fatalf("socketgotest: no packet with id %#x within %v", id, timeout)
+			return RecordedPacket{}
+		}
+	}
+}
+
+// ExpectNoPacket 在 wait 时间内不应收到事件ID为 id 的新封包
+func (ts *TestServer) ExpectNoPacket(id uint32, wait time.Duration) {
+	ts.t.Helper()
+
+	deadline := time.Now().Add(wait)
+	for {
+		ts.dispatcher.lock.Lock()
+		for i, recorded := range ts.dispatcher.packets {
+			if recorded.ID == id && !ts.dispatcher.consumed[i] {
+				ts.dispatcher.lock.Unlock()
+				ts.t.Fatalf("socketgotest: unexpected packet with id %#x", id)
+				return
+			}
+		}
+		notify := ts.dispatcher.notify
+		ts.dispatcher.lock.Unlock()
+
+		select {
+		case <-notify:
+		case <-time.After(time.Until(deadline)):
+			return
+		}
+	}
+}